@@ -5,18 +5,22 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/phanthehoang2503/small-project/auth-service/internal/handler"
 	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/oidc"
 	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
 	"github.com/phanthehoang2503/small-project/auth-service/internal/router"
 	"github.com/phanthehoang2503/small-project/internal/database"
+	"github.com/phanthehoang2503/small-project/internal/event"
 	"github.com/phanthehoang2503/small-project/internal/helper"
 	"github.com/phanthehoang2503/small-project/internal/logger"
 	"github.com/phanthehoang2503/small-project/internal/middleware"
+	"github.com/phanthehoang2503/small-project/internal/serviceauth"
 	"github.com/phanthehoang2503/small-project/internal/telemetry"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
@@ -52,12 +56,48 @@ func main() {
 	logger.SetService("auth-service")
 
 	userRepo := repo.NewUserRepo(db)
-	if err := db.AutoMigrate(&model.User{}); err != nil {
+	if err := db.AutoMigrate(&model.User{}, &model.RefreshToken{}, &model.ActionToken{}, &model.TwoFactor{}, &model.RecoveryCode{}, &model.Session{}, &model.ExternalIdentity{}, &model.OIDCLogin{}); err != nil {
 		log.Fatalf("Migration failed: %v", err)
 	}
+	if err := repo.RenameAnonymized(db); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	// revoked access tokens are kept in Redis until they expire
+	var denylist middleware.Denylist
+	if d := helper.UseTokenDenylist(); d != nil {
+		denylist = d
+	}
+
+	// ACCESS_TOKEN_TTL: access token lifetime (default 15m);
+	// REFRESH_TOKEN_TTL: how long a session lasts without refresh (default 720h)
+	accessTTL, _ := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL"))
+	refreshTTL, _ := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL"))
+
+	// access tokens are signed with the keys in JWT_SIGNING_KEYS_DIR and
+	// verified here with the same keys, without fetching our own JWKS
+	signer := helper.TokenSigner()
 
-	jwtSecret := []byte(os.Getenv("JWT_SECRET"))
-	authHandler := handler.NewAuthHandler(userRepo, jwtSecret, 72)
+	// user events carry a service token of auth-service, signed here
+	b.AuthenticateWith(serviceauth.MessageToken(func(ctx context.Context) (string, error) {
+		token, _, err := signer.IssueService("auth-service", []string{event.AudienceEvents}, 0)
+		return token, err
+	}))
+	authHandler := handler.NewAuthHandler(userRepo, repo.NewTokenRepo(db), repo.NewActionTokenRepo(db), repo.NewTwoFactorRepo(db), denylist, signer, emailFlows(), accessTTL, refreshTTL)
+
+	// ADMIN_EMAILS: comma-separated emails made admin, once verified
+	authHandler.AdminEmails = adminEmails()
+	authHandler.PromoteAdmins()
+
+	// SERVICE_CLIENTS: which services may get service tokens, and for whom
+	authHandler.ServiceClients = serviceClients(authHandler.Signer.Audience)
+
+	// OIDC_PROVIDERS: external providers users may sign in with
+	authHandler.OIDCProviders = oidcProviders()
+	authHandler.Identities = repo.NewIdentityRepo(db)
+
+	// TOTP_ISSUER: the account name shown in authenticator apps
+	authHandler.TOTPIssuer = os.Getenv("TOTP_ISSUER")
 
 	// Connect Redis for Rate Limiting
 	redisAddr := os.Getenv("REDIS_URL")
@@ -65,6 +105,9 @@ func main() {
 		Addr: redisAddr,
 	})
 
+	// failed logins delay, then lock, the account (see loginPolicy)
+	authHandler.Attempts = repo.NewLoginAttempts(rdb, loginPolicy())
+
 	r := gin.Default()
 	r.Use(otelgin.Middleware("auth-service"))
 	r.Use(middleware.CORSMiddleware())
@@ -77,7 +120,7 @@ func main() {
 	}
 	loginLimiter := middleware.RateLimitMiddleware(rdb, limit, time.Minute)
 
-	router.RegisterRoutes(r, authHandler, jwtSecret, loginLimiter)
+	router.RegisterRoutes(r, authHandler, middleware.NewLocalVerifier(signer), loginLimiter)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	r.Run(":8084")
@@ -90,3 +133,134 @@ func DbConnect() *gorm.DB {
 	}
 	return db
 }
+
+func adminEmails() []string {
+	var emails []string
+	for _, e := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			emails = append(emails, e)
+		}
+	}
+	return emails
+}
+
+// serviceClients reads SERVICE_CLIENTS, a comma-separated list of
+// client:audience|audience... entries, e.g.
+// "order-service:cart-service,cart-service:product-service". Each client's
+// secret is its <CLIENT>_CLIENT_SECRET; clients without one are skipped.
+func serviceClients(userAudience string) map[string]handler.ServiceClient {
+	clients := map[string]handler.ServiceClient{}
+	for _, entry := range strings.Split(os.Getenv("SERVICE_CLIENTS"), ",") {
+		id, auds, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if id == "" {
+			continue
+		}
+		client := handler.ServiceClient{ID: id, Secret: helper.ServiceClientSecret(id)}
+		if client.Secret == "" {
+			log.Printf("SERVICE_CLIENTS: no client secret for %s, skipped", id)
+			continue
+		}
+		for _, aud := range strings.Split(auds, "|") {
+			// service tokens must never pass as user access tokens
+			if aud = strings.TrimSpace(aud); aud != "" && aud != userAudience {
+				client.Audiences = append(client.Audiences, aud)
+			}
+		}
+		if len(client.Audiences) == 0 {
+			log.Printf("SERVICE_CLIENTS: %s may call no service, skipped", id)
+			continue
+		}
+		clients[id] = client
+	}
+	return clients
+}
+
+// oidcProviders reads OIDC_PROVIDERS, a comma-separated list of provider
+// names, e.g. "google,keycloak". Each provider is configured with
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET
+// (optional for public clients) and OIDC_<NAME>_SCOPES (default "openid
+// email profile"). The callback is OIDC_REDIRECT_URL with {provider}
+// replaced by the name.
+func oidcProviders() map[string]*oidc.Provider {
+	redirect := os.Getenv("OIDC_REDIRECT_URL")
+	if redirect == "" {
+		redirect = "http://localhost:8888/auth/oidc/{provider}/callback"
+	}
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg := oidc.Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  strings.ReplaceAll(redirect, "{provider}", name),
+			Scopes:       strings.Fields(strings.ReplaceAll(os.Getenv(prefix+"SCOPES"), ",", " ")),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			log.Printf("OIDC_PROVIDERS: no %sISSUER or %sCLIENT_ID for %s, skipped", prefix, prefix, name)
+			continue
+		}
+		providers[name] = oidc.NewProvider(cfg)
+	}
+	return providers
+}
+
+// emailFlows reads the email verification and password reset settings:
+// REQUIRE_EMAIL_VERIFICATION (false), EMAIL_VERIFICATION_TTL (48h),
+// PASSWORD_RESET_TTL (1h) and the links VERIFY_EMAIL_URL, PASSWORD_RESET_URL
+// and CHANGE_EMAIL_URL.
+func emailFlows() handler.EmailFlows {
+	verifyTTL, _ := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL"))
+	resetTTL, _ := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL"))
+	verifyURL := os.Getenv("VERIFY_EMAIL_URL")
+	if verifyURL == "" {
+		verifyURL = "http://localhost:8888/auth/verify-email"
+	}
+	resetURL := os.Getenv("PASSWORD_RESET_URL")
+	if resetURL == "" {
+		resetURL = "http://localhost:8888/auth/password/reset"
+	}
+	changeEmailURL := os.Getenv("CHANGE_EMAIL_URL")
+	if changeEmailURL == "" {
+		changeEmailURL = "http://localhost:8888/users/email/confirm"
+	}
+	return handler.EmailFlows{
+		RequireVerified: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+		VerifyTTL:       verifyTTL,
+		ResetTTL:        resetTTL,
+		VerifyURL:       verifyURL,
+		ResetURL:        resetURL,
+		ChangeEmailURL:  changeEmailURL,
+	}
+}
+
+// loginPolicy reads the failed login policy, defaulting each setting to
+// repo.DefaultLoginPolicy: LOGIN_DELAY_AFTER, LOGIN_LOCKOUT_AFTER,
+// LOGIN_LOCKOUT_DURATION, LOGIN_IP_DELAY_AFTER, LOGIN_MAX_DELAY and
+// LOGIN_FAILURE_WINDOW.
+func loginPolicy() repo.LoginPolicy {
+	p := repo.DefaultLoginPolicy
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_DELAY_AFTER")); err == nil && n > 0 {
+		p.DelayAfter = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_AFTER")); err == nil && n > 0 {
+		p.LockoutAfter = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_IP_DELAY_AFTER")); err == nil && n > 0 {
+		p.IPDelayAfter = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_DURATION")); err == nil && d > 0 {
+		p.LockoutFor = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_MAX_DELAY")); err == nil && d > 0 {
+		p.MaxDelay = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_FAILURE_WINDOW")); err == nil && d > 0 {
+		p.Window = d
+	}
+	return p
+}
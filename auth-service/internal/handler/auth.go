@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -8,8 +9,12 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/oidc"
 	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/broker"
+	"github.com/phanthehoang2503/small-project/internal/event"
 	logger "github.com/phanthehoang2503/small-project/internal/logger"
+	"github.com/phanthehoang2503/small-project/internal/message"
 	"github.com/phanthehoang2503/small-project/internal/middleware"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -26,9 +31,27 @@ type loginReq struct {
 }
 
 type AuthHandler struct {
-	Repo      repo.UserRepo
-	jwtSecret []byte
-	jwtExp    time.Duration
+	Repo         repo.UserRepo
+	Tokens       *repo.TokenRepo
+	ActionTokens *repo.ActionTokenRepo
+	TwoFactor    *repo.TwoFactorRepo
+	Denylist     middleware.Denylist // nil when revocation is not checked
+	Signer       *middleware.Signer
+	Email        EmailFlows
+	Attempts     *repo.LoginAttempts // nil disables lockout
+	TOTPIssuer   string              // shown in authenticator apps
+	verifier     *middleware.Verifier
+	accessTTL    time.Duration
+	refreshTTL   time.Duration
+
+	// AdminEmails get the admin role when they register
+	AdminEmails []string
+	// ServiceClients may get service tokens, by client ID
+	ServiceClients map[string]ServiceClient
+	// OIDCProviders users may sign in with, by name; Identities links
+	// their accounts there to ours
+	OIDCProviders map[string]*oidc.Provider
+	Identities    *repo.IdentityRepo
 }
 
 type registerResp struct {
@@ -38,25 +61,44 @@ type registerResp struct {
 }
 
 type loginResp struct {
-	Token    string `json:"token"`
-	ID       uint   `json:"id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
+	Token        string   `json:"token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in" example:"900"` // access token lifetime in seconds
+	ID           uint     `json:"id"`
+	Email        string   `json:"email"`
+	Username     string   `json:"username"`
+	Roles        []string `json:"roles"`
 }
 
 type errorResp struct {
 	Error string `json:"error"`
 }
 
-func NewAuthHandler(r repo.UserRepo, secret []byte, expHours int) *AuthHandler {
-	if expHours <= 0 {
-		expHours = 72
+func NewAuthHandler(r repo.UserRepo, tokens *repo.TokenRepo, actionTokens *repo.ActionTokenRepo, twoFactor *repo.TwoFactorRepo, denylist middleware.Denylist, signer *middleware.Signer, email EmailFlows, accessTTL, refreshTTL time.Duration) *AuthHandler {
+	if accessTTL <= 0 {
+		accessTTL = middleware.DefaultAccessTokenTTL
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTokenTTL
+	}
+	if email.VerifyTTL <= 0 {
+		email.VerifyTTL = DefaultVerifyEmailTTL
+	}
+	if email.ResetTTL <= 0 {
+		email.ResetTTL = DefaultResetPasswordTTL
 	}
 
 	return &AuthHandler{
-		Repo:      r,
-		jwtSecret: secret,
-		jwtExp:    time.Duration(expHours) * time.Hour,
+		Repo:         r,
+		Tokens:       tokens,
+		ActionTokens: actionTokens,
+		TwoFactor:    twoFactor,
+		Denylist:     denylist,
+		Signer:       signer,
+		Email:        email,
+		verifier:     middleware.NewLocalVerifier(signer),
+		accessTTL:    accessTTL,
+		refreshTTL:   refreshTTL,
 	}
 }
 
@@ -123,6 +165,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		Email:    req.Email,
 		Username: req.Username,
 		Password: string(hashed),
+		Roles:    h.initialRoles(req.Email, false),
 	}
 
 	if err := h.Repo.Create(user); err != nil {
@@ -133,6 +176,11 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 	logger.Info(ctx, fmt.Sprintf("register: user created (trace_id=%s, id=%d, email=%s, username=%s)", traceID, user.ID, user.Email, user.Username))
 
+	// the user can ask for another link if this one is lost
+	if err := h.sendVerification(ctx, user, event.RoutingKeyUserRegistered); err != nil {
+		logger.Error(ctx, fmt.Sprintf("register: failed to publish user.registered (trace_id=%s, id=%d, err=%v)", traceID, user.ID, err))
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"id":       user.ID,
 		"email":    user.Email,
@@ -145,10 +193,14 @@ func (h *AuthHandler) Register(c *gin.Context) {
 // @Tags Auth
 // @Accept json
 // @Produce json
+// @Param X-Cart-Token header string false "Guest cart token to merge into the user's cart"
 // @Param payload body loginReq true "Login payload"
-// @Success 200 {object} loginResp
+// @Success 200 {object} loginResp "logged in, or twoFactorChallengeResp when the user has 2FA on"
 // @Failure 400 {object} errorResp
 // @Failure 401 {object} errorResp
+// @Failure 403 {object} errorResp "email not verified, when verification is required"
+// @Failure 423 {object} errorResp "account locked after too many failed logins"
+// @Failure 429 {object} errorResp "retry too soon after failed logins"
 // @Failure 500 {object} errorResp
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -163,31 +215,86 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	u, err := h.Repo.GetUser(req.Login)
+	// only an unknown login is a failed attempt; a database error is ours
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		logger.Error(ctx, fmt.Sprintf("login: failed to load user (trace_id=%s, login=%s, err=%v)", traceID, req.Login, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	var userID uint
+	if u != nil {
+		userID = u.ID
+	}
+	account := repo.AccountKey(userID, req.Login)
+	if h.throttled(c, account) {
+		logger.Info(ctx, fmt.Sprintf("login: throttled (trace_id=%s, account=%s, ip=%s)", traceID, account, c.ClientIP()))
+		return
+	}
+
 	if err != nil {
-		logger.Info(ctx, fmt.Sprintf("login: user not found or repo error (trace_id=%s, login=%s, err=%v)", traceID, req.Login, err))
+		logger.Info(ctx, fmt.Sprintf("login: user not found (trace_id=%s, login=%s)", traceID, req.Login))
+		h.loginFailed(c, nil, account)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(req.Password)); err != nil {
 		logger.Info(ctx, fmt.Sprintf("login: invalid password (trace_id=%s, user_id=%d, login=%s)", traceID, u.ID, req.Login))
+		h.loginFailed(c, u, account)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
-	token, err := middleware.GenerateToken(h.jwtSecret, u.ID, int(h.jwtExp.Hours()))
+	if h.Email.RequireVerified && u.EmailVerifiedAt == nil {
+		logger.Info(ctx, fmt.Sprintf("login: email not verified (trace_id=%s, user_id=%d)", traceID, u.ID))
+		c.JSON(http.StatusForbidden, gin.H{"error": "email not verified", "code": "email_not_verified"})
+		return
+	}
+
+	// with 2FA on, the password only earns a challenge for the code
+	if on, err := h.TwoFactor.Enabled(u.ID); err != nil {
+		logger.Error(ctx, fmt.Sprintf("login: failed to check 2fa (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	} else if on {
+		h.challengeTwoFactor(c, u)
+		return
+	}
+
+	h.completeLogin(c, u)
+}
+
+// completeLogin issues the user's tokens once every factor has been checked.
+func (h *AuthHandler) completeLogin(c *gin.Context, u *model.User) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	h.loginSucceeded(c, repo.AccountKey(u.ID, ""))
+
+	pair, err := h.issueTokens(c, u)
 	if err != nil {
 		logger.Error(ctx, fmt.Sprintf("login: failed to create token (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
 		return
 	}
 
+	// let cart-service pick up the guest cart, if the client had one
+	if err := broker.PublishJSON(ctx, event.ExchangeUser, event.RoutingKeyUserLoggedIn, message.UserLoggedIn{
+		UserID:    u.ID,
+		Email:     u.Email,
+		CartToken: c.GetHeader("X-Cart-Token"),
+	}); err != nil {
+		logger.Error(ctx, fmt.Sprintf("login: failed to publish user.logged_in (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+	}
+
 	logger.Info(ctx, fmt.Sprintf("login: success (trace_id=%s, user_id=%d, email=%s, username=%s)", traceID, u.ID, u.Email, u.Username))
 
-	c.JSON(http.StatusOK, gin.H{
-		"token":    token,
-		"id":       u.ID,
-		"email":    u.Email,
-		"username": u.Username,
+	c.JSON(http.StatusOK, loginResp{
+		Token:        pair.Token,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    pair.ExpiresIn,
+		ID:           u.ID,
+		Email:        u.Email,
+		Username:     u.Username,
+		Roles:        rolesOf(u.Roles),
 	})
 }
@@ -0,0 +1,309 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/broker"
+	"github.com/phanthehoang2503/small-project/internal/event"
+	logger "github.com/phanthehoang2503/small-project/internal/logger"
+	"github.com/phanthehoang2503/small-project/internal/message"
+)
+
+// Default lifetimes of emailed tokens
+const (
+	DefaultVerifyEmailTTL   = 48 * time.Hour
+	DefaultResetPasswordTTL = time.Hour
+)
+
+// EmailFlows configures email verification and password reset.
+type EmailFlows struct {
+	RequireVerified bool // refuse login until the email is verified
+	VerifyTTL       time.Duration
+	ResetTTL        time.Duration
+	VerifyURL       string // link in the verification email, ?token= is appended
+	ResetURL        string // link in the reset email, ?token= is appended
+	ChangeEmailURL  string // link sent to a new address, ?token= is appended
+}
+
+type tokenReq struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type emailReq struct {
+	Email string `json:"email" binding:"required,email" example:"user@example.com"`
+}
+
+type resetPasswordReq struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required" example:"newsecret123"`
+}
+
+type messageResp struct {
+	Message string `json:"message"`
+}
+
+// issueActionToken signs a single-use token for purpose, sent to u's email.
+// The purpose is the token's audience so it cannot pass as an access token.
+func (h *AuthHandler) issueActionToken(u *model.User, purpose string, ttl time.Duration) (string, time.Time, error) {
+	claims := h.Signer.NewClaims(u.ID, purpose, ttl)
+	token, err := h.Signer.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := h.ActionTokens.Create(&model.ActionToken{
+		ID:        claims.ID,
+		UserID:    u.ID,
+		Purpose:   purpose,
+		Email:     u.Email,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, claims.ExpiresAt.Time, nil
+}
+
+// useActionToken checks the token's signature and marks it used.
+func (h *AuthHandler) useActionToken(ctx context.Context, token, purpose string) (*model.ActionToken, error) {
+	claims, err := h.verifier.ParseFor(ctx, token, purpose)
+	if err != nil {
+		return nil, repo.ErrActionTokenInvalid
+	}
+	return h.ActionTokens.Use(claims.ID, purpose)
+}
+
+// sendVerification publishes the verification email for u under routingKey.
+func (h *AuthHandler) sendVerification(ctx context.Context, u *model.User, routingKey string) error {
+	token, expiresAt, err := h.issueActionToken(u, model.PurposeVerifyEmail, h.Email.VerifyTTL)
+	if err != nil {
+		return err
+	}
+	return broker.PublishJSON(ctx, event.ExchangeUser, routingKey, message.UserRegistered{
+		UserID:            u.ID,
+		Email:             u.Email,
+		Username:          u.Username,
+		VerificationToken: token,
+		VerifyURL:         withToken(h.Email.VerifyURL, token),
+		ExpiresAt:         expiresAt,
+	})
+}
+
+// withToken appends the token to the link's query
+func withToken(link, token string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func actionTokenStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, repo.ErrActionTokenInvalid), errors.Is(err, repo.ErrActionTokenUsed), errors.Is(err, repo.ErrActionTokenExpired):
+		return http.StatusBadRequest, err.Error()
+	}
+	return http.StatusInternalServerError, "internal error"
+}
+
+// VerifyEmail godoc
+// @Summary Confirm an email address
+// @Description Uses the token from the verification email, sent as ?token= (the emailed link) or in the body.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param token query string false "Verification token"
+// @Param payload body tokenReq false "Verification token"
+// @Success 200 {object} messageResp
+// @Failure 400 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/verify-email [post]
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	token := c.Query("token")
+	if token == "" {
+		var req tokenReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		token = req.Token
+	}
+
+	t, err := h.useActionToken(ctx, token, model.PurposeVerifyEmail)
+	if err != nil {
+		status, msg := actionTokenStatus(err)
+		if status == http.StatusInternalServerError {
+			logger.Error(ctx, fmt.Sprintf("verify-email: failed to use token (trace_id=%s, err=%v)", traceID, err))
+		}
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.Repo.MarkEmailVerified(t.UserID, t.Email); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the email address changed since this link was sent"})
+			return
+		}
+		logger.Error(ctx, fmt.Sprintf("verify-email: failed to mark verified (trace_id=%s, user_id=%d, err=%v)", traceID, t.UserID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	h.promoteAdmin(ctx, t.UserID, t.Email)
+
+	logger.Info(ctx, fmt.Sprintf("verify-email: email verified (trace_id=%s, user_id=%d)", traceID, t.UserID))
+	c.JSON(http.StatusOK, messageResp{Message: "email verified"})
+}
+
+// ResendVerification godoc
+// @Summary Send a new verification email
+// @Description Always answers 202, whether or not the address belongs to an unverified account.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body emailReq true "Email"
+// @Success 202 {object} messageResp
+// @Failure 400 {object} errorResp
+// @Router /auth/verify-email/resend [post]
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	var req emailReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if u, err := h.Repo.GetUser(req.Email); err == nil && u.EmailVerifiedAt == nil {
+		if err := h.sendVerification(ctx, u, event.RoutingKeyUserVerificationRequested); err != nil {
+			logger.Error(ctx, fmt.Sprintf("verify-email: failed to send verification (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		}
+	}
+
+	c.JSON(http.StatusAccepted, messageResp{Message: "if the address needs verifying, an email is on its way"})
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset email
+// @Description Always answers 202, whether or not the address has an account.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body emailReq true "Email"
+// @Success 202 {object} messageResp
+// @Failure 400 {object} errorResp
+// @Router /auth/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	var req emailReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if u, err := h.Repo.GetUser(req.Email); err == nil {
+		token, expiresAt, err := h.issueActionToken(u, model.PurposeResetPassword, h.Email.ResetTTL)
+		if err == nil {
+			err = broker.PublishJSON(ctx, event.ExchangeUser, event.RoutingKeyUserPasswordResetRequested, message.PasswordResetRequested{
+				UserID:     u.ID,
+				Email:      u.Email,
+				ResetToken: token,
+				ResetURL:   withToken(h.Email.ResetURL, token),
+				ExpiresAt:  expiresAt,
+			})
+		}
+		if err != nil {
+			logger.Error(ctx, fmt.Sprintf("forgot-password: failed to send reset email (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		} else {
+			logger.Info(ctx, fmt.Sprintf("forgot-password: reset requested (trace_id=%s, user_id=%d)", traceID, u.ID))
+		}
+	}
+
+	c.JSON(http.StatusAccepted, messageResp{Message: "if the address has an account, a reset email is on its way"})
+}
+
+// ResetPassword godoc
+// @Summary Reset a forgotten password
+// @Description Sets a new password with the token from the reset email. Every session of the user is signed out.
+// @Tags Auth
+// @Accept json
+// @Param payload body resetPasswordReq true "Reset token and new password"
+// @Success 204
+// @Failure 400 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/password/reset [post]
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	var req resetPasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	t, err := h.useActionToken(ctx, req.Token, model.PurposeResetPassword)
+	if err != nil {
+		status, msg := actionTokenStatus(err)
+		if status == http.StatusInternalServerError {
+			logger.Error(ctx, fmt.Sprintf("reset-password: failed to use token (trace_id=%s, err=%v)", traceID, err))
+		}
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("reset-password: failed to hash password (trace_id=%s, err=%v)", traceID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+	if err := h.Repo.UpdatePassword(t.UserID, string(hashed)); err != nil {
+		logger.Error(ctx, fmt.Sprintf("reset-password: failed to update password (trace_id=%s, user_id=%d, err=%v)", traceID, t.UserID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	// older reset links die with this one
+	if err := h.ActionTokens.RevokeAll(t.UserID, model.PurposeResetPassword); err != nil {
+		logger.Error(ctx, fmt.Sprintf("reset-password: failed to revoke reset tokens (trace_id=%s, user_id=%d, err=%v)", traceID, t.UserID, err))
+	}
+	// whoever knew the old password is signed out
+	revoked, err := h.Tokens.RevokeAllForUser(t.UserID)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("reset-password: failed to revoke sessions (trace_id=%s, user_id=%d, err=%v)", traceID, t.UserID, err))
+	}
+	h.revokeAccess(ctx, revoked)
+	// the owner proved themselves, so a lockout from guessing is lifted
+	if h.Attempts != nil {
+		if err := h.Attempts.Unlock(ctx, repo.AccountKey(t.UserID, "")); err != nil {
+			logger.Error(ctx, fmt.Sprintf("reset-password: failed to unlock account (trace_id=%s, user_id=%d, err=%v)", traceID, t.UserID, err))
+		}
+	}
+	// receiving the email proves the address, unless it changed meanwhile
+	if err := h.Repo.MarkEmailVerified(t.UserID, t.Email); err == nil {
+		h.promoteAdmin(ctx, t.UserID, t.Email)
+	} else if !errors.Is(err, repo.ErrNotFound) {
+		logger.Error(ctx, fmt.Sprintf("reset-password: failed to mark email verified (trace_id=%s, user_id=%d, err=%v)", traceID, t.UserID, err))
+	}
+
+	logger.Info(ctx, fmt.Sprintf("reset-password: password reset (trace_id=%s, user_id=%d)", traceID, t.UserID))
+	c.Status(http.StatusNoContent)
+}
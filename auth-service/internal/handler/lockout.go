@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/broker"
+	"github.com/phanthehoang2503/small-project/internal/event"
+	logger "github.com/phanthehoang2503/small-project/internal/logger"
+	"github.com/phanthehoang2503/small-project/internal/message"
+)
+
+// throttled answers the request and returns true when the account is locked
+// or the client must wait before trying again. Redis errors let it through.
+func (h *AuthHandler) throttled(c *gin.Context, account string) bool {
+	if h.Attempts == nil {
+		return false
+	}
+	ctx := c.Request.Context()
+
+	wait, locked, err := h.Attempts.Check(ctx, account, c.ClientIP())
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("login: failed to check attempts (trace_id=%s, err=%v)", getTraceID(c), err))
+		return false
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	secs := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(secs))
+	if locked {
+		c.JSON(http.StatusLocked, gin.H{"error": "account temporarily locked after too many failed logins", "code": "account_locked", "retry_after": secs})
+		return true
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed logins, try again later", "code": "login_delayed", "retry_after": secs})
+	return true
+}
+
+// loginFailed records a failed login on account; u is nil for unknown logins.
+// The owner of an account that gets locked is told by email.
+func (h *AuthHandler) loginFailed(c *gin.Context, u *model.User, account string) {
+	if h.Attempts == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	locked, err := h.Attempts.Failed(ctx, account, c.ClientIP())
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("login: failed to record attempt (trace_id=%s, err=%v)", traceID, err))
+		return
+	}
+	if !locked {
+		return
+	}
+
+	logger.Warn(ctx, fmt.Sprintf("login: account locked (trace_id=%s, account=%s, ip=%s)", traceID, account, c.ClientIP()))
+	if u == nil {
+		return
+	}
+	if err := broker.PublishJSON(ctx, event.ExchangeUser, event.RoutingKeyUserLockedOut, message.UserLockedOut{
+		UserID: u.ID,
+		Email:  u.Email,
+		IP:     c.ClientIP(),
+		Until:  time.Now().Add(h.Attempts.LockoutFor()),
+	}); err != nil {
+		logger.Error(ctx, fmt.Sprintf("login: failed to publish user.locked_out (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+	}
+}
+
+// loginSucceeded forgets the account's failures.
+func (h *AuthHandler) loginSucceeded(c *gin.Context, account string) {
+	if h.Attempts == nil {
+		return
+	}
+	if err := h.Attempts.Succeeded(c.Request.Context(), account); err != nil {
+		logger.Error(c.Request.Context(), fmt.Sprintf("login: failed to reset attempts (trace_id=%s, err=%v)", getTraceID(c), err))
+	}
+}
+
+// UnlockUser godoc
+// @Summary Unlock a user's account
+// @Description Lifts a lockout from failed logins and forgets the failures.
+// @Tags Users
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 204
+// @Failure 400 {object} errorResp
+// @Failure 403 {object} errorResp
+// @Failure 404 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/users/{id}/lockout [delete]
+func (h *AuthHandler) UnlockUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	if _, err := h.Repo.GetByID(uint(id)); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	if h.Attempts != nil {
+		if err := h.Attempts.Unlock(ctx, repo.AccountKey(uint(id), "")); err != nil {
+			logger.Error(ctx, fmt.Sprintf("unlock: failed to unlock (trace_id=%s, user_id=%d, err=%v)", traceID, id, err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+	}
+
+	logger.Info(ctx, fmt.Sprintf("unlock: account unlocked (trace_id=%s, user_id=%d, by=%d)", traceID, id, c.GetUint("user_id")))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,336 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/oidc"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
+	logger "github.com/phanthehoang2503/small-project/internal/logger"
+)
+
+// OIDCLoginTTL is how long the user has to sign in at the provider.
+const OIDCLoginTTL = 10 * time.Minute
+
+// oidcLoginCookie holds a secret of the browser that started a sign-in. The
+// callback requires it, so a code and state obtained by someone else cannot
+// be completed in a victim's browser (login CSRF).
+const (
+	oidcLoginCookie = "oidc_login"
+	oidcCookiePath  = "/auth/oidc"
+)
+
+type oidcCallbackReq struct {
+	Code  string `json:"code" form:"code" binding:"required"`
+	State string `json:"state" form:"state" binding:"required"`
+}
+
+type oidcProvidersResp struct {
+	Providers []string `json:"providers" example:"google"`
+}
+
+var nonAlnum = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+func (h *AuthHandler) oidcProvider(c *gin.Context) (*oidc.Provider, bool) {
+	p, ok := h.OIDCProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown login provider"})
+	}
+	return p, ok
+}
+
+// ListOIDCProviders godoc
+// @Summary External login providers
+// @Description Names of the OpenID Connect providers users can sign in with
+// @Tags OIDC
+// @Produce json
+// @Success 200 {object} oidcProvidersResp
+// @Router /auth/oidc/providers [get]
+func (h *AuthHandler) ListOIDCProviders(c *gin.Context) {
+	names := make([]string, 0, len(h.OIDCProviders))
+	for name := range h.OIDCProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.JSON(http.StatusOK, oidcProvidersResp{Providers: names})
+}
+
+// OIDCLogin godoc
+// @Summary Sign in with an external provider
+// @Description Redirects to the provider's sign-in page (authorization code flow with PKCE). The provider sends the user back to the callback within 10 minutes.
+// @Tags OIDC
+// @Param provider path string true "Provider name"
+// @Success 302
+// @Failure 404 {object} errorResp
+// @Failure 502 {object} errorResp "the provider could not be reached"
+// @Router /auth/oidc/{provider}/login [get]
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	p, ok := h.oidcProvider(c)
+	if !ok {
+		return
+	}
+
+	var state, nonce, verifier, browser string
+	var err error
+	for _, v := range []*string{&state, &nonce, &verifier, &browser} {
+		if *v, err = oidc.NewState(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = h.Identities.StartLogin(&model.OIDCLogin{
+			StateHash:    hashToken(state),
+			Provider:     p.Name,
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			BrowserHash:  hashToken(browser),
+			ExpiresAt:    time.Now().Add(OIDCLoginTTL),
+		})
+	}
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("oidc: failed to start login (trace_id=%s, provider=%s, err=%v)", traceID, p.Name, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	target, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("oidc: provider unavailable (trace_id=%s, provider=%s, err=%v)", traceID, p.Name, err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "login provider unavailable"})
+		return
+	}
+	// Lax: the provider sends the browser back with a top-level GET
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLoginCookie, browser, int(OIDCLoginTTL.Seconds()), oidcCookiePath, "", secureRequest(c), true)
+	c.Redirect(http.StatusFound, target)
+}
+
+// secureRequest reports whether the client reached us over HTTPS, directly
+// or through the gateway.
+func secureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// OIDCCallback godoc
+// @Summary Finish signing in with an external provider
+// @Description The provider redirects here with code and state (GET); a frontend receiving them can POST them instead. It needs the oidc_login cookie set by the login redirect, so the sign-in finishes in the browser that started it. Returns our tokens like /auth/login. The external account is linked to the user with its email when the provider verified it; a new user is created if there is none.
+// @Tags OIDC
+// @Accept json
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string false "Authorization code (GET)"
+// @Param state query string false "State (GET)"
+// @Param X-Cart-Token header string false "Guest cart token to merge into the user's cart"
+// @Param payload body oidcCallbackReq false "Code and state (POST)"
+// @Success 200 {object} loginResp "logged in, or twoFactorChallengeResp when the user has 2FA on"
+// @Failure 400 {object} errorResp "bad or used state, or the login was started in another browser"
+// @Failure 401 {object} errorResp
+// @Failure 403 {object} errorResp "the provider did not verify the email"
+// @Failure 404 {object} errorResp
+// @Failure 409 {object} errorResp "an account with the email exists but its email is not verified"
+// @Failure 423 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/oidc/{provider}/callback [get]
+// @Router /auth/oidc/{provider}/callback [post]
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	p, ok := h.oidcProvider(c)
+	if !ok {
+		return
+	}
+
+	// the user cancelled, or the provider refused
+	if e := c.Query("error"); e != "" {
+		logger.Info(ctx, fmt.Sprintf("oidc: provider returned an error (trace_id=%s, provider=%s, error=%s)", traceID, p.Name, e))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login at the provider failed: " + e})
+		return
+	}
+
+	var req oidcCallbackReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	login, err := h.Identities.TakeLogin(hashToken(req.State), p.Name)
+	if errors.Is(err, repo.ErrOIDCStateInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("oidc: failed to load login (trace_id=%s, provider=%s, err=%v)", traceID, p.Name, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	// the sign-in must finish in the browser that started it
+	browser, _ := c.Cookie(oidcLoginCookie)
+	c.SetCookie(oidcLoginCookie, "", -1, oidcCookiePath, "", secureRequest(c), true)
+	if browser == "" || login.BrowserHash == "" ||
+		subtle.ConstantTimeCompare([]byte(hashToken(browser)), []byte(login.BrowserHash)) != 1 {
+		logger.Warn(ctx, fmt.Sprintf("oidc: login started in another browser (trace_id=%s, provider=%s, ip=%s)", traceID, p.Name, c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sign-in was started in another browser", "code": "oidc_browser_mismatch"})
+		return
+	}
+
+	ident, err := p.Exchange(ctx, req.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		logger.Warn(ctx, fmt.Sprintf("oidc: code exchange failed (trace_id=%s, provider=%s, err=%v)", traceID, p.Name, err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login at the provider failed"})
+		return
+	}
+
+	u, ok := h.externalUser(c, p.Name, ident)
+	if !ok {
+		return
+	}
+
+	if h.throttled(c, repo.AccountKey(u.ID, "")) {
+		logger.Info(ctx, fmt.Sprintf("oidc: throttled (trace_id=%s, user_id=%d, ip=%s)", traceID, u.ID, c.ClientIP()))
+		return
+	}
+
+	// the provider stands in for the password, not for the second factor
+	if on, err := h.TwoFactor.Enabled(u.ID); err != nil {
+		logger.Error(ctx, fmt.Sprintf("oidc: failed to check 2fa (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	} else if on {
+		h.challengeTwoFactor(c, u)
+		return
+	}
+
+	h.completeLogin(c, u)
+}
+
+// externalUser returns the user of the external identity: the one it is
+// linked to, else the user with its verified email, linked now, else a new
+// user. It answers the request when it returns false.
+func (h *AuthHandler) externalUser(c *gin.Context, provider string, ident *oidc.Identity) (*model.User, bool) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	linked, err := h.Identities.Find(provider, ident.Subject)
+	if err == nil {
+		u, err := h.Repo.GetByID(linked.UserID)
+		if err == nil {
+			return u, true
+		}
+		if !errors.Is(err, repo.ErrNotFound) {
+			logger.Error(ctx, fmt.Sprintf("oidc: failed to load user (trace_id=%s, user_id=%d, err=%v)", traceID, linked.UserID, err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return nil, false
+		}
+		// left over from a deleted account
+		if err := h.Identities.UnlinkAll(linked.UserID); err != nil {
+			logger.Error(ctx, fmt.Sprintf("oidc: failed to unlink deleted user (trace_id=%s, user_id=%d, err=%v)", traceID, linked.UserID, err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return nil, false
+		}
+	} else if !errors.Is(err, repo.ErrIdentityNotFound) {
+		logger.Error(ctx, fmt.Sprintf("oidc: failed to find identity (trace_id=%s, provider=%s, err=%v)", traceID, provider, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return nil, false
+	}
+
+	// accounts are only matched on an address the provider vouches for
+	email := normalizeEmail(ident.Email)
+	if email == "" || !ident.EmailVerified {
+		logger.Info(ctx, fmt.Sprintf("oidc: email not verified by provider (trace_id=%s, provider=%s)", traceID, provider))
+		c.JSON(http.StatusForbidden, gin.H{"error": "the provider did not verify your email", "code": "email_not_verified"})
+		return nil, false
+	}
+	identity := &model.ExternalIdentity{Provider: provider, Subject: ident.Subject, Email: email}
+
+	u, err := h.Repo.GetByEmail(email)
+	switch {
+	case err == nil:
+		// whoever registered the address without verifying it may not own it
+		if u.EmailVerifiedAt == nil {
+			logger.Info(ctx, fmt.Sprintf("oidc: account email not verified, not linked (trace_id=%s, provider=%s, user_id=%d)", traceID, provider, u.ID))
+			c.JSON(http.StatusConflict, gin.H{"error": "an account with this email exists; verify its email or log in with its password first", "code": "account_exists"})
+			return nil, false
+		}
+		identity.UserID = u.ID
+		if err := h.Identities.Link(identity); err != nil {
+			logger.Error(ctx, fmt.Sprintf("oidc: failed to link identity (trace_id=%s, provider=%s, user_id=%d, err=%v)", traceID, provider, u.ID, err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return nil, false
+		}
+		logger.Info(ctx, fmt.Sprintf("oidc: identity linked (trace_id=%s, provider=%s, user_id=%d)", traceID, provider, u.ID))
+		return u, true
+	case !errors.Is(err, repo.ErrNotFound):
+		logger.Error(ctx, fmt.Sprintf("oidc: repo error checking email (trace_id=%s, err=%v)", traceID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return nil, false
+	}
+
+	username, err := h.freeUsername(email)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("oidc: failed to pick username (trace_id=%s, err=%v)", traceID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return nil, false
+	}
+	now := time.Now()
+	u = &model.User{
+		Email:           email,
+		Username:        username,
+		Roles:           h.initialRoles(email, true), // verified by the provider
+		EmailVerifiedAt: &now,
+		DisplayName:     truncate(ident.Name, 100),
+		// no password: one can be set after a recent sign-in, or with a reset link
+	}
+	if err := h.Identities.CreateUser(u, identity); err != nil {
+		logger.Error(ctx, fmt.Sprintf("oidc: failed to create user (trace_id=%s, provider=%s, err=%v)", traceID, provider, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
+		return nil, false
+	}
+	logger.Info(ctx, fmt.Sprintf("oidc: user created (trace_id=%s, provider=%s, id=%d, username=%s)", traceID, provider, u.ID, u.Username))
+	return u, true
+}
+
+// normalizeEmail is the form provider emails are matched and stored in
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// freeUsername derives an unused username from the email's local part.
+func (h *AuthHandler) freeUsername(email string) (string, error) {
+	local, _, _ := strings.Cut(email, "@")
+	base := truncate(nonAlnum.ReplaceAllString(local, ""), 20)
+	if base == "" {
+		base = "user"
+	}
+	for i := 0; i < 5; i++ {
+		name := base
+		if i > 0 {
+			n, err := rand.Int(rand.Reader, big.NewInt(10000))
+			if err != nil {
+				return "", err
+			}
+			name += fmt.Sprintf("%04d", n.Int64())
+		}
+		if _, err := h.Repo.GetUser(name); errors.Is(err, repo.ErrNotFound) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("no free username")
+}
@@ -0,0 +1,463 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/broker"
+	"github.com/phanthehoang2503/small-project/internal/event"
+	logger "github.com/phanthehoang2503/small-project/internal/logger"
+	"github.com/phanthehoang2503/small-project/internal/message"
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+type profileResp struct {
+	ID              uint       `json:"id"`
+	Email           string     `json:"email" example:"user@example.com"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Username        string     `json:"username" example:"username123"`
+	DisplayName     string     `json:"display_name" example:"Jane Doe"`
+	Phone           string     `json:"phone" example:"+84901234567"`
+	DefaultAddress  string     `json:"default_address" example:"123 Main St"`
+	Roles           []string   `json:"roles" example:"customer"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// updateProfileReq holds the fields to change; absent fields are kept and
+// empty strings clear them.
+type updateProfileReq struct {
+	DisplayName    *string `json:"display_name" binding:"omitempty,max=100" example:"Jane Doe"`
+	Phone          *string `json:"phone" binding:"omitempty,max=32" example:"+84901234567"`
+	DefaultAddress *string `json:"default_address" binding:"omitempty,max=500" example:"123 Main St"`
+}
+
+// Requests that need the password leave it out for accounts that have none
+// (created with an external provider); those need a recent sign-in instead.
+
+type changeEmailReq struct {
+	Email    string `json:"email" binding:"required,email" example:"new@example.com"`
+	Password string `json:"password" example:"secret123"`
+}
+
+type changePasswordReq struct {
+	CurrentPassword string `json:"current_password" example:"secret123"` // left out to set a first password
+	NewPassword     string `json:"new_password" binding:"required" example:"newsecret123"`
+}
+
+type deleteAccountReq struct {
+	Password string `json:"password" example:"secret123"`
+	Code     string `json:"code" example:"123456"` // TOTP or recovery code, when 2FA is on
+}
+
+func newProfileResp(u *model.User) profileResp {
+	return profileResp{
+		ID:              u.ID,
+		Email:           u.Email,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		Username:        u.Username,
+		DisplayName:     u.DisplayName,
+		Phone:           u.Phone,
+		DefaultAddress:  u.DefaultAddress,
+		Roles:           rolesOf(u.Roles),
+		CreatedAt:       u.CreatedAt,
+	}
+}
+
+// currentUser loads the signed-in user, answering 401 if the account is gone.
+func (h *AuthHandler) currentUser(c *gin.Context) (*model.User, bool) {
+	u, err := h.Repo.GetByID(c.GetUint("user_id"))
+	if errors.Is(err, repo.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return nil, false
+	}
+	return u, true
+}
+
+// RecentLoginWindow is how recently a user without a password must have
+// signed in with their provider to do what otherwise needs the password.
+const RecentLoginWindow = 10 * time.Minute
+
+// requirePassword answers 401 unless password is the user's. Wrong passwords
+// count towards the lockout like failed logins. Users without a password
+// need a recent sign-in instead, which is not counted.
+func (h *AuthHandler) requirePassword(c *gin.Context, u *model.User, password string) bool {
+	if u.Password == "" {
+		return h.requireRecentLogin(c, u)
+	}
+	if password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password is required"})
+		return false
+	}
+	account := repo.AccountKey(u.ID, "")
+	if h.throttled(c, account) {
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
+		h.loginFailed(c, u, account)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return false
+	}
+	return true
+}
+
+// requireRecentLogin answers 403 with code reauth_required unless the
+// session of the request started within RecentLoginWindow. A stolen access
+// or refresh token is not enough: only a new sign-in starts a session.
+func (h *AuthHandler) requireRecentLogin(c *gin.Context, u *model.User) bool {
+	s, err := h.Tokens.GetSession(u.ID, currentSession(c))
+	if err != nil && !errors.Is(err, repo.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return false
+	}
+	if err != nil || time.Since(s.CreatedAt) > RecentLoginWindow {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "this account has no password: sign in again with your external account first",
+			"code":  "reauth_required",
+		})
+		return false
+	}
+	return true
+}
+
+// GetMe godoc
+// @Summary Get my profile
+// @Tags Users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} profileResp
+// @Failure 401 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /users/me [get]
+func (h *AuthHandler) GetMe(c *gin.Context) {
+	u, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newProfileResp(u))
+}
+
+// UpdateMe godoc
+// @Summary Update my profile
+// @Description Changes the fields sent: display name, phone and default address. An empty string clears a field.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body updateProfileReq true "Fields to change"
+// @Success 200 {object} profileResp
+// @Failure 400 {object} errorResp
+// @Failure 401 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /users/me [patch]
+func (h *AuthHandler) UpdateMe(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	userID := c.GetUint("user_id")
+
+	var req updateProfileReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fields := map[string]interface{}{}
+	if req.DisplayName != nil {
+		fields["display_name"] = strings.TrimSpace(*req.DisplayName)
+	}
+	if req.Phone != nil {
+		fields["phone"] = strings.TrimSpace(*req.Phone)
+	}
+	if req.DefaultAddress != nil {
+		fields["default_address"] = strings.TrimSpace(*req.DefaultAddress)
+	}
+
+	u, err := h.Repo.UpdateProfile(userID, fields)
+	if errors.Is(err, repo.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("profile: failed to update (trace_id=%s, user_id=%d, err=%v)", traceID, userID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newProfileResp(u))
+}
+
+// ChangeEmail godoc
+// @Summary Change my email
+// @Description Sends a confirmation link to the new address. The email changes, verified, once the link is used; until then login keeps the old address. Accounts without a password leave it out and must have signed in within 10 minutes (403 with code reauth_required otherwise).
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body changeEmailReq true "New email and current password"
+// @Success 202 {object} messageResp
+// @Failure 400 {object} errorResp
+// @Failure 401 {object} errorResp
+// @Failure 403 {object} errorResp
+// @Failure 423 {object} errorResp
+// @Failure 429 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /users/me/email [post]
+func (h *AuthHandler) ChangeEmail(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	var req changeEmailReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, ok := h.currentUser(c)
+	if !ok || !h.requirePassword(c, u, req.Password) {
+		return
+	}
+	if strings.EqualFold(req.Email, u.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "this is already your email"})
+		return
+	}
+	if other, err := h.Repo.GetUser(req.Email); err == nil && other != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": repo.ErrEmailTaken.Error()})
+		return
+	} else if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	// the token is recorded for the new address, which it proves
+	target := *u
+	target.Email = req.Email
+	token, expiresAt, err := h.issueActionToken(&target, model.PurposeChangeEmail, h.Email.VerifyTTL)
+	if err == nil {
+		err = broker.PublishJSON(ctx, event.ExchangeUser, event.RoutingKeyUserEmailChangeRequested, message.EmailChangeRequested{
+			UserID:       u.ID,
+			Email:        req.Email,
+			OldEmail:     u.Email,
+			ConfirmToken: token,
+			ConfirmURL:   withToken(h.Email.ChangeEmailURL, token),
+			ExpiresAt:    expiresAt,
+		})
+	}
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("change-email: failed to send confirmation (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	logger.Info(ctx, fmt.Sprintf("change-email: confirmation sent (trace_id=%s, user_id=%d)", traceID, u.ID))
+	c.JSON(http.StatusAccepted, messageResp{Message: "a confirmation link was sent to the new address"})
+}
+
+// ConfirmEmailChange godoc
+// @Summary Confirm an email change
+// @Description Uses the token sent to the new address, as ?token= (the emailed link) or in the body.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param token query string false "Confirmation token"
+// @Param payload body tokenReq false "Confirmation token"
+// @Success 200 {object} messageResp
+// @Failure 400 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /users/email/confirm [post]
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	token := c.Query("token")
+	if token == "" {
+		var req tokenReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		token = req.Token
+	}
+
+	t, err := h.useActionToken(ctx, token, model.PurposeChangeEmail)
+	if err != nil {
+		status, msg := actionTokenStatus(err)
+		if status == http.StatusInternalServerError {
+			logger.Error(ctx, fmt.Sprintf("change-email: failed to use token (trace_id=%s, err=%v)", traceID, err))
+		}
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.Repo.ChangeEmail(t.UserID, t.Email); err != nil {
+		switch {
+		case errors.Is(err, repo.ErrEmailTaken):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, repo.ErrNotFound):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			logger.Error(ctx, fmt.Sprintf("change-email: failed to change email (trace_id=%s, user_id=%d, err=%v)", traceID, t.UserID, err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		}
+		return
+	}
+
+	// links sent to the old address, or for other new ones, no longer apply
+	for _, purpose := range []string{model.PurposeVerifyEmail, model.PurposeChangeEmail} {
+		if err := h.ActionTokens.RevokeAll(t.UserID, purpose); err != nil {
+			logger.Error(ctx, fmt.Sprintf("change-email: failed to revoke %s tokens (trace_id=%s, user_id=%d, err=%v)", purpose, traceID, t.UserID, err))
+		}
+	}
+
+	h.promoteAdmin(ctx, t.UserID, t.Email)
+
+	logger.Info(ctx, fmt.Sprintf("change-email: email changed (trace_id=%s, user_id=%d)", traceID, t.UserID))
+	c.JSON(http.StatusOK, messageResp{Message: "email changed"})
+}
+
+// ChangePassword godoc
+// @Summary Change my password
+// @Description Needs the current password. Accounts created with an external provider have none: they set a first password without current_password, within 10 minutes of signing in (403 with code reauth_required otherwise). Every other session of the user is signed out; the one making the request stays.
+// @Tags Users
+// @Accept json
+// @Security BearerAuth
+// @Param payload body changePasswordReq true "Current and new password"
+// @Success 204
+// @Failure 400 {object} errorResp
+// @Failure 401 {object} errorResp
+// @Failure 403 {object} errorResp
+// @Failure 423 {object} errorResp
+// @Failure 429 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /users/me/password [post]
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	var req changePasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, ok := h.currentUser(c)
+	if !ok || !h.requirePassword(c, u, req.CurrentPassword) {
+		return
+	}
+	firstPassword := u.Password == ""
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("change-password: failed to hash password (trace_id=%s, err=%v)", traceID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+	if err := h.Repo.UpdatePassword(u.ID, string(hashed)); err != nil {
+		logger.Error(ctx, fmt.Sprintf("change-password: failed to update password (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	h.loginSucceeded(c, repo.AccountKey(u.ID, ""))
+
+	// the session of this access token stays signed in
+	var current string
+	if claims, ok := middleware.ClaimsFrom(c); ok {
+		current = claims.SessionID
+	}
+	revoked, err := h.Tokens.RevokeOthers(u.ID, current)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("change-password: failed to revoke sessions (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+	}
+	h.revokeAccess(ctx, revoked)
+	if err := h.ActionTokens.RevokeAll(u.ID, model.PurposeResetPassword); err != nil {
+		logger.Error(ctx, fmt.Sprintf("change-password: failed to revoke reset tokens (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+	}
+
+	logger.Info(ctx, fmt.Sprintf("change-password: password changed (trace_id=%s, user_id=%d, first=%t, sessions_revoked=%d)", traceID, u.ID, firstPassword, len(revoked)))
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteMe godoc
+// @Summary Delete my account
+// @Description Needs the password (or, for accounts without one, a sign-in within 10 minutes), and a TOTP or recovery code when 2FA is on. Personal data is wiped, every session is signed out and user.deleted is published so other services purge the user's data. This cannot be undone.
+// @Tags Users
+// @Accept json
+// @Security BearerAuth
+// @Param payload body deleteAccountReq true "Password and, with 2FA on, a code"
+// @Success 204
+// @Failure 400 {object} errorResp
+// @Failure 401 {object} errorResp
+// @Failure 403 {object} errorResp
+// @Failure 423 {object} errorResp
+// @Failure 429 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /users/me [delete]
+func (h *AuthHandler) DeleteMe(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	var req deleteAccountReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, ok := h.currentUser(c)
+	if !ok || !h.requirePassword(c, u, req.Password) {
+		return
+	}
+	tf, err := h.TwoFactor.Get(u.ID)
+	if err != nil && !errors.Is(err, repo.ErrTwoFactorNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if err == nil && tf.EnabledAt != nil && !h.requireCode(c, tf, req.Code) {
+		return
+	}
+
+	if err := h.Repo.Anonymize(u.ID); err != nil {
+		logger.Error(ctx, fmt.Sprintf("delete-account: failed to anonymize (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	// what is left of the account is cleaned up on a best-effort basis
+	revoked, err := h.Tokens.RevokeAllForUser(u.ID)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("delete-account: failed to revoke sessions (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+	}
+	h.revokeAccess(ctx, revoked)
+	for _, purpose := range []string{model.PurposeVerifyEmail, model.PurposeResetPassword, model.PurposeChangeEmail, model.PurposeTwoFactorChallenge} {
+		if err := h.ActionTokens.RevokeAll(u.ID, purpose); err != nil {
+			logger.Error(ctx, fmt.Sprintf("delete-account: failed to revoke %s tokens (trace_id=%s, user_id=%d, err=%v)", purpose, traceID, u.ID, err))
+		}
+	}
+	if err := h.TwoFactor.Disable(u.ID); err != nil {
+		logger.Error(ctx, fmt.Sprintf("delete-account: failed to remove 2fa (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+	}
+	if h.Identities != nil {
+		if err := h.Identities.UnlinkAll(u.ID); err != nil {
+			logger.Error(ctx, fmt.Sprintf("delete-account: failed to unlink external accounts (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		}
+	}
+
+	if err := broker.PublishJSON(ctx, event.ExchangeUser, event.RoutingKeyUserDeleted, message.UserDeleted{
+		UserID:    u.ID,
+		DeletedAt: time.Now(),
+	}); err != nil {
+		logger.Error(ctx, fmt.Sprintf("delete-account: failed to publish user.deleted (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+	}
+
+	logger.Info(ctx, fmt.Sprintf("delete-account: account deleted (trace_id=%s, user_id=%d)", traceID, u.ID))
+	c.Status(http.StatusNoContent)
+}
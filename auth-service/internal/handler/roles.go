@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
+	logger "github.com/phanthehoang2503/small-project/internal/logger"
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+var (
+	errOwnAdminRole = errors.New("you cannot revoke your own admin role")
+	errServiceRole  = errors.New("the service role is only for service clients")
+)
+
+type roleReq struct {
+	Role string `json:"role" binding:"required" example:"support"`
+}
+
+type rolesResp struct {
+	UserID uint     `json:"user_id"`
+	Roles  []string `json:"roles" example:"customer,support"`
+}
+
+// rolesOf returns the stored roles as put in tokens; users created before
+// roles existed have none stored and are customers. The service role is
+// dropped: only client credentials tokens carry it.
+func rolesOf(roles []string) []string {
+	held := make([]string, 0, len(roles))
+	for _, r := range roles {
+		if r != middleware.RoleService {
+			held = append(held, r)
+		}
+	}
+	if len(held) == 0 {
+		return []string{middleware.RoleCustomer}
+	}
+	return held
+}
+
+// isAdminEmail reports whether email is in AdminEmails.
+func (h *AuthHandler) isAdminEmail(email string) bool {
+	for _, e := range h.AdminEmails {
+		if strings.EqualFold(e, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// initialRoles are the roles of a new user: customer, plus admin for the
+// addresses in AdminEmails once the user proved they own it. Registration
+// does not prove it; the admin role then comes with verification.
+func (h *AuthHandler) initialRoles(email string, verified bool) []string {
+	roles := []string{middleware.RoleCustomer}
+	if verified && h.isAdminEmail(email) {
+		roles = append(roles, middleware.RoleAdmin)
+	}
+	return roles
+}
+
+// PromoteAdmins grants the admin role to the users in AdminEmails whose
+// email is verified.
+func (h *AuthHandler) PromoteAdmins() {
+	for _, email := range h.AdminEmails {
+		u, err := h.Repo.GetUser(email)
+		if err != nil || u.EmailVerifiedAt == nil {
+			continue // made admin when they verify their email
+		}
+		h.promoteAdmin(context.Background(), u.ID, email)
+	}
+}
+
+// promoteAdmin grants the admin role to the user if email, which they just
+// proved to own, is in AdminEmails.
+func (h *AuthHandler) promoteAdmin(ctx context.Context, userID uint, email string) {
+	if !h.isAdminEmail(email) {
+		return
+	}
+	if _, err := h.Repo.UpdateRoles(userID, func(roles []string) ([]string, error) {
+		return withRole(rolesOf(roles), middleware.RoleAdmin), nil
+	}); err != nil {
+		logger.Error(ctx, fmt.Sprintf("roles: failed to make %s admin (user_id=%d, err=%v)", email, userID, err))
+		return
+	}
+	logger.Info(ctx, fmt.Sprintf("roles: admin granted to %s (user_id=%d)", email, userID))
+}
+
+func withRole(roles []string, role string) []string {
+	for _, r := range roles {
+		if r == role {
+			return roles
+		}
+	}
+	return append(roles, role)
+}
+
+// GetRoles godoc
+// @Summary Get a user's roles
+// @Tags Users
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} rolesResp
+// @Failure 400 {object} errorResp
+// @Failure 403 {object} errorResp
+// @Failure 404 {object} errorResp
+// @Router /auth/users/{id}/roles [get]
+func (h *AuthHandler) GetRoles(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	u, err := h.Repo.GetByID(uint(id))
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	c.JSON(http.StatusOK, rolesResp{UserID: u.ID, Roles: rolesOf(u.Roles)})
+}
+
+// GrantRole godoc
+// @Summary Grant a role to a user
+// @Description Takes effect in the user's next access token (at the latest after ACCESS_TOKEN_TTL).
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param payload body roleReq true "Role: customer, support or admin"
+// @Success 200 {object} rolesResp
+// @Failure 400 {object} errorResp
+// @Failure 403 {object} errorResp
+// @Failure 404 {object} errorResp
+// @Router /auth/users/{id}/roles [post]
+func (h *AuthHandler) GrantRole(c *gin.Context) {
+	var req roleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.changeRole(c, "granted", req.Role, func(roles []string) []string {
+		return withRole(roles, req.Role)
+	})
+}
+
+// RevokeRole godoc
+// @Summary Revoke a role from a user
+// @Description Takes effect in the user's next access token (at the latest after ACCESS_TOKEN_TTL). Admins cannot revoke their own admin role.
+// @Tags Users
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param role path string true "Role"
+// @Success 200 {object} rolesResp
+// @Failure 400 {object} errorResp
+// @Failure 403 {object} errorResp
+// @Failure 404 {object} errorResp
+// @Failure 409 {object} errorResp
+// @Router /auth/users/{id}/roles/{role} [delete]
+func (h *AuthHandler) RevokeRole(c *gin.Context) {
+	role := c.Param("role")
+	if role == middleware.RoleCustomer {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "every user is a customer"})
+		return
+	}
+	// keep at least the admin doing this able to manage roles
+	if role == middleware.RoleAdmin && c.Param("id") == strconv.FormatUint(uint64(c.GetUint("user_id")), 10) {
+		c.JSON(http.StatusConflict, gin.H{"error": errOwnAdminRole.Error()})
+		return
+	}
+
+	h.changeRole(c, "revoked", role, func(roles []string) []string {
+		kept := make([]string, 0, len(roles))
+		for _, r := range roles {
+			if r != role {
+				kept = append(kept, r)
+			}
+		}
+		return kept
+	})
+}
+
+// changeRole replaces the roles of the user in the path with change(roles).
+func (h *AuthHandler) changeRole(c *gin.Context, action, role string, change func([]string) []string) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	if !middleware.ValidRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown role %q", role)})
+		return
+	}
+	// service principals come from client credentials, never from a user
+	if role == middleware.RoleService {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errServiceRole.Error()})
+		return
+	}
+	u, err := h.Repo.UpdateRoles(uint(id), func(roles []string) ([]string, error) {
+		return change(rolesOf(roles)), nil
+	})
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Error(ctx, fmt.Sprintf("roles: failed to update roles (trace_id=%s, user_id=%d, err=%v)", traceID, id, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	logger.Info(ctx, fmt.Sprintf("roles: %s %s (trace_id=%s, user_id=%d, by=%d, roles=%v)", role, action, traceID, u.ID, c.GetUint("user_id"), u.Roles))
+	c.JSON(http.StatusOK, rolesResp{UserID: u.ID, Roles: rolesOf(u.Roles)})
+}
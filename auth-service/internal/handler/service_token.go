@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	logger "github.com/phanthehoang2503/small-project/internal/logger"
+)
+
+// ServiceClient is a service allowed to get client-credentials tokens for
+// calling the services in Audiences.
+type ServiceClient struct {
+	ID        string
+	Secret    string
+	Audiences []string
+}
+
+type serviceTokenReq struct {
+	GrantType    string `form:"grant_type" json:"grant_type" binding:"required" example:"client_credentials"`
+	ClientID     string `form:"client_id" json:"client_id" example:"order-service"` // or HTTP Basic auth
+	ClientSecret string `form:"client_secret" json:"client_secret"`
+	Audience     string `form:"audience" json:"audience" example:"cart-service"` // one of the client's services, all of them if empty
+}
+
+type serviceTokenResp struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type" example:"Bearer"`
+	ExpiresIn   int    `json:"expires_in" example:"900"`
+}
+
+type oauthErrorResp struct {
+	Error            string `json:"error" example:"invalid_client"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// oauthError answers with an OAuth 2.0 error (RFC 6749 section 5.2)
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, oauthErrorResp{Error: code, ErrorDescription: description})
+}
+
+// authenticateClient returns the service client whose credentials were sent,
+// by HTTP Basic auth or in the body.
+func (h *AuthHandler) authenticateClient(c *gin.Context, req serviceTokenReq) (ServiceClient, bool) {
+	id, secret := req.ClientID, req.ClientSecret
+	if user, pass, ok := c.Request.BasicAuth(); ok {
+		// credentials are form-encoded before Basic auth (RFC 6749 section 2.3.1)
+		id, _ = url.QueryUnescape(user)
+		secret, _ = url.QueryUnescape(pass)
+	}
+
+	client, ok := h.ServiceClients[id]
+	// hashing first makes the comparison independent of the secret's length
+	got, want := sha256.Sum256([]byte(secret)), sha256.Sum256([]byte(client.Secret))
+	if !ok || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+		return ServiceClient{}, false
+	}
+	return client, true
+}
+
+// ServiceToken godoc
+// @Summary Get a service token
+// @Description OAuth 2.0 client-credentials grant for services calling each other. The token holds the service role and, as audience, the service asked for (or all the client may call). Credentials go in HTTP Basic auth or in the body.
+// @Tags Services
+// @Accept x-www-form-urlencoded
+// @Accept json
+// @Produce json
+// @Param payload body serviceTokenReq true "Grant"
+// @Success 200 {object} serviceTokenResp
+// @Failure 400 {object} oauthErrorResp
+// @Failure 401 {object} oauthErrorResp
+// @Failure 500 {object} oauthErrorResp
+// @Router /auth/token [post]
+func (h *AuthHandler) ServiceToken(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	c.Header("Cache-Control", "no-store")
+
+	var req serviceTokenReq
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if req.GrantType != "client_credentials" {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+		return
+	}
+
+	client, ok := h.authenticateClient(c, req)
+	if !ok {
+		logger.Warn(ctx, fmt.Sprintf("service-token: invalid client (trace_id=%s, ip=%s)", traceID, c.ClientIP()))
+		c.Header("WWW-Authenticate", `Basic realm="auth-service"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+
+	audiences := client.Audiences
+	if req.Audience != "" {
+		audiences = nil
+		for _, aud := range client.Audiences {
+			if aud == req.Audience {
+				audiences = []string{aud}
+			}
+		}
+		if audiences == nil {
+			oauthError(c, http.StatusBadRequest, "invalid_target", fmt.Sprintf("%s may not call %s", client.ID, req.Audience))
+			return
+		}
+	}
+
+	token, _, err := h.Signer.IssueService(client.ID, audiences, h.accessTTL)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("service-token: failed to sign (trace_id=%s, client=%s, err=%v)", traceID, client.ID, err))
+		oauthError(c, http.StatusInternalServerError, "server_error", "failed to create token")
+		return
+	}
+
+	logger.Info(ctx, fmt.Sprintf("service-token: issued (trace_id=%s, client=%s, audience=%v)", traceID, client.ID, audiences))
+	c.JSON(http.StatusOK, serviceTokenResp{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(h.accessTTL.Seconds()),
+	})
+}
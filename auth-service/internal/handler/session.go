@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
+	logger "github.com/phanthehoang2503/small-project/internal/logger"
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+type sessionResp struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device" example:"Chrome on Windows"`
+	IP         string    `json:"ip" example:"203.0.113.7"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"` // the session of the token making the request
+}
+
+type signOutResp struct {
+	SessionsRevoked int `json:"sessions_revoked" example:"3"`
+}
+
+// browsers and systems recognised in user agents, in match order: Edge and
+// Opera also claim to be Chrome, Chrome claims to be Safari, Android and iOS
+// claim to be Linux and Mac OS.
+var (
+	uaBrowsers = [][2]string{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	}
+	uaSystems = [][2]string{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"},
+		{"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// deviceOf names the device of a user agent, e.g. "Firefox on Linux"; other
+// clients are named by their product token, e.g. "curl".
+func deviceOf(ua string) string {
+	match := func(table [][2]string) string {
+		for _, e := range table {
+			if strings.Contains(ua, e[0]) {
+				return e[1]
+			}
+		}
+		return ""
+	}
+	browser, system := match(uaBrowsers), match(uaSystems)
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	if product, _, _ := strings.Cut(ua, "/"); product != "" {
+		return truncate(product, 100)
+	}
+	return "Unknown device"
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// currentSession returns the session of the access token making the request
+func currentSession(c *gin.Context) string {
+	if claims, ok := middleware.ClaimsFrom(c); ok {
+		return claims.SessionID
+	}
+	return ""
+}
+
+// ListSessions godoc
+// @Summary List my sessions
+// @Description The devices the user is signed in on, most recently used first. Sessions from before session tracking are not listed; they end with their refresh token.
+// @Tags Sessions
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} sessionResp
+// @Failure 401 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/sessions [get]
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	userID := c.GetUint("user_id")
+
+	sessions, err := h.Tokens.ListSessions(userID)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("sessions: failed to list sessions (trace_id=%s, user_id=%d, err=%v)", traceID, userID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	current := currentSession(c)
+	resp := make([]sessionResp, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, newSessionResp(s, current))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func newSessionResp(s model.Session, current string) sessionResp {
+	return sessionResp{
+		ID:         s.ID,
+		Device:     s.Device,
+		IP:         s.IP,
+		UserAgent:  s.UserAgent,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		Current:    s.ID == current,
+	}
+}
+
+// RevokeSession godoc
+// @Summary Sign out a session
+// @Description Signs out one of the user's devices: its refresh token stops working and its access tokens are rejected right away.
+// @Tags Sessions
+// @Security BearerAuth
+// @Param id path string true "Session ID"
+// @Success 204
+// @Failure 401 {object} errorResp
+// @Failure 404 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/sessions/{id} [delete]
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	userID := c.GetUint("user_id")
+	id := c.Param("id")
+
+	revoked, err := h.Tokens.RevokeSession(userID, id)
+	if errors.Is(err, repo.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("sessions: failed to revoke session (trace_id=%s, user_id=%d, session=%s, err=%v)", traceID, userID, id, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	h.revokeAccess(ctx, revoked)
+
+	logger.Info(ctx, fmt.Sprintf("sessions: session revoked (trace_id=%s, user_id=%d, session=%s)", traceID, userID, id))
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeAllSessions godoc
+// @Summary Sign out everywhere
+// @Description Signs out every session of the user, including this one unless except_current is set.
+// @Tags Sessions
+// @Produce json
+// @Security BearerAuth
+// @Param except_current query bool false "Keep the session making the request signed in"
+// @Success 200 {object} signOutResp
+// @Failure 401 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/sessions [delete]
+func (h *AuthHandler) RevokeAllSessions(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	userID := c.GetUint("user_id")
+
+	var revoked []model.RefreshToken
+	var err error
+	if current := currentSession(c); current != "" && c.Query("except_current") == "true" {
+		revoked, err = h.Tokens.RevokeOthers(userID, current)
+	} else {
+		revoked, err = h.Tokens.RevokeAllForUser(userID)
+	}
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("sessions: failed to revoke sessions (trace_id=%s, user_id=%d, err=%v)", traceID, userID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	h.revokeAccess(ctx, revoked)
+
+	families := make(map[string]bool)
+	for _, t := range revoked {
+		families[t.FamilyID] = true
+	}
+	logger.Info(ctx, fmt.Sprintf("sessions: signed out everywhere (trace_id=%s, user_id=%d, sessions_revoked=%d)", traceID, userID, len(families)))
+	c.JSON(http.StatusOK, signOutResp{SessionsRevoked: len(families)})
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
+	logger "github.com/phanthehoang2503/small-project/internal/logger"
+)
+
+// DefaultRefreshTokenTTL is how long a session lasts without being refreshed.
+const DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type tokenResp struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in" example:"900"` // access token lifetime in seconds
+}
+
+// newRefreshToken returns a random opaque token and the hash stored for it
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(b)
+	return raw, hashToken(raw), nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTokens creates an access token for the session and the refresh token
+// row going with it.
+func (h *AuthHandler) newTokens(u *model.User, sessionID string) (tokenResp, *model.RefreshToken, error) {
+	access, claims, err := h.Signer.Issue(u.ID, rolesOf(u.Roles), sessionID, h.accessTTL)
+	if err != nil {
+		return tokenResp{}, nil, err
+	}
+	raw, hash, err := newRefreshToken()
+	if err != nil {
+		return tokenResp{}, nil, err
+	}
+
+	rt := &model.RefreshToken{
+		UserID:          u.ID,
+		TokenHash:       hash,
+		ExpiresAt:       time.Now().Add(h.refreshTTL),
+		AccessJTI:       claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+	}
+	return tokenResp{
+		Token:        access,
+		RefreshToken: raw,
+		ExpiresIn:    int(h.accessTTL.Seconds()),
+	}, rt, nil
+}
+
+// issueTokens starts a new session, and token family, for the user on the
+// client making the request.
+func (h *AuthHandler) issueTokens(c *gin.Context, u *model.User) (tokenResp, error) {
+	now := time.Now()
+	ua := c.Request.UserAgent()
+	s := &model.Session{
+		ID:         uuid.New().String(),
+		UserID:     u.ID,
+		Device:     deviceOf(ua),
+		UserAgent:  truncate(ua, 512),
+		IP:         c.ClientIP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(h.refreshTTL),
+	}
+	pair, rt, err := h.newTokens(u, s.ID)
+	if err != nil {
+		return tokenResp{}, err
+	}
+	if err := h.Tokens.CreateSession(s, rt); err != nil {
+		return tokenResp{}, err
+	}
+	return pair, nil
+}
+
+// revokeAccess denylists the access tokens issued with the given refresh
+// tokens, and the sessions they belong to, so access tokens of those sessions
+// missing from the list are rejected too.
+func (h *AuthHandler) revokeAccess(ctx context.Context, tokens []model.RefreshToken) {
+	if h.Denylist == nil {
+		return
+	}
+	sessions := make(map[string]bool)
+	for _, t := range tokens {
+		if !sessions[t.FamilyID] {
+			sessions[t.FamilyID] = true
+			// no access token of the session outlives this
+			if err := h.Denylist.RevokeSession(ctx, t.FamilyID, time.Now().Add(h.accessTTL)); err != nil {
+				logger.Error(ctx, fmt.Sprintf("failed to denylist session (user_id=%d, err=%v)", t.UserID, err))
+			}
+		}
+		if t.AccessJTI == "" {
+			continue
+		}
+		if err := h.Denylist.Revoke(ctx, t.AccessJTI, t.AccessExpiresAt); err != nil {
+			logger.Error(ctx, fmt.Sprintf("failed to denylist access token (user_id=%d, err=%v)", t.UserID, err))
+		}
+	}
+}
+
+// Refresh godoc
+// @Summary Exchange a refresh token for new tokens
+// @Description Refresh tokens rotate: the one sent is used up and a new one is returned. Sending a used refresh token again revokes every token of the session.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body refreshReq true "Refresh token"
+// @Success 200 {object} tokenResp
+// @Failure 400 {object} errorResp
+// @Failure 401 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/refresh [post]
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	var req refreshReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var pair tokenResp
+	current, revoked, err := h.Tokens.Rotate(hashToken(req.RefreshToken), c.ClientIP(), func(userID uint, sessionID string) (*model.RefreshToken, error) {
+		// roles are read again so grants and revocations apply on refresh
+		u, err := h.Repo.GetByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		p, rt, err := h.newTokens(u, sessionID)
+		pair = p
+		return rt, err
+	})
+	switch {
+	case errors.Is(err, repo.ErrRefreshTokenReused):
+		h.revokeAccess(ctx, revoked)
+		logger.Warn(ctx, fmt.Sprintf("refresh: token reuse detected, session revoked (trace_id=%s, user_id=%d, family=%s)", traceID, current.UserID, current.FamilyID))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token already used, please log in again"})
+		return
+	case errors.Is(err, repo.ErrRefreshTokenInvalid), errors.Is(err, repo.ErrRefreshTokenExpired), errors.Is(err, repo.ErrNotFound):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		logger.Error(ctx, fmt.Sprintf("refresh: failed to rotate token (trace_id=%s, err=%v)", traceID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	logger.Info(ctx, fmt.Sprintf("refresh: tokens rotated (trace_id=%s, user_id=%d)", traceID, current.UserID))
+	c.JSON(http.StatusOK, pair)
+}
+
+// Logout godoc
+// @Summary Log out
+// @Description Revokes the refresh token's session and the access tokens issued in it.
+// @Tags Auth
+// @Accept json
+// @Param payload body refreshReq true "Refresh token"
+// @Success 204
+// @Failure 400 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	var req refreshReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	revoked, err := h.Tokens.RevokeFamilyOf(hashToken(req.RefreshToken))
+	if err != nil && !errors.Is(err, repo.ErrRefreshTokenInvalid) {
+		logger.Error(ctx, fmt.Sprintf("logout: failed to revoke session (trace_id=%s, err=%v)", traceID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	h.revokeAccess(ctx, revoked)
+
+	// unknown tokens are not an error: the client is logged out either way
+	c.Status(http.StatusNoContent)
+}
+
+// JWKS godoc
+// @Summary Public signing keys
+// @Description JSON Web Key Set of the keys access tokens are signed with, looked up by the token's kid
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /.well-known/jwks.json [get]
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.Signer.JWKS())
+}
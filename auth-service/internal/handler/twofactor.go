@@ -0,0 +1,450 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/auth-service/internal/totp"
+	logger "github.com/phanthehoang2503/small-project/internal/logger"
+)
+
+const (
+	// TwoFactorChallengeTTL is how long a login waits for the 2FA code.
+	TwoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 10
+)
+
+type codeReq struct {
+	Code string `json:"code" binding:"required" example:"123456"`
+}
+
+type disableTwoFactorReq struct {
+	Password string `json:"password" example:"secret123"`             // left out by accounts without one
+	Code     string `json:"code" binding:"required" example:"123456"` // TOTP or recovery code
+}
+
+type verifyTwoFactorReq struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required" example:"123456"` // TOTP or recovery code
+}
+
+type twoFactorChallengeResp struct {
+	TwoFactorRequired bool   `json:"two_factor_required" example:"true"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in" example:"300"`
+}
+
+type twoFactorEnrollResp struct {
+	Secret        string   `json:"secret" example:"JBSWY3DPEHPK3PXP"`
+	OTPAuthURI    string   `json:"otpauth_uri" example:"otpauth://totp/small-project:user@example.com?secret=JBSWY3DPEHPK3PXP&issuer=small-project"`
+	RecoveryCodes []string `json:"recovery_codes" example:"abcde-fghij"`
+}
+
+type twoFactorStatusResp struct {
+	Enabled           bool  `json:"enabled"`
+	RecoveryCodesLeft int64 `json:"recovery_codes_left"`
+}
+
+type recoveryCodesResp struct {
+	RecoveryCodes []string `json:"recovery_codes" example:"abcde-fghij"`
+}
+
+// newRecoveryCodes returns codes to show the user once, and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+// recoveryCodeHash hashes a recovery code as typed by the user
+func recoveryCodeHash(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
+
+// checkCode accepts a current TOTP code, once, or an unused recovery code.
+func (h *AuthHandler) checkCode(tf *model.TwoFactor, code string) (bool, error) {
+	if step, ok := totp.Validate(tf.Secret, code, time.Now()); ok {
+		return h.TwoFactor.UseStep(tf.UserID, step)
+	}
+	return h.TwoFactor.UseRecoveryCode(tf.UserID, recoveryCodeHash(code))
+}
+
+func (h *AuthHandler) totpIssuer() string {
+	if h.TOTPIssuer != "" {
+		return h.TOTPIssuer
+	}
+	return "small-project"
+}
+
+// challengeTwoFactor answers a correct password of a 2FA user with a
+// single-use challenge token for VerifyTwoFactor.
+func (h *AuthHandler) challengeTwoFactor(c *gin.Context, u *model.User) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	token, _, err := h.issueActionToken(u, model.PurposeTwoFactorChallenge, TwoFactorChallengeTTL)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("login: failed to create 2fa challenge (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	logger.Info(ctx, fmt.Sprintf("login: 2fa challenge issued (trace_id=%s, user_id=%d)", traceID, u.ID))
+	c.JSON(http.StatusOK, twoFactorChallengeResp{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(TwoFactorChallengeTTL.Seconds()),
+	})
+}
+
+// VerifyTwoFactor godoc
+// @Summary Finish a 2FA login
+// @Description Exchanges the challenge token from /auth/login and a TOTP or recovery code for the access and refresh tokens. Wrong codes count as failed logins.
+// @Tags Two-factor
+// @Accept json
+// @Produce json
+// @Param X-Cart-Token header string false "Guest cart token to merge into the user's cart"
+// @Param payload body verifyTwoFactorReq true "Challenge token and code"
+// @Success 200 {object} loginResp
+// @Failure 400 {object} errorResp
+// @Failure 401 {object} errorResp
+// @Failure 423 {object} errorResp
+// @Failure 429 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/2fa/verify [post]
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+
+	var req verifyTwoFactorReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := h.verifier.ParseFor(ctx, req.ChallengeToken, model.PurposeTwoFactorChallenge)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge, please log in again"})
+		return
+	}
+	account := repo.AccountKey(claims.UserID, "")
+	if h.throttled(c, account) {
+		return
+	}
+
+	u, err := h.Repo.GetByID(claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge, please log in again"})
+		return
+	}
+	tf, err := h.TwoFactor.Get(u.ID)
+	if err != nil || tf.EnabledAt == nil {
+		// 2FA was turned off meanwhile; the password must be checked again
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge, please log in again"})
+		return
+	}
+
+	ok, err := h.checkCode(tf, req.Code)
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("2fa: failed to check code (trace_id=%s, user_id=%d, err=%v)", traceID, u.ID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if !ok {
+		logger.Info(ctx, fmt.Sprintf("2fa: invalid code (trace_id=%s, user_id=%d)", traceID, u.ID))
+		h.loginFailed(c, u, account)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+
+	// the challenge is used up only by a correct code
+	if _, err := h.ActionTokens.Use(claims.ID, model.PurposeTwoFactorChallenge); err != nil {
+		status, msg := actionTokenStatus(err)
+		if status == http.StatusBadRequest {
+			status, msg = http.StatusUnauthorized, "invalid or expired challenge, please log in again"
+		}
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	h.completeLogin(c, u)
+}
+
+// TwoFactorStatus godoc
+// @Summary 2FA status
+// @Tags Two-factor
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} twoFactorStatusResp
+// @Failure 401 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/2fa [get]
+func (h *AuthHandler) TwoFactorStatus(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	enabled, err := h.TwoFactor.Enabled(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	resp := twoFactorStatusResp{Enabled: enabled}
+	if enabled {
+		if resp.RecoveryCodesLeft, err = h.TwoFactor.RecoveryCodesLeft(userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// EnrollTwoFactor godoc
+// @Summary Start 2FA enrolment
+// @Description Returns a new TOTP secret, its otpauth URI (for a QR code) and recovery codes, shown only now. 2FA is on once a code is confirmed with /auth/2fa/confirm.
+// @Tags Two-factor
+// @Produce json
+// @Security BearerAuth
+// @Success 201 {object} twoFactorEnrollResp
+// @Failure 401 {object} errorResp
+// @Failure 409 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/2fa/enroll [post]
+func (h *AuthHandler) EnrollTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	userID := c.GetUint("user_id")
+
+	u, err := h.Repo.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return
+	}
+	if enabled, err := h.TwoFactor.Enabled(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	} else if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already on, disable it first"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if err := h.TwoFactor.Enroll(userID, secret, hashes); err != nil {
+		logger.Error(ctx, fmt.Sprintf("2fa: failed to enroll (trace_id=%s, user_id=%d, err=%v)", traceID, userID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	logger.Info(ctx, fmt.Sprintf("2fa: enrolment started (trace_id=%s, user_id=%d)", traceID, userID))
+	c.JSON(http.StatusCreated, twoFactorEnrollResp{
+		Secret:        secret,
+		OTPAuthURI:    totp.URI(h.totpIssuer(), u.Email, secret),
+		RecoveryCodes: codes,
+	})
+}
+
+// ConfirmTwoFactor godoc
+// @Summary Turn 2FA on
+// @Description Confirms the enrolment with a code from the authenticator app.
+// @Tags Two-factor
+// @Accept json
+// @Security BearerAuth
+// @Param payload body codeReq true "TOTP code"
+// @Success 204
+// @Failure 400 {object} errorResp
+// @Failure 401 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/2fa/confirm [post]
+func (h *AuthHandler) ConfirmTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	userID := c.GetUint("user_id")
+
+	var req codeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tf, err := h.TwoFactor.Get(userID)
+	if errors.Is(err, repo.ErrTwoFactorNotFound) || (err == nil && tf.EnabledAt != nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no pending enrolment, start one with /auth/2fa/enroll"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	// only an authenticator code proves the app was set up
+	step, ok := totp.Validate(tf.Secret, req.Code, time.Now())
+	if ok {
+		ok, err = h.TwoFactor.UseStep(userID, step)
+	}
+	if err == nil && ok {
+		err = h.TwoFactor.Enable(userID)
+	}
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("2fa: failed to enable (trace_id=%s, user_id=%d, err=%v)", traceID, userID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return
+	}
+
+	logger.Info(ctx, fmt.Sprintf("2fa: enabled (trace_id=%s, user_id=%d)", traceID, userID))
+	c.Status(http.StatusNoContent)
+}
+
+// DisableTwoFactor godoc
+// @Summary Turn 2FA off
+// @Description Needs the password (or, for accounts without one, a sign-in within 10 minutes) and a TOTP or recovery code.
+// @Tags Two-factor
+// @Accept json
+// @Security BearerAuth
+// @Param payload body disableTwoFactorReq true "Password and code"
+// @Success 204
+// @Failure 400 {object} errorResp
+// @Failure 401 {object} errorResp
+// @Failure 403 {object} errorResp
+// @Failure 423 {object} errorResp
+// @Failure 429 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/2fa/disable [post]
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	userID := c.GetUint("user_id")
+
+	var req disableTwoFactorReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tf, ok := h.enabledTwoFactor(c, userID)
+	if !ok {
+		return
+	}
+	u, ok := h.currentUser(c)
+	if !ok || !h.requirePassword(c, u, req.Password) {
+		return
+	}
+	if !h.requireCode(c, tf, req.Code) {
+		return
+	}
+
+	if err := h.TwoFactor.Disable(userID); err != nil {
+		logger.Error(ctx, fmt.Sprintf("2fa: failed to disable (trace_id=%s, user_id=%d, err=%v)", traceID, userID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	logger.Info(ctx, fmt.Sprintf("2fa: disabled (trace_id=%s, user_id=%d)", traceID, userID))
+	c.Status(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Replace the 2FA recovery codes
+// @Description Needs a TOTP or recovery code. The old recovery codes stop working.
+// @Tags Two-factor
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body codeReq true "TOTP or recovery code"
+// @Success 200 {object} recoveryCodesResp
+// @Failure 400 {object} errorResp
+// @Failure 401 {object} errorResp
+// @Failure 500 {object} errorResp
+// @Router /auth/2fa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	ctx := c.Request.Context()
+	traceID := getTraceID(c)
+	userID := c.GetUint("user_id")
+
+	var req codeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tf, ok := h.enabledTwoFactor(c, userID)
+	if !ok || !h.requireCode(c, tf, req.Code) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = h.TwoFactor.ReplaceRecoveryCodes(userID, hashes)
+	}
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("2fa: failed to replace recovery codes (trace_id=%s, user_id=%d, err=%v)", traceID, userID, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	logger.Info(ctx, fmt.Sprintf("2fa: recovery codes replaced (trace_id=%s, user_id=%d)", traceID, userID))
+	c.JSON(http.StatusOK, recoveryCodesResp{RecoveryCodes: codes})
+}
+
+// enabledTwoFactor loads the user's active enrolment, answering 400 if 2FA is off.
+func (h *AuthHandler) enabledTwoFactor(c *gin.Context, userID uint) (*model.TwoFactor, bool) {
+	tf, err := h.TwoFactor.Get(userID)
+	if errors.Is(err, repo.ErrTwoFactorNotFound) || (err == nil && tf.EnabledAt == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is off"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return nil, false
+	}
+	return tf, true
+}
+
+// requireCode answers 401 unless code is a valid TOTP or recovery code.
+// Wrong codes count as failed logins.
+func (h *AuthHandler) requireCode(c *gin.Context, tf *model.TwoFactor, code string) bool {
+	account := repo.AccountKey(tf.UserID, "")
+	if h.throttled(c, account) {
+		return false
+	}
+	ok, err := h.checkCode(tf, code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return false
+	}
+	if !ok {
+		h.loginFailed(c, nil, account)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// Purposes of action tokens
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	PurposeChangeEmail   = "change_email" // Email is the new address
+)
+
+// ActionToken records a signed single-use token sent by email. The token is
+// a JWT whose jti is the ID; the row makes it single-use.
+type ActionToken struct {
+	ID        string `gorm:"primaryKey;size:36"` // jti
+	UserID    uint   `gorm:"index;not null"`
+	Purpose   string `gorm:"size:32;not null"`
+	Email     string `gorm:"not null"` // address the token was sent to
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
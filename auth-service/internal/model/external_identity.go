@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// ExternalIdentity links a user to their account at an OpenID provider, so
+// they can sign in there instead of with a password.
+type ExternalIdentity struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index;not null"`
+	Provider    string `gorm:"size:50;uniqueIndex:idx_external_identity;not null"`
+	Subject     string `gorm:"size:255;uniqueIndex:idx_external_identity;not null"` // the provider's user ID
+	Email       string // as the provider knew it when linked
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+// OIDCLogin is a sign-in sent to an OpenID provider and not back yet. It is
+// found by the hash of its state and used once.
+type OIDCLogin struct {
+	StateHash    string `gorm:"primaryKey;size:64"`
+	Provider     string `gorm:"size:50;not null"`
+	Nonce        string `gorm:"size:64;not null"`
+	CodeVerifier string `gorm:"size:64;not null"` // PKCE
+	BrowserHash  string `gorm:"size:64"`          // of the cookie binding the login to the browser
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
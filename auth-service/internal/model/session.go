@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// Session is one login of a user, kept alive by a refresh token family: its
+// ID is the family's FamilyID, and access tokens carry it as sid.
+type Session struct {
+	ID         string `gorm:"primaryKey;size:36"`
+	UserID     uint   `gorm:"index;not null"`
+	Device     string `gorm:"size:100"` // guessed from the user agent
+	UserAgent  string `gorm:"size:512"`
+	IP         string `gorm:"size:45"` // of the login or the last refresh
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time  // of the current refresh token
+	RevokedAt  *time.Time // set on logout, reuse detection or sign-out
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// RefreshToken is a server-side refresh token. Only its SHA-256 hash is
+// stored. Tokens rotate on every use; all tokens descending from one login
+// share a FamilyID, so reusing a rotated token revokes the whole family.
+type RefreshToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	FamilyID  string `gorm:"size:36;index;not null"`
+	TokenHash string `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time
+	RotatedAt *time.Time // set when exchanged for a new token
+	RevokedAt *time.Time // set on logout or reuse detection
+
+	// access token issued with this refresh token, denylisted on revocation
+	AccessJTI       string `gorm:"size:36"`
+	AccessExpiresAt time.Time
+
+	CreatedAt time.Time
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// PurposeTwoFactorChallenge is the action token returned by login when the
+// user has 2FA on, exchanged for tokens with a code.
+const PurposeTwoFactorChallenge = "2fa_challenge"
+
+// TwoFactor is a user's TOTP enrolment. It is active once EnabledAt is set,
+// after the user confirmed a first code.
+type TwoFactor struct {
+	UserID       uint   `gorm:"primaryKey"`
+	Secret       string `gorm:"size:64;not null"` // base32 TOTP secret
+	EnabledAt    *time.Time
+	LastUsedStep int64 // newest time step used, so a code works once
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// RecoveryCode is a single-use code replacing a TOTP code when the
+// authenticator is lost. Only its SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"size:64;not null"`
+	UsedAt   *time.Time
+}
@@ -1,10 +1,21 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
-	gorm.Model `swaggerignore:"true"`
-	Email      string `json:"email" gorm:"uniqueIndex;not null" example:"user@example.com"`
-	Username   string `json:"username" gorm:"uniqueIndex;not null" example:"username123"`
-	Password   string `json:"-"` // hashed password
+	gorm.Model      `swaggerignore:"true"`
+	Email           string     `json:"email" gorm:"uniqueIndex;not null" example:"user@example.com"`
+	Username        string     `json:"username" gorm:"uniqueIndex;not null" example:"username123"`
+	Password        string     `json:"-"`                                                     // hashed password
+	Roles           []string   `json:"roles" gorm:"serializer:json" example:"customer,admin"` // empty means customer
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+
+	// profile, edited by the user
+	DisplayName    string `json:"display_name" gorm:"size:100" example:"Jane Doe"`
+	Phone          string `json:"phone" gorm:"size:32" example:"+84901234567"`
+	DefaultAddress string `json:"default_address" gorm:"size:500" example:"123 Main St"`
 }
@@ -0,0 +1,261 @@
+// Package oidc signs users in with an external OpenID Connect provider,
+// using the authorization code flow with PKCE (RFC 7636). Providers are
+// configured by issuer URL; their endpoints and keys are discovered.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+// DefaultScopes are requested when a provider has none configured.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// Config is one provider, as registered with it.
+type Config struct {
+	Name         string // in our URLs, e.g. "google"
+	Issuer       string // e.g. https://accounts.google.com
+	ClientID     string
+	ClientSecret string // empty for public clients, which rely on PKCE alone
+	RedirectURL  string // our callback, registered with the provider
+	Scopes       []string
+}
+
+// Identity is the user as the provider knows them, from the ID token.
+type Identity struct {
+	Subject       string // stable ID of the user at the provider
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// metadata is the part of the discovery document used here
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider talks to one OpenID provider. Discovery happens on first use, so
+// a provider that is down at startup does not stop the service.
+type Provider struct {
+	Config
+
+	client *http.Client
+
+	mu       sync.Mutex
+	meta     *metadata
+	verifier *middleware.Verifier // ID tokens, against the provider's JWKS
+}
+
+func NewProvider(cfg Config) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultScopes
+	} else if !slices.Contains(cfg.Scopes, "openid") {
+		// without it there is no ID token
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	return &Provider{
+		Config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// discover fetches the provider's metadata once it succeeds.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	u := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery returned status %d", resp.StatusCode)
+	}
+
+	var m metadata
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid discovery document: %w", err)
+	}
+	// OpenID Connect Discovery 1.0, section 4.3
+	if m.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", m.Issuer, p.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	p.meta = &m
+	p.verifier = middleware.NewVerifier(m.JWKSURI, m.Issuer, p.ClientID, 0)
+	return p.meta, nil
+}
+
+// NewState returns a random value for the state, nonce or PKCE verifier.
+func NewState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// challenge is the S256 PKCE challenge of verifier
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the user is sent to sign in. The provider sends them
+// back to RedirectURL with a code and state.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+type tokenResp struct {
+	IDToken string `json:"id_token"`
+}
+
+// Exchange redeems the code with the PKCE verifier and returns the identity
+// in the ID token, which must carry nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	// client_secret_basic, unless the provider only takes the secret in the body
+	postSecret := p.ClientSecret != "" && len(m.TokenAuthMethods) > 0 &&
+		!slices.Contains(m.TokenAuthMethods, "client_secret_basic") &&
+		slices.Contains(m.TokenAuthMethods, "client_secret_post")
+	if postSecret {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" && !postSecret {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("token request returned status %d: %s", resp.StatusCode, body)
+	}
+	var tr tokenResp
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if tr.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return p.verify(ctx, tr.IDToken, nonce)
+}
+
+// idClaims are the ID token claims used here
+type idClaims struct {
+	Nonce         string   `json:"nonce"`
+	AuthorizedBy  string   `json:"azp"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	Name          string   `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// flexBool also accepts "true" and "false", which some providers send
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+// verify checks the ID token's signature, issuer, audience, expiry and nonce.
+func (p *Provider) verify(ctx context.Context, idToken, nonce string) (*Identity, error) {
+	var claims idClaims
+	if err := p.verifier.ParseClaims(ctx, idToken, p.ClientID, &claims); err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+	if claims.AuthorizedBy != "" && claims.AuthorizedBy != p.ClientID {
+		return nil, errors.New("id_token issued to another client")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
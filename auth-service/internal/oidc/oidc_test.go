@@ -0,0 +1,311 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+const (
+	testClientID     = "shop"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "http://localhost:8888/auth/oidc/mock/callback"
+)
+
+// mockProvider is an OpenID provider serving discovery, a JWKS and a token
+// endpoint that checks the code, PKCE verifier and client secret.
+type mockProvider struct {
+	t      *testing.T
+	srv    *httptest.Server
+	key    middleware.SigningKey
+	signer *middleware.Signer
+
+	authMethods []string // token_endpoint_auth_methods_supported
+	issuer      string   // in the discovery document, the server URL if empty
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+	// claims changes the ID token claims before signing
+	claims func(c jwt.MapClaims)
+}
+
+// authRequest is what the user agreed to at the authorization endpoint
+type authRequest struct {
+	nonce     string
+	challenge string
+	subject   string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := middleware.GenerateSigningKey(middleware.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := middleware.NewSigner([]middleware.SigningKey{key}, "", "", "")
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+	m := &mockProvider{t: t, key: key, signer: signer, codes: map[string]authRequest{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(m.signer.JWKS())
+	})
+	mux.HandleFunc("/token", m.token)
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := m.issuer
+	if issuer == "" {
+		issuer = m.srv.URL
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                m.srv.URL + "/authorize",
+		"token_endpoint":                        m.srv.URL + "/token",
+		"jwks_uri":                              m.srv.URL + "/jwks",
+		"token_endpoint_auth_methods_supported": m.authMethods,
+	})
+}
+
+// authorize plays the user signing in at the authorization URL and returns
+// the code the provider sends back.
+func (m *mockProvider) authorize(authURL, subject string) (code, state string) {
+	m.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatalf("parse auth url: %v", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		m.t.Fatalf("auth url has no S256 PKCE challenge: %s", authURL)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code = "code-" + subject
+	m.codes[code] = authRequest{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), subject: subject}
+	return code, q.Get("state")
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	} else {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	if id != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	req, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != testRedirectURL ||
+		challenge(r.PostForm.Get("code_verifier")) != req.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.srv.URL,
+		"aud":            testClientID,
+		"sub":            req.subject,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          req.nonce,
+		"email":          req.subject + "@example.com",
+		"email_verified": "true", // as a string, like some providers
+		"name":           "Jane Doe",
+	}
+	if m.claims != nil {
+		m.claims(claims)
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	tok.Header["kid"] = m.key.KID
+	idToken, err := tok.SignedString(m.key.Key)
+	if err != nil {
+		m.t.Errorf("sign id token: %v", err)
+		http.Error(w, `{"error":"server_error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+}
+
+func (m *mockProvider) provider() *Provider {
+	return NewProvider(Config{
+		Name:         "mock",
+		Issuer:       m.srv.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+}
+
+// start begins a sign-in and returns the code and the values kept for the
+// callback.
+func start(t *testing.T, m *mockProvider, p *Provider, subject string) (code, verifier, nonce string) {
+	t.Helper()
+	state, _ := NewState()
+	nonce, _ = NewState()
+	verifier, _ = NewState()
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, gotState := m.authorize(authURL, subject)
+	if gotState != state {
+		t.Fatalf("state = %q, want %q", gotState, state)
+	}
+	return code, verifier, nonce
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "st", "no", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	if !strings.HasPrefix(authURL, m.srv.URL+"/authorize?") {
+		t.Fatalf("auth url = %s, want the discovered endpoint", authURL)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	for k, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "st",
+		"nonce":                 "no",
+		"code_challenge":        challenge("verifier"),
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(k); got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestNewProviderAddsOpenIDScope(t *testing.T) {
+	p := NewProvider(Config{Scopes: []string{"email"}})
+	if got := strings.Join(p.Scopes, " "); got != "openid email" {
+		t.Errorf("scopes = %q, want %q", got, "openid email")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	for _, methods := range [][]string{nil, {"client_secret_basic"}, {"client_secret_post"}} {
+		name := strings.Join(methods, ",")
+		if name == "" {
+			name = "no auth methods advertised"
+		}
+		t.Run(name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.authMethods = methods
+			p := m.provider()
+
+			code, verifier, nonce := start(t, m, p, "user-1")
+			id, err := p.Exchange(context.Background(), code, verifier, nonce)
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			want := Identity{Subject: "user-1", Email: "user-1@example.com", EmailVerified: true, Name: "Jane Doe"}
+			if *id != want {
+				t.Errorf("identity = %+v, want %+v", *id, want)
+			}
+
+			// codes are used once
+			if _, err := p.Exchange(context.Background(), code, verifier, nonce); err == nil {
+				t.Error("second exchange of the code succeeded")
+			}
+		})
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   func(c jwt.MapClaims)
+		verifier string // instead of the one sent in the challenge
+		nonce    string // instead of the one sent
+	}{
+		{name: "wrong PKCE verifier", verifier: "not-the-verifier"},
+		{name: "nonce mismatch", nonce: "other-nonce"},
+		{name: "other audience", claims: func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{name: "other issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "other authorized party", claims: func(c jwt.MapClaims) { c["azp"] = "someone-else" }},
+		{name: "no subject", claims: func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.claims = tt.claims
+			p := m.provider()
+
+			code, verifier, nonce := start(t, m, p, "user-1")
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			if id, err := p.Exchange(context.Background(), code, verifier, nonce); err == nil {
+				t.Fatalf("Exchange succeeded with %+v", *id)
+			}
+		})
+	}
+}
+
+func TestExchangeUnverifiedEmail(t *testing.T) {
+	m := newMockProvider(t)
+	m.claims = func(c jwt.MapClaims) { c["email_verified"] = false }
+	p := m.provider()
+
+	code, verifier, nonce := start(t, m, p, "user-2")
+	id, err := p.Exchange(context.Background(), code, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if id.EmailVerified {
+		t.Error("email reported verified")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	m.issuer = "https://accounts.example.com"
+	p := m.provider()
+
+	if _, err := p.AuthCodeURL(context.Background(), "st", "no", "verifier"); err == nil {
+		t.Fatal("AuthCodeURL succeeded with a mismatched issuer")
+	}
+}
@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrActionTokenInvalid = errors.New("invalid token")
+	ErrActionTokenUsed    = errors.New("token already used")
+	ErrActionTokenExpired = errors.New("token expired")
+)
+
+type ActionTokenRepo struct {
+	db *gorm.DB
+}
+
+func NewActionTokenRepo(db *gorm.DB) *ActionTokenRepo {
+	return &ActionTokenRepo{db: db}
+}
+
+func (r *ActionTokenRepo) Create(t *model.ActionToken) error {
+	return r.db.Create(t).Error
+}
+
+// Use marks the token used and returns it. It fails if the token is unknown,
+// issued for another purpose, already used or expired.
+func (r *ActionTokenRepo) Use(id, purpose string) (*model.ActionToken, error) {
+	var t model.ActionToken
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND purpose = ?", id, purpose).
+			First(&t).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrActionTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if t.UsedAt != nil {
+			return ErrActionTokenUsed
+		}
+		now := time.Now()
+		if now.After(t.ExpiresAt) {
+			return ErrActionTokenExpired
+		}
+		t.UsedAt = &now
+		return tx.Model(&t).Update("used_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RevokeAll uses up the user's outstanding tokens for purpose, e.g. older
+// reset links once the password has been reset.
+func (r *ActionTokenRepo) RevokeAll(userID uint, purpose string) error {
+	return r.db.Model(&model.ActionToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
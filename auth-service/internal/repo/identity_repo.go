@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOIDCStateInvalid = errors.New("invalid or expired login state")
+	ErrIdentityNotFound = errors.New("external identity not found")
+)
+
+// IdentityRepo stores external identities and the OpenID sign-ins in
+// progress.
+type IdentityRepo struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepo(db *gorm.DB) *IdentityRepo {
+	return &IdentityRepo{db: db}
+}
+
+// StartLogin records a sign-in sent to a provider, dropping those that
+// expired without coming back.
+func (r *IdentityRepo) StartLogin(l *model.OIDCLogin) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&model.OIDCLogin{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(l).Error
+}
+
+// TakeLogin removes the sign-in with the state hash and returns it. It fails
+// with ErrOIDCStateInvalid if there is none for provider or it expired.
+func (r *IdentityRepo) TakeLogin(stateHash, provider string) (*model.OIDCLogin, error) {
+	var logins []model.OIDCLogin
+	err := r.db.Clauses(clause.Returning{}).
+		Where("state_hash = ? AND provider = ?", stateHash, provider).
+		Delete(&logins).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(logins) == 0 || time.Now().After(logins[0].ExpiresAt) {
+		return nil, ErrOIDCStateInvalid
+	}
+	return &logins[0], nil
+}
+
+// Find returns the identity of subject at provider and records its use.
+func (r *IdentityRepo) Find(provider, subject string) (*model.ExternalIdentity, error) {
+	var id model.ExternalIdentity
+	err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	id.LastLoginAt = time.Now()
+	if err := r.db.Model(&id).Update("last_login_at", id.LastLoginAt).Error; err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func (r *IdentityRepo) Link(id *model.ExternalIdentity) error {
+	id.LastLoginAt = time.Now()
+	return r.db.Create(id).Error
+}
+
+// CreateUser registers u with their first identity.
+func (r *IdentityRepo) CreateUser(u *model.User, id *model.ExternalIdentity) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(u).Error; err != nil {
+			return err
+		}
+		id.UserID = u.ID
+		id.LastLoginAt = time.Now()
+		return tx.Create(id).Error
+	})
+}
+
+// UnlinkAll removes every identity of the user.
+func (r *IdentityRepo) UnlinkAll(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&model.ExternalIdentity{}).Error
+}
@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginPolicy sets how failed logins are throttled.
+type LoginPolicy struct {
+	DelayAfter   int           // failures of an account before each retry is delayed
+	LockoutAfter int           // failures of an account that lock it
+	LockoutFor   time.Duration // how long a lockout lasts
+	IPDelayAfter int           // failures from one IP, any account, before delays
+	MaxDelay     time.Duration // longest delay between attempts
+	Window       time.Duration // failures are forgotten after this long without one
+}
+
+// DefaultLoginPolicy delays an account after 3 failures and locks it for
+// 15 minutes after 10.
+var DefaultLoginPolicy = LoginPolicy{
+	DelayAfter:   3,
+	LockoutAfter: 10,
+	LockoutFor:   15 * time.Minute,
+	IPDelayAfter: 20,
+	MaxDelay:     30 * time.Second,
+	Window:       15 * time.Minute,
+}
+
+// LoginAttempts counts failed logins in Redis, per account and per client
+// IP. Each failure past a threshold makes the next attempt wait twice as
+// long; too many failures on one account lock it. Accounts are identified by
+// AccountKey so unknown logins are throttled like real ones.
+type LoginAttempts struct {
+	rdb    *redis.Client
+	policy LoginPolicy
+}
+
+func NewLoginAttempts(rdb *redis.Client, policy LoginPolicy) *LoginAttempts {
+	return &LoginAttempts{rdb: rdb, policy: policy}
+}
+
+// AccountKey identifies the account a login attempt is for: the user when
+// the login matched one, else the login itself.
+func AccountKey(userID uint, login string) string {
+	if userID != 0 {
+		return fmt.Sprintf("user:%d", userID)
+	}
+	return "login:" + strings.ToLower(strings.TrimSpace(login))
+}
+
+func failKey(scope, id string) string { return "login:fail:" + scope + ":" + id }
+func waitKey(scope, id string) string { return "login:wait:" + scope + ":" + id }
+func lockKey(account string) string   { return "login:locked:" + account }
+
+// Check returns how long the client must wait before trying account again
+// from ip. locked reports that the wait is an account lockout.
+func (a *LoginAttempts) Check(ctx context.Context, account, ip string) (time.Duration, bool, error) {
+	pipe := a.rdb.Pipeline()
+	lock := pipe.PTTL(ctx, lockKey(account))
+	accountWait := pipe.PTTL(ctx, waitKey("account", account))
+	ipWait := pipe.PTTL(ctx, waitKey("ip", ip))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, false, err
+	}
+
+	if d := lock.Val(); d > 0 {
+		return d, true, nil
+	}
+	return max(accountWait.Val(), ipWait.Val(), 0), false, nil
+}
+
+// Failed records a failed attempt on account from ip. It reports whether
+// this failure locked the account.
+func (a *LoginAttempts) Failed(ctx context.Context, account, ip string) (bool, error) {
+	pipe := a.rdb.TxPipeline()
+	accountFails := pipe.Incr(ctx, failKey("account", account))
+	pipe.Expire(ctx, failKey("account", account), a.policy.Window)
+	ipFails := pipe.Incr(ctx, failKey("ip", ip))
+	pipe.Expire(ctx, failKey("ip", ip), a.policy.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	n := int(accountFails.Val())
+	if a.policy.LockoutAfter > 0 && n >= a.policy.LockoutAfter {
+		// only the failure that sets the lock reports it
+		return a.rdb.SetNX(ctx, lockKey(account), n, a.policy.LockoutFor).Result()
+	}
+
+	pipe = a.rdb.Pipeline()
+	if d := a.delay(n, a.policy.DelayAfter); d > 0 {
+		pipe.Set(ctx, waitKey("account", account), 1, d)
+	}
+	if d := a.delay(int(ipFails.Val()), a.policy.IPDelayAfter); d > 0 {
+		pipe.Set(ctx, waitKey("ip", ip), 1, d)
+	}
+	_, err := pipe.Exec(ctx)
+	return false, err
+}
+
+// delay is 1s for the first failure past after, doubling up to MaxDelay
+func (a *LoginAttempts) delay(failures, after int) time.Duration {
+	if after <= 0 || failures < after {
+		return 0
+	}
+	d := time.Second
+	for i := after; i < failures && d < a.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, a.policy.MaxDelay)
+}
+
+// Succeeded forgets the account's failures. The IP's are kept, so an
+// attacker cannot reset them by logging in to an account of their own.
+func (a *LoginAttempts) Succeeded(ctx context.Context, account string) error {
+	return a.rdb.Del(ctx, failKey("account", account), waitKey("account", account)).Err()
+}
+
+// Unlock lifts the account's lockout and forgets its failures.
+func (a *LoginAttempts) Unlock(ctx context.Context, account string) error {
+	return a.rdb.Del(ctx, lockKey(account), failKey("account", account), waitKey("account", account)).Err()
+}
+
+// LockoutFor is how long a lockout lasts
+func (a *LoginAttempts) LockoutFor() time.Duration {
+	return a.policy.LockoutFor
+}
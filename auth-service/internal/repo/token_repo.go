@@ -0,0 +1,198 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	// ErrRefreshTokenReused means a rotated or revoked token was presented
+	// again; its family has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	ErrSessionNotFound    = errors.New("session not found")
+)
+
+type TokenRepo struct {
+	db *gorm.DB
+}
+
+func NewTokenRepo(db *gorm.DB) *TokenRepo {
+	return &TokenRepo{db: db}
+}
+
+// CreateSession starts a login session with its first refresh token.
+func (r *TokenRepo) CreateSession(s *model.Session, t *model.RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+		t.FamilyID = s.ID
+		return tx.Create(t).Error
+	})
+}
+
+// Rotate exchanges the refresh token with the given hash for the one built
+// by next, in the same family, and records the use of the session from ip.
+// On reuse the family is revoked and its tokens are returned with
+// ErrRefreshTokenReused, so their access tokens can be denylisted.
+func (r *TokenRepo) Rotate(hash, ip string, next func(userID uint, familyID string) (*model.RefreshToken, error)) (*model.RefreshToken, []model.RefreshToken, error) {
+	var current model.RefreshToken
+	var revoked []model.RefreshToken
+	reused := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hash).
+			First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRefreshTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if current.RotatedAt != nil || current.RevokedAt != nil {
+			revoked, err = revokeFamily(tx, current.FamilyID, now)
+			if err != nil {
+				return err
+			}
+			// returning the error here would roll the revocation back
+			reused = true
+			return nil
+		}
+		if now.After(current.ExpiresAt) {
+			return ErrRefreshTokenExpired
+		}
+
+		if err := tx.Model(&current).Update("rotated_at", now).Error; err != nil {
+			return err
+		}
+		rt, err := next(current.UserID, current.FamilyID)
+		if err != nil {
+			return err
+		}
+		rt.UserID = current.UserID
+		rt.FamilyID = current.FamilyID
+		if err := tx.Create(rt).Error; err != nil {
+			return err
+		}
+		// families from before sessions existed have no session row
+		return tx.Model(&model.Session{}).Where("id = ?", current.FamilyID).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"expires_at":   rt.ExpiresAt,
+			"ip":           ip,
+		}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if reused {
+		return &current, revoked, ErrRefreshTokenReused
+	}
+	return &current, nil, nil
+}
+
+// RevokeFamilyOf revokes the family of the refresh token with the given
+// hash and returns the tokens it revoked.
+func (r *TokenRepo) RevokeFamilyOf(hash string) ([]model.RefreshToken, error) {
+	var t model.RefreshToken
+	err := r.db.Where("token_hash = ?", hash).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return revokeFamily(r.db, t.FamilyID, time.Now())
+}
+
+// revokeFamily ends the session of the family, marking every live token of
+// it revoked, and returns them.
+func revokeFamily(tx *gorm.DB, familyID string, now time.Time) ([]model.RefreshToken, error) {
+	return revokeWhere(tx, now, "family_id = ?", familyID)
+}
+
+// revokeWhere revokes the live refresh tokens matching the condition and
+// the sessions of their families, and returns the tokens.
+func revokeWhere(db *gorm.DB, now time.Time, query string, args ...interface{}) ([]model.RefreshToken, error) {
+	var tokens []model.RefreshToken
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&tokens).
+			Clauses(clause.Returning{}).
+			Where("revoked_at IS NULL").
+			Where(query, args...).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		if len(tokens) == 0 {
+			return nil
+		}
+		families := make([]string, 0, len(tokens))
+		for _, t := range tokens {
+			families = append(families, t.FamilyID)
+		}
+		return tx.Model(&model.Session{}).
+			Where("id IN ? AND revoked_at IS NULL", families).
+			Update("revoked_at", now).Error
+	})
+	return tokens, err
+}
+
+// RevokeAllForUser revokes every live refresh token of the user, ending all
+// of their sessions, and returns the tokens it revoked.
+func (r *TokenRepo) RevokeAllForUser(userID uint) ([]model.RefreshToken, error) {
+	return revokeWhere(r.db, time.Now(), "user_id = ?", userID)
+}
+
+// ListSessions returns the user's sessions that are neither revoked nor
+// expired, most recently used first.
+func (r *TokenRepo) ListSessions(userID uint) ([]model.Session, error) {
+	var sessions []model.Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// GetSession returns the user's live session with the given ID, or
+// ErrSessionNotFound.
+func (r *TokenRepo) GetSession(userID uint, sessionID string) (*model.Session, error) {
+	var s model.Session
+	err := r.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, userID, time.Now()).
+		First(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// RevokeSession ends one session of the user and returns the refresh
+// tokens it revoked. It returns ErrSessionNotFound if the user has no such
+// live session.
+func (r *TokenRepo) RevokeSession(userID uint, sessionID string) ([]model.RefreshToken, error) {
+	var n int64
+	if err := r.db.Model(&model.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Count(&n).Error; err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrSessionNotFound
+	}
+	return revokeFamily(r.db, sessionID, time.Now())
+}
+
+// RevokeOthers revokes every live refresh token of the user outside the
+// family keepFamily, and returns the tokens it revoked.
+func (r *TokenRepo) RevokeOthers(userID uint, keepFamily string) ([]model.RefreshToken, error) {
+	return revokeWhere(r.db, time.Now(), "user_id = ? AND family_id <> ?", userID, keepFamily)
+}
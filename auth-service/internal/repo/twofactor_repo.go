@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrTwoFactorNotFound = errors.New("two-factor authentication is not set up")
+
+type TwoFactorRepo struct {
+	db *gorm.DB
+}
+
+func NewTwoFactorRepo(db *gorm.DB) *TwoFactorRepo {
+	return &TwoFactorRepo{db: db}
+}
+
+// Get returns the user's enrolment, enabled or not.
+func (r *TwoFactorRepo) Get(userID uint) (*model.TwoFactor, error) {
+	var tf model.TwoFactor
+	err := r.db.First(&tf, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTwoFactorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// Enabled reports whether the user has 2FA on.
+func (r *TwoFactorRepo) Enabled(userID uint) (bool, error) {
+	var n int64
+	err := r.db.Model(&model.TwoFactor{}).
+		Where("user_id = ? AND enabled_at IS NOT NULL", userID).
+		Count(&n).Error
+	return n > 0, err
+}
+
+// Enroll starts a new, not yet enabled, enrolment with secret and recovery
+// codes, replacing any previous pending one.
+func (r *TwoFactorRepo) Enroll(userID uint, secret string, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		tf := model.TwoFactor{UserID: userID, Secret: secret}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"secret": secret, "enabled_at": nil, "last_used_step": 0, "updated_at": time.Now()}),
+		}).Create(&tf).Error; err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+// Enable turns on a pending enrolment.
+func (r *TwoFactorRepo) Enable(userID uint) error {
+	return r.db.Model(&model.TwoFactor{}).
+		Where("user_id = ? AND enabled_at IS NULL", userID).
+		Update("enabled_at", time.Now()).Error
+}
+
+// Disable removes the enrolment and its recovery codes.
+func (r *TwoFactorRepo) Disable(userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.TwoFactor{}).Error
+	})
+}
+
+// UseStep records that the code of step was used. It returns false if that
+// step, or a later one, was used already.
+func (r *TwoFactorRepo) UseStep(userID uint, step int64) (bool, error) {
+	res := r.db.Model(&model.TwoFactor{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	return res.RowsAffected > 0, res.Error
+}
+
+// UseRecoveryCode uses up the recovery code with the given hash. It returns
+// false if there is no such unused code.
+func (r *TwoFactorRepo) UseRecoveryCode(userID uint, hash string) (bool, error) {
+	res := r.db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	return res.RowsAffected > 0, res.Error
+}
+
+// ReplaceRecoveryCodes swaps the user's recovery codes for new ones.
+func (r *TwoFactorRepo) ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+// RecoveryCodesLeft counts the user's unused recovery codes.
+func (r *TwoFactorRepo) RecoveryCodesLeft(userID uint) (int64, error) {
+	var n int64
+	err := r.db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&n).Error
+	return n, err
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, codeHashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]model.RecoveryCode, 0, len(codeHashes))
+	for _, h := range codeHashes {
+		codes = append(codes, model.RecoveryCode{UserID: userID, CodeHash: h})
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return tx.Create(&codes).Error
+}
@@ -2,16 +2,39 @@ package repo
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/phanthehoang2503/small-project/auth-service/internal/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var ErrNotFound = errors.New("user not found")
+var (
+	ErrNotFound   = errors.New("user not found")
+	ErrEmailTaken = errors.New("email already in use")
+)
 
 type UserRepo interface {
 	Create(u *model.User) error
 	GetUser(value string) (*model.User, error)
+	GetByID(id uint) (*model.User, error)
+	// GetByEmail finds the user with the email in any letter case,
+	// preferring one whose email is verified.
+	GetByEmail(email string) (*model.User, error)
+	// UpdateRoles replaces the user's roles with change(current roles),
+	// holding a lock on the user row.
+	UpdateRoles(id uint, change func(roles []string) ([]string, error)) (*model.User, error)
+	// MarkEmailVerified marks the user's email verified if it is still email.
+	MarkEmailVerified(id uint, email string) error
+	UpdatePassword(id uint, hashed string) error
+	// UpdateProfile sets the given profile columns and returns the user.
+	UpdateProfile(id uint, fields map[string]interface{}) (*model.User, error)
+	// ChangeEmail replaces the user's email with a verified one. It returns
+	// ErrEmailTaken if another account has it.
+	ChangeEmail(id uint, email string) error
+	// Anonymize wipes the user's personal data and deletes the account.
+	Anonymize(id uint) error
 }
 
 type userRepoDB struct {
@@ -36,3 +59,151 @@ func (r *userRepoDB) GetUser(value string) (*model.User, error) {
 	}
 	return &u, nil
 }
+
+func (r *userRepoDB) GetByEmail(email string) (*model.User, error) {
+	var u model.User
+	err := r.db.Where("lower(email) = lower(?)", email).
+		Order("email_verified_at IS NULL, id").
+		First(&u).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *userRepoDB) GetByID(id uint) (*model.User, error) {
+	var u model.User
+	if err := r.db.First(&u, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *userRepoDB) UpdateRoles(id uint, change func(roles []string) ([]string, error)) (*model.User, error) {
+	var u model.User
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&u, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrNotFound
+			}
+			return err
+		}
+		roles, err := change(u.Roles)
+		if err != nil {
+			return err
+		}
+		u.Roles = roles
+		return tx.Model(&u).Select("roles").Updates(&u).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *userRepoDB) MarkEmailVerified(id uint, email string) error {
+	res := r.db.Model(&model.User{}).
+		Where("id = ? AND email = ? AND email_verified_at IS NULL", id, email).
+		Update("email_verified_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// already verified is fine; a changed address is not
+		var n int64
+		if err := r.db.Model(&model.User{}).Where("id = ? AND email = ?", id, email).Count(&n).Error; err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+	}
+	return nil
+}
+
+func (r *userRepoDB) UpdatePassword(id uint, hashed string) error {
+	res := r.db.Model(&model.User{}).Where("id = ?", id).Update("password", hashed)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *userRepoDB) UpdateProfile(id uint, fields map[string]interface{}) (*model.User, error) {
+	if len(fields) > 0 {
+		res := r.db.Model(&model.User{}).Where("id = ?", id).Updates(fields)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil, ErrNotFound
+		}
+	}
+	return r.GetByID(id)
+}
+
+func (r *userRepoDB) ChangeEmail(id uint, email string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Model(&model.User{}).Where("email = ? AND id <> ?", email, id).Count(&n).Error; err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrEmailTaken
+		}
+		res := tx.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"email":             email,
+			"email_verified_at": time.Now(),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (r *userRepoDB) Anonymize(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// the placeholders keep the unique columns unique and free the
+		// address and username for new accounts; neither is one a user can
+		// register (usernames are alphanumeric)
+		res := tx.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"email":             fmt.Sprintf("deleted-%d@deleted.invalid", id),
+			"username":          fmt.Sprintf("deleted-%d", id),
+			"password":          "",
+			"roles":             nil,
+			"email_verified_at": nil,
+			"display_name":      "",
+			"phone":             "",
+			"default_address":   "",
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Delete(&model.User{}, id).Error
+	})
+}
+
+// RenameAnonymized moves accounts deleted before usernames got the
+// "deleted-<id>" placeholder off the old "deleted<id>", which could be
+// registered.
+func RenameAnonymized(db *gorm.DB) error {
+	return db.Unscoped().Model(&model.User{}).
+		Where("deleted_at IS NOT NULL AND username = 'deleted' || id").
+		Update("username", gorm.Expr("'deleted-' || id")).Error
+}
@@ -7,21 +7,91 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func RegisterRoutes(r *gin.Engine, h *handler.AuthHandler, jwtSecret []byte, loginLimiter gin.HandlerFunc) {
+func RegisterRoutes(r *gin.Engine, h *handler.AuthHandler, verifier *middleware.Verifier, loginLimiter gin.HandlerFunc) {
 	r.Use(otelgin.Middleware("auth-service"))
 	authGroup := r.Group("/auth")
 	{
 		authGroup.POST("/register", h.Register)
 		authGroup.POST("/login", loginLimiter, h.Login)
+		authGroup.POST("/refresh", h.Refresh)
+		authGroup.POST("/logout", h.Logout)
+
+		// email verification (GET for the emailed link) and password reset;
+		// requests that send email share the login rate limit
+		authGroup.GET("/verify-email", h.VerifyEmail)
+		authGroup.POST("/verify-email", h.VerifyEmail)
+		authGroup.POST("/verify-email/resend", loginLimiter, h.ResendVerification)
+		authGroup.POST("/password/forgot", loginLimiter, h.ForgotPassword)
+		authGroup.POST("/password/reset", h.ResetPassword)
+
+		// second step of a login with two-factor authentication on
+		authGroup.POST("/2fa/verify", loginLimiter, h.VerifyTwoFactor)
+
+		// sign in with an external OpenID Connect provider (GET for its redirect)
+		authGroup.GET("/oidc/providers", h.ListOIDCProviders)
+		authGroup.GET("/oidc/:provider/login", loginLimiter, h.OIDCLogin)
+		authGroup.GET("/oidc/:provider/callback", loginLimiter, h.OIDCCallback)
+		authGroup.POST("/oidc/:provider/callback", loginLimiter, h.OIDCCallback)
+	}
+
+	// Two-factor authentication of the signed-in user
+	twoFactor := r.Group("/auth/2fa")
+	twoFactor.Use(middleware.JWTMiddleware(verifier))
+	{
+		twoFactor.GET("", h.TwoFactorStatus)
+		twoFactor.POST("/enroll", h.EnrollTwoFactor)
+		twoFactor.POST("/confirm", h.ConfirmTwoFactor)
+		twoFactor.POST("/disable", h.DisableTwoFactor)
+		twoFactor.POST("/recovery-codes", h.RegenerateRecoveryCodes)
+	}
+
+	// Devices the signed-in user is logged in on
+	sessions := r.Group("/auth/sessions")
+	sessions.Use(middleware.JWTMiddleware(verifier))
+	{
+		sessions.GET("", h.ListSessions)
+		sessions.DELETE("", h.RevokeAllSessions)
+		sessions.DELETE("/:id", h.RevokeSession)
 	}
 
+	// client-credentials tokens for calls between services
+	r.POST("/auth/token", loginLimiter, h.ServiceToken)
+
+	// Public keys other services verify access tokens with
+	r.GET("/.well-known/jwks.json", h.JWKS)
+
+	// Role administration
+	users := r.Group("/auth/users")
+	users.Use(middleware.JWTMiddleware(verifier), middleware.RequirePermission(middleware.PermUsersManage))
+	{
+		users.GET("/:id/roles", h.GetRoles)
+		users.POST("/:id/roles", h.GrantRole)
+		users.DELETE("/:id/roles/:role", h.RevokeRole)
+		users.DELETE("/:id/lockout", h.UnlockUser)
+	}
+
+	// Profile of the signed-in user
+	me := r.Group("/users/me")
+	me.Use(middleware.JWTMiddleware(verifier))
+	{
+		me.GET("", h.GetMe)
+		me.PATCH("", h.UpdateMe)
+		me.DELETE("", h.DeleteMe)
+		me.POST("/email", h.ChangeEmail)
+		me.POST("/password", h.ChangePassword)
+	}
+
+	// link sent to the new address of an email change (GET for the emailed link)
+	r.GET("/users/email/confirm", h.ConfirmEmailChange)
+	r.POST("/users/email/confirm", h.ConfirmEmailChange)
+
 	// Protected API group
 	api := r.Group("/api")
-	api.Use(middleware.JWTMiddleware(jwtSecret))
+	api.Use(middleware.JWTMiddleware(verifier))
 	{
 		api.GET("/profile", func(c *gin.Context) {
-			uid, _ := c.Get("user_id")
-			c.JSON(200, gin.H{"user_id": uid})
+			claims, _ := middleware.ClaimsFrom(c)
+			c.JSON(200, gin.H{"user_id": claims.UserID, "roles": claims.Roles})
 		})
 	}
 
@@ -4,19 +4,24 @@ import (
 	"context"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/phanthehoang2503/small-project/cart-service/internal/consumer"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/handler"
 	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
 	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
 	"github.com/phanthehoang2503/small-project/cart-service/internal/router"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/scheduler"
 	"github.com/phanthehoang2503/small-project/internal/database"
 	"github.com/phanthehoang2503/small-project/internal/event"
 	"github.com/phanthehoang2503/small-project/internal/helper"
 	"github.com/phanthehoang2503/small-project/internal/middleware"
 	"github.com/phanthehoang2503/small-project/internal/telemetry"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"gorm.io/gorm"
 
 	_ "github.com/phanthehoang2503/small-project/cart-service/docs"
 	swaggerFiles "github.com/swaggo/files"
@@ -50,25 +55,57 @@ func main() {
 	b := helper.ConnectRabbit()
 	defer b.Close()
 
+	// events are sent with a service token of cart-service and only accepted
+	// from the service that publishes them
+	helper.SignEvents(b, "cart-service")
+	helper.VerifyEvents(b)
+
 	// Migrations
-	if err := db.AutoMigrate(&model.Cart{}); err != nil {
+	if err := repo.MigrateCarts(db); err != nil {
 		log.Fatalf("Migration failed (cart): %v", err)
 	}
 	if err := db.AutoMigrate(&model.ProductSnapshot{}); err != nil {
 		log.Fatalf("Migration failed (product_snapshot): %v", err)
 	}
+	if err := db.AutoMigrate(&model.OrderCartLine{}, &model.CustomerPurchase{}); err != nil {
+		log.Fatalf("Migration failed (order_cart_line): %v", err)
+	}
+	if err := db.AutoMigrate(&model.Wishlist{}, &model.WishlistItem{}); err != nil {
+		log.Fatalf("Migration failed (wishlist): %v", err)
+	}
+	if err := db.AutoMigrate(&model.CartActivity{}, &model.ReminderPreference{}); err != nil {
+		log.Fatalf("Migration failed (cart_activity): %v", err)
+	}
+	if err := db.AutoMigrate(&model.Coupon{}, &model.CouponRedemption{}, &model.CartCoupon{}); err != nil {
+		log.Fatalf("Migration failed (coupon): %v", err)
+	}
 
-	jwtSecret := []byte(os.Getenv("JWT_SECRET"))
+	verifier := helper.TokenVerifier() // access tokens are checked against auth-service's JWKS
+	verifier.AcceptServiceTokens("cart-service")
+	helper.UseTokenDenylist() // reject access tokens revoked by auth-service
+
+	// products missing from the snapshots are fetched as cart-service
+	handler.UseProductClient(helper.ServiceClient("cart-service", "product-service", 3*time.Second))
 
 	//repos
-	// cr := repo.NewCartRepo(db) // Postgres
-	redisAddr := os.Getenv("REDIS_URL")
-	if redisAddr == "" {
-		redisAddr = "localhost:6379"
+	cr, err := openCartStore(db)
+	if err != nil {
+		log.Fatalf("failed to open cart store: %v", err)
 	}
-	cr := repo.NewRedisCartRepo(redisAddr)
+	log.Printf("cart backend: %s", cartBackend())
+
+	// user cart changes record the cart's last activity, for reminders
+	activity := repo.NewActivityRepo(db)
+	cr = repo.TrackActivity(cr, activity)
 
 	pr := repo.NewProductRepo(db)
+	wr := repo.NewWishlistRepo(db)
+	coupons := repo.NewCouponRepo(db)
+	purchases := repo.NewPurchaseRepo(db)
+
+	// TAX_RATE_BPS: tax in basis points, e.g. 1000 = 10%
+	taxRate, _ := strconv.Atoi(os.Getenv("TAX_RATE_BPS"))
+	summarizer := repo.NewSummarizer(pr, coupons, purchases, taxRate)
 
 	// Setup Product Queue
 	prodQueue := "cart_products_queue"
@@ -79,7 +116,7 @@ func main() {
 		log.Fatalf("failed to bind product queue: %v", err)
 	}
 
-	pc := consumer.NewProductConsumer(pr)
+	pc := consumer.NewProductConsumer(pr, wr)
 	if err := pc.Start(event.ExchangeProduct, prodQueue, "product.*"); err != nil {
 		log.Fatalf("failed to start product consumer: %v", err)
 	}
@@ -89,20 +126,87 @@ func main() {
 	if err := b.DeclareQueue(orderQueue); err != nil {
 		log.Fatalf("failed to declare order queue: %v", err)
 	}
-	if err := b.BindQueue(orderQueue, event.ExchangeOrder, []string{event.RoutingKeyOrderCreated}); err != nil {
+	orderKeys := []string{
+		event.RoutingKeyOrderCreated,
+		event.RoutingKeyOrderCancelled,
+		event.RoutingKeyInventoryReservationFailed,
+		event.RoutingKeyPaymentSucceeded,
+	}
+	if err := b.BindQueue(orderQueue, event.ExchangeOrder, orderKeys); err != nil {
 		log.Fatalf("failed to bind order queue: %v", err)
 	}
 
-	oc := consumer.NewOrderConsumer(cr, b)
-	if err := oc.Start(event.ExchangeOrder, orderQueue, event.RoutingKeyOrderCreated); err != nil {
+	oc := consumer.NewOrderConsumer(cr, repo.NewOrderCartRepo(db), coupons, purchases, b)
+	if err := oc.Start(event.ExchangeOrder, orderQueue, orderKeys); err != nil {
 		log.Fatalf("failed to start order consumer: %v", err)
 	}
 
+	// Setup User Queue (guest cart merge on login, purge of deleted users)
+	userQueue := "cart_users_queue"
+	if err := b.DeclareQueue(userQueue); err != nil {
+		log.Fatalf("failed to declare user queue: %v", err)
+	}
+	if err := b.BindQueue(userQueue, event.ExchangeUser, []string{event.RoutingKeyUserLoggedIn, event.RoutingKeyUserDeleted}); err != nil {
+		log.Fatalf("failed to bind user queue: %v", err)
+	}
+
+	uc := consumer.NewUserConsumer(cr, cr, pr, purchases, activity, wr, b)
+	if err := uc.Start(userQueue); err != nil {
+		log.Fatalf("failed to start user consumer: %v", err)
+	}
+
+	// Resync product snapshots with product-service (on startup, then periodically)
+	if base := os.Getenv("PRODUCT_SERVICE_URL"); base != "" {
+		interval, err := time.ParseDuration(os.Getenv("SNAPSHOT_RESYNC_INTERVAL"))
+		if err != nil {
+			interval = 15 * time.Minute
+		}
+		scheduler.NewSnapshotResync(pr, base, interval).Start(context.Background())
+	} else {
+		log.Println("PRODUCT_SERVICE_URL not set, snapshot resync disabled")
+	}
+
+	// Remind users of carts left idle for CART_ABANDONED_AFTER
+	idleAfter, err := time.ParseDuration(os.Getenv("CART_ABANDONED_AFTER"))
+	if err != nil {
+		idleAfter = 4 * time.Hour
+	}
+	checkEvery, err := time.ParseDuration(os.Getenv("CART_ABANDONED_CHECK_INTERVAL"))
+	if err != nil {
+		checkEvery = 15 * time.Minute
+	}
+	if ttl, _ := repo.ParseTTL(os.Getenv("CART_TTL")); ttl > 0 && idleAfter >= ttl {
+		log.Printf("CART_ABANDONED_AFTER (%s) is not below CART_TTL (%s), carts expire before any reminder", idleAfter, ttl)
+	}
+	scheduler.NewAbandonedCarts(cr, pr, activity, idleAfter, checkEvery).Start(context.Background())
+
 	r := gin.Default()
 	r.Use(otelgin.Middleware("cart-service"))
 	r.Use(middleware.CORSMiddleware())
-	router.RegisterRoutes(r, cr, pr, jwtSecret)
+	router.RegisterRoutes(r, cr, cr, pr, summarizer, wr, coupons, purchases, activity, verifier)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	r.Run(":8082")
 }
+
+func cartBackend() string {
+	if b := os.Getenv("CART_BACKEND"); b != "" {
+		return b
+	}
+	return repo.BackendRedis
+}
+
+// openCartStore picks the cart backend from CART_BACKEND (redis or postgres)
+// and the expiry of untouched carts from CART_TTL ("0" keeps them forever).
+func openCartStore(db *gorm.DB) (repo.Store, error) {
+	ttl, err := repo.ParseTTL(os.Getenv("CART_TTL"))
+	if err != nil {
+		return nil, err
+	}
+
+	redisAddr := os.Getenv("REDIS_URL")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	return repo.OpenStore(cartBackend(), db, redisAddr, ttl)
+}
@@ -0,0 +1,102 @@
+// Command migrate copies every cart from one backend to the other, e.g. when
+// switching CART_BACKEND:
+//
+//	go run ./cart-service/cmd/migrate -from redis -to postgres
+//
+// Carts already present in the target are replaced. Run it while cart-service
+// is stopped, otherwise changes made during the copy may be lost.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/database"
+)
+
+func main() {
+	from := flag.String("from", repo.BackendRedis, "source backend (redis or postgres)")
+	to := flag.String("to", repo.BackendPostgres, "target backend (redis or postgres)")
+	dryRun := flag.Bool("dry-run", false, "only count carts")
+	flag.Parse()
+
+	if *from == *to {
+		log.Fatalf("source and target are both %s", *from)
+	}
+
+	godotenv.Load()
+
+	db, err := database.ConnectDB()
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	if err := repo.MigrateCarts(db); err != nil {
+		log.Fatalf("Migration failed (cart): %v", err)
+	}
+
+	ttl, err := repo.ParseTTL(os.Getenv("CART_TTL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	redisAddr := os.Getenv("REDIS_URL")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+
+	src, err := repo.OpenStore(*from, db, redisAddr, ttl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dst, err := repo.OpenStore(*to, db, redisAddr, ttl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var carts, lines int
+	err = src.EachCart(func(owner repo.Owner, items []model.Cart) error {
+		if len(items) == 0 {
+			return nil
+		}
+		carts++
+		lines += len(items)
+		if *dryRun {
+			return nil
+		}
+		return copyCart(dst, owner, items)
+	})
+	if err != nil {
+		log.Fatalf("migration stopped after %d carts: %v", carts, err)
+	}
+
+	log.Printf("%d carts (%d lines) copied from %s to %s", carts, lines, *from, *to)
+}
+
+func copyCart(dst repo.Store, owner repo.Owner, items []model.Cart) error {
+	if owner.GuestToken != "" {
+		if err := dst.ClearGuest(owner.GuestToken); err != nil {
+			return err
+		}
+		for _, it := range items {
+			line := model.Cart{ProductID: it.ProductID, Quantity: it.Quantity, Price: it.Price}
+			if _, err := dst.AddGuestItems(owner.GuestToken, &line, repo.NoLimit); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := dst.ClearCart(owner.UserID); err != nil {
+		return err
+	}
+	for _, it := range items {
+		line := model.Cart{UserID: owner.UserID, ProductID: it.ProductID, Quantity: it.Quantity, Price: it.Price}
+		if _, err := dst.AddNewItems(&line, repo.NoLimit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
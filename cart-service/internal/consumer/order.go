@@ -6,23 +6,33 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
 	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
 	"github.com/phanthehoang2503/small-project/internal/broker"
+	"github.com/phanthehoang2503/small-project/internal/event"
 )
 
+// OrderConsumer clears the cart when an order is placed and gives it back if
+// the order fails before payment, releasing the coupon the order used.
 type OrderConsumer struct {
-	cartRepo repo.CartRepository
-	broker   *broker.Broker
+	cartRepo  repo.CartRepository
+	orderCart *repo.OrderCartRepo
+	coupons   *repo.CouponRepo
+	purchases *repo.PurchaseRepo
+	broker    *broker.Broker
 }
 
-func NewOrderConsumer(cr repo.CartRepository, b *broker.Broker) *OrderConsumer {
+func NewOrderConsumer(cr repo.CartRepository, oc *repo.OrderCartRepo, coupons *repo.CouponRepo, pu *repo.PurchaseRepo, b *broker.Broker) *OrderConsumer {
 	return &OrderConsumer{
-		cartRepo: cr,
-		broker:   b,
+		cartRepo:  cr,
+		orderCart: oc,
+		coupons:   coupons,
+		purchases: pu,
+		broker:    b,
 	}
 }
 
-func (c *OrderConsumer) Start(exchange, queueName, routingKey string) error {
+func (c *OrderConsumer) Start(exchange, queueName string, routingKeys []string) error {
 	if err := c.broker.DeclareTopicExchange(exchange); err != nil {
 		return fmt.Errorf("failed to declare exchange: %w", err)
 	}
@@ -31,11 +41,11 @@ func (c *OrderConsumer) Start(exchange, queueName, routingKey string) error {
 		return fmt.Errorf("failed to declare queue: %w", err)
 	}
 
-	if err := c.broker.BindQueue(queueName, exchange, []string{routingKey}); err != nil {
+	if err := c.broker.BindQueue(queueName, exchange, routingKeys); err != nil {
 		return fmt.Errorf("failed to bind queue: %w", err)
 	}
 
-	if err := c.broker.Consume(queueName, c.handleOrderRequested); err != nil {
+	if err := c.broker.Consume(queueName, c.handle); err != nil {
 		return fmt.Errorf("failed to start consumer: %w", err)
 	}
 
@@ -51,7 +61,25 @@ type orderRequestedPayload struct {
 	Currency      string `json:"currency"`
 }
 
-func (c *OrderConsumer) handleOrderRequested(ctx context.Context, routingKey string, body []byte) error {
+// orderOutcomePayload is the common part of order.cancelled,
+// inventory.reservation.failed and payment.succeeded
+type orderOutcomePayload struct {
+	OrderUUID string `json:"order_uuid"`
+}
+
+func (c *OrderConsumer) handle(ctx context.Context, routingKey string, body []byte) error {
+	switch routingKey {
+	case event.RoutingKeyOrderCreated:
+		return c.handleOrderRequested(ctx, body)
+	case event.RoutingKeyOrderCancelled, event.RoutingKeyInventoryReservationFailed:
+		return c.restoreCart(body, routingKey)
+	case event.RoutingKeyPaymentSucceeded:
+		return c.discardCart(body)
+	}
+	return nil
+}
+
+func (c *OrderConsumer) handleOrderRequested(ctx context.Context, body []byte) error {
 	var payload orderRequestedPayload
 	if err := json.Unmarshal(body, &payload); err != nil {
 		return fmt.Errorf("failed to unmarshal payload: %w", err)
@@ -59,10 +87,79 @@ func (c *OrderConsumer) handleOrderRequested(ctx context.Context, routingKey str
 
 	log.Printf("Received order.requested for user %d (order %s)", payload.UserID, payload.OrderUUID)
 
+	// keep the cart until the order is paid
+	items, err := c.cartRepo.List(payload.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to read cart for user %d: %w", payload.UserID, err)
+	}
+	if err := c.orderCart.Save(payload.OrderUUID, payload.UserID, items); err != nil {
+		return fmt.Errorf("failed to save cart of order %s: %w", payload.OrderUUID, err)
+	}
+	// counted against per-customer limits until the order fails
+	if err := c.purchases.Record(payload.OrderUUID, payload.UserID, items); err != nil {
+		return fmt.Errorf("failed to record purchases of order %s: %w", payload.OrderUUID, err)
+	}
+
 	if err := c.cartRepo.ClearCart(payload.UserID); err != nil {
 		return fmt.Errorf("failed to clear cart for user %d: %w", payload.UserID, err)
 	}
+	if err := c.coupons.RemoveApplied(payload.UserID); err != nil {
+		return fmt.Errorf("failed to remove coupon from cart of user %d: %w", payload.UserID, err)
+	}
 
 	log.Printf("Cart cleared for user %d", payload.UserID)
 	return nil
 }
+
+func (c *OrderConsumer) restoreCart(body []byte, routingKey string) error {
+	var payload orderOutcomePayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	if err := c.purchases.Release(payload.OrderUUID); err != nil {
+		return fmt.Errorf("failed to release purchases of order %s: %w", payload.OrderUUID, err)
+	}
+
+	released, err := c.coupons.Release(payload.OrderUUID, 0)
+	if err != nil {
+		return fmt.Errorf("failed to release coupon of order %s: %w", payload.OrderUUID, err)
+	}
+	// the coupon goes back on the cart with the items
+	for _, red := range released {
+		if err := c.coupons.Apply(red.UserID, red.Code); err != nil {
+			log.Printf("failed to re-apply coupon %s for user %d: %v", red.Code, red.UserID, err)
+		}
+	}
+
+	lines, err := c.orderCart.Take(payload.OrderUUID)
+	if err != nil {
+		return fmt.Errorf("failed to load cart of order %s: %w", payload.OrderUUID, err)
+	}
+	if len(lines) == 0 {
+		return nil // already restored, or paid
+	}
+
+	// added on top of whatever the user put in the cart since
+	for _, l := range lines {
+		if _, err := c.cartRepo.AddNewItems(&model.Cart{
+			UserID:    l.UserID,
+			ProductID: l.ProductID,
+			Quantity:  l.Quantity,
+			Price:     l.Price,
+		}, repo.NoLimit); err != nil {
+			log.Printf("failed to restore product %d to cart of user %d: %v", l.ProductID, l.UserID, err)
+		}
+	}
+
+	log.Printf("Cart of order %s restored for user %d after %s", payload.OrderUUID, lines[0].UserID, routingKey)
+	return nil
+}
+
+func (c *OrderConsumer) discardCart(body []byte) error {
+	var payload orderOutcomePayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	return c.orderCart.Discard(payload.OrderUUID)
+}
@@ -9,18 +9,21 @@ import (
 	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
 	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
 	"github.com/phanthehoang2503/small-project/internal/broker"
+	"github.com/phanthehoang2503/small-project/internal/event"
 	"github.com/phanthehoang2503/small-project/internal/message"
 )
 
 // ProductConsumer holds dependencies
 type ProductConsumer struct {
-	repo *repo.ProductRepo
+	repo      *repo.ProductRepo
+	wishlists *repo.WishlistRepo
 }
 
 // NewProductConsumer creates consumer
-func NewProductConsumer(snapshotRepo *repo.ProductRepo) *ProductConsumer {
+func NewProductConsumer(snapshotRepo *repo.ProductRepo, wishlistRepo *repo.WishlistRepo) *ProductConsumer {
 	return &ProductConsumer{
-		repo: snapshotRepo,
+		repo:      snapshotRepo,
+		wishlists: wishlistRepo,
 	}
 }
 
@@ -40,21 +43,30 @@ func (pc *ProductConsumer) Start(exchange, queue, binding string) error {
 			log.Println("cart-service: failed to parse product event:", err)
 			return nil // ack malformed
 		}
-		pc.handleProductEvent(routingKey, ev)
+		pc.handleProductEvent(ctx, routingKey, ev)
 		return nil
 	})
 }
 
 // process events
-func (pc *ProductConsumer) handleProductEvent(routingKey string, ev message.ProductMessage) {
+func (pc *ProductConsumer) handleProductEvent(ctx context.Context, routingKey string, ev message.ProductMessage) {
 	switch routingKey {
 
-	case "product.created", "product.updated":
+	case "product.created", "product.updated", "product.restored":
+		// previous snapshot, to spot price drops
+		old, err := pc.repo.Get(ev.ID)
+		if err != nil {
+			old = nil
+		}
+
 		snap := model.ProductSnapshot{
-			ProductID: ev.ID,
-			Name:      ev.Name,
-			Price:     ev.Price,
-			Stock:     ev.Stock,
+			ProductID:      ev.ID,
+			Name:           ev.Name,
+			Price:          ev.Price,
+			Stock:          ev.Stock,
+			Category:       ev.Category,
+			MaxPerOrder:    ev.MaxPerOrder,
+			MaxPerCustomer: ev.MaxPerCustomer,
 		}
 
 		if err := pc.repo.Upsert(snap); err != nil {
@@ -63,11 +75,51 @@ func (pc *ProductConsumer) handleProductEvent(routingKey string, ev message.Prod
 			log.Println("cart-service: snapshot updated for product:", ev.ID)
 		}
 
+		if old != nil && !old.Deleted && ev.Price < old.Price {
+			pc.notifyPriceDrop(ctx, ev, old.Price)
+		}
+
 	case "product.deleted":
-		if err := pc.repo.Delete(ev.ID); err != nil {
-			log.Println("cart-service: failed to delete snapshot:", err)
+		if err := pc.repo.MarkDeleted(ev.ID); err != nil {
+			log.Println("cart-service: failed to mark snapshot deleted:", err)
 		} else {
-			log.Println("cart-service: snapshot deleted for product:", ev.ID)
+			log.Println("cart-service: snapshot marked deleted for product:", ev.ID)
+		}
+	}
+}
+
+// notifyPriceDrop tells mailer-service about wishlists holding a product that got cheaper
+func (pc *ProductConsumer) notifyPriceDrop(ctx context.Context, ev message.ProductMessage, oldPrice int64) {
+	watchers, err := pc.wishlists.Watchers(ev.ID)
+	if err != nil {
+		log.Println("cart-service: failed to load wishlist watchers:", err)
+		return
+	}
+	if len(watchers) == 0 {
+		return
+	}
+
+	// one entry per email, listing the wishlists
+	msg := message.WishlistPriceDropped{
+		ProductID: ev.ID,
+		Name:      ev.Name,
+		OldPrice:  oldPrice,
+		NewPrice:  ev.Price,
+	}
+	idx := make(map[string]int)
+	for _, w := range watchers {
+		i, ok := idx[w.Email]
+		if !ok {
+			i = len(msg.Watchers)
+			idx[w.Email] = i
+			msg.Watchers = append(msg.Watchers, message.WishlistWatcher{UserID: w.UserID, Email: w.Email})
 		}
+		msg.Watchers[i].Wishlists = append(msg.Watchers[i].Wishlists, w.WishlistName)
+	}
+
+	if err := broker.PublishJSON(ctx, event.ExchangeCart, event.RoutingKeyWishlistPriceDropped, msg); err != nil {
+		log.Println("cart-service: failed to publish wishlist.price_dropped:", err)
+		return
 	}
+	log.Printf("cart-service: price drop of product %d sent to %d wishlist watcher(s)", ev.ID, len(msg.Watchers))
 }
@@ -0,0 +1,105 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/broker"
+	"github.com/phanthehoang2503/small-project/internal/event"
+	"github.com/phanthehoang2503/small-project/internal/message"
+)
+
+// UserConsumer merges a guest cart into the user's cart when they log in,
+// keeps the address cart reminders are sent to and purges the data of
+// deleted users
+type UserConsumer struct {
+	cartRepo    repo.CartRepository
+	guestRepo   repo.GuestCartRepository
+	productRepo *repo.ProductRepo
+	purchases   *repo.PurchaseRepo
+	activity    *repo.ActivityRepo
+	wishlists   *repo.WishlistRepo
+	broker      *broker.Broker
+}
+
+func NewUserConsumer(cr repo.CartRepository, gr repo.GuestCartRepository, pr *repo.ProductRepo, pu *repo.PurchaseRepo, ar *repo.ActivityRepo, wr *repo.WishlistRepo, b *broker.Broker) *UserConsumer {
+	return &UserConsumer{
+		cartRepo:    cr,
+		guestRepo:   gr,
+		productRepo: pr,
+		purchases:   pu,
+		activity:    ar,
+		wishlists:   wr,
+		broker:      b,
+	}
+}
+
+func (c *UserConsumer) Start(queueName string) error {
+	if err := c.broker.Consume(queueName, c.handle); err != nil {
+		return fmt.Errorf("failed to start consumer: %w", err)
+	}
+
+	log.Printf("UserConsumer started, listening on %s", queueName)
+	return nil
+}
+
+func (c *UserConsumer) handle(ctx context.Context, routingKey string, body []byte) error {
+	switch routingKey {
+	case event.RoutingKeyUserLoggedIn:
+		return c.handleLoggedIn(ctx, routingKey, body)
+	case event.RoutingKeyUserDeleted:
+		return c.handleDeleted(body)
+	}
+	return nil
+}
+
+func (c *UserConsumer) handleLoggedIn(ctx context.Context, routingKey string, body []byte) error {
+	var msg message.UserLoggedIn
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	// cart reminders go to the address the user last logged in with
+	if msg.Email != "" {
+		if err := c.activity.SetEmail(msg.UserID, msg.Email); err != nil {
+			return fmt.Errorf("failed to store email of user %d: %w", msg.UserID, err)
+		}
+	}
+
+	if msg.CartToken == "" {
+		return nil
+	}
+
+	res, err := repo.MergeGuestCart(c.cartRepo, c.guestRepo, c.productRepo, c.purchases, msg.CartToken, msg.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to merge guest cart for user %d: %w", msg.UserID, err)
+	}
+
+	log.Printf("Guest cart merged for user %d (merged=%d, adjusted=%d, dropped=%d)", msg.UserID, res.Merged, len(res.Adjusted), len(res.Dropped))
+	return nil
+}
+
+// handleDeleted removes the cart, wishlists and reminder settings of a
+// deleted user. Purchase records stay: they belong to the orders.
+func (c *UserConsumer) handleDeleted(body []byte) error {
+	var msg message.UserDeleted
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	if err := c.cartRepo.ClearCart(msg.UserID); err != nil {
+		return fmt.Errorf("failed to clear cart of user %d: %w", msg.UserID, err)
+	}
+	if err := c.wishlists.DeleteAll(msg.UserID); err != nil {
+		return fmt.Errorf("failed to delete wishlists of user %d: %w", msg.UserID, err)
+	}
+	if err := c.activity.ForgetUser(msg.UserID); err != nil {
+		return fmt.Errorf("failed to forget cart activity of user %d: %w", msg.UserID, err)
+	}
+
+	log.Printf("Cart data of deleted user %d purged", msg.UserID)
+	return nil
+}
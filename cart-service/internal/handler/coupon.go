@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/util"
+)
+
+// CouponReq creates a coupon
+type CouponReq struct {
+	Code         string     `json:"code" binding:"required,max=32" example:"SPRING10"`
+	Type         string     `json:"type" binding:"required,oneof=percent fixed" example:"percent"`
+	Value        int64      `json:"value" binding:"required,min=1" example:"10"`
+	MaxDiscount  int64      `json:"max_discount" binding:"min=0" example:"50000"`
+	MinSpend     int64      `json:"min_spend" binding:"min=0" example:"100000"`
+	UsageLimit   int        `json:"usage_limit" binding:"min=0" example:"100"`
+	PerUserLimit int        `json:"per_user_limit" binding:"min=0" example:"1"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	ProductIDs   []uint     `json:"product_ids"`
+	Categories   []string   `json:"categories"`
+}
+
+// ApplyCouponReq is the code applied to the cart
+type ApplyCouponReq struct {
+	Code string `json:"code" binding:"required" example:"SPRING10"`
+}
+
+// RedeemCouponReq identifies the order using the cart's coupon
+type RedeemCouponReq struct {
+	OrderUUID string `json:"order_uuid" binding:"required,uuid" example:"9b2f7c1e-3f7a-4c52-9d43-0c3a8b5e6f10"`
+}
+
+// CreateCoupon godoc
+// @Summary Create a coupon
+// @Description Percentage (`value` 1-100, optional `max_discount`) or fixed amount coupon, optionally scoped to products or categories.
+// @Tags Coupons
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body CouponReq true "Coupon"
+// @Success 201 {object} model.Coupon
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /coupons [post]
+func CreateCoupon(cr *repo.CouponRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in CouponReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if in.Type == model.CouponPercent && in.Value > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "percent coupons take a value between 1 and 100"})
+			return
+		}
+		if in.StartsAt != nil && in.EndsAt != nil && !in.EndsAt.After(*in.StartsAt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at must be after starts_at"})
+			return
+		}
+
+		if _, err := cr.Get(in.Code); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "coupon code already exists"})
+			return
+		} else if !errors.Is(err, repo.ErrCouponNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		coupon, err := cr.Create(model.Coupon{
+			Code:         in.Code,
+			Type:         in.Type,
+			Value:        in.Value,
+			MaxDiscount:  in.MaxDiscount,
+			MinSpend:     in.MinSpend,
+			UsageLimit:   in.UsageLimit,
+			PerUserLimit: in.PerUserLimit,
+			StartsAt:     in.StartsAt,
+			EndsAt:       in.EndsAt,
+			ProductIDs:   in.ProductIDs,
+			Categories:   in.Categories,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, coupon)
+	}
+}
+
+// ListCoupons godoc
+// @Summary List coupons
+// @Tags Coupons
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} model.Coupon
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /coupons [get]
+func ListCoupons(cr *repo.CouponRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		coupons, err := cr.List()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, coupons)
+	}
+}
+
+// ApplyCoupon godoc
+// @Summary Apply a coupon to the cart
+// @Description Checks the coupon against the current cart and keeps it on the cart. It is checked again at checkout.
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param payload body ApplyCouponReq true "Coupon code"
+// @Success 200 {object} repo.Summary
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/coupon [put]
+// @Security BearerAuth
+func ApplyCoupon(r repo.CartRepository, cr *repo.CouponRepo, s *repo.Summarizer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in ApplyCouponReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := cr.Get(in.Code); err != nil {
+			if errors.Is(err, repo.ErrCouponNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		items, err := r.List(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		previous, err := cr.Applied(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := cr.Apply(userID, in.Code); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		sum, err := s.Summarize(userID, items)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if sum.CouponError != "" {
+			// keep the coupon the cart had before
+			if previous != "" {
+				err = cr.Apply(userID, previous)
+			} else {
+				err = cr.RemoveApplied(userID)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": sum.CouponError})
+			return
+		}
+
+		c.JSON(http.StatusOK, sum)
+	}
+}
+
+// RemoveCoupon godoc
+// @Summary Remove the coupon from the cart
+// @Tags Cart
+// @Success 204
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/coupon [delete]
+// @Security BearerAuth
+func RemoveCoupon(cr *repo.CouponRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		if err := cr.RemoveApplied(userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// RedeemCoupon godoc
+// @Summary Redeem the cart's coupon for an order
+// @Description Called by order-service at checkout, with its service token and the user in X-On-Behalf-Of. Validates the applied coupon again, records its use by the order and returns the priced cart with the discount breakdown. Redeeming the same order again records nothing and returns the discount of the first redemption.
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param X-On-Behalf-Of header int true "User ID"
+// @Param payload body RedeemCouponReq true "Order"
+// @Success 200 {object} repo.Summary
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/coupon/redemptions [post]
+// @Security BearerAuth
+func RedeemCoupon(r repo.CartRepository, s *repo.Summarizer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in RedeemCouponReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		items, err := r.List(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		sum, err := s.Redeem(userID, in.OrderUUID, items)
+		if err != nil {
+			if repo.IsCouponError(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, sum)
+	}
+}
+
+// ReleaseCoupon godoc
+// @Summary Release the coupon used by an order
+// @Description Called by order-service, like the redemption, when the order could not be placed. Gives back the coupon use. Releasing twice is a no-op.
+// @Tags Cart
+// @Param X-On-Behalf-Of header int true "User ID"
+// @Param order_uuid path string true "Order UUID"
+// @Success 204
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/coupon/redemptions/{order_uuid} [delete]
+// @Security BearerAuth
+func ReleaseCoupon(cr *repo.CouponRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		if _, err := cr.Release(c.Param("order_uuid"), userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/util"
+)
+
+const (
+	// CartTokenHeader carries the guest cart token; the cookie is a fallback for browsers
+	CartTokenHeader = "X-Cart-Token"
+	cartTokenCookie = "cart_token"
+	cartTokenMaxAge = 7 * 24 * 60 * 60 // seconds
+)
+
+// cartToken reads the guest cart token from header or cookie
+func cartToken(c *gin.Context) string {
+	if t := c.GetHeader(CartTokenHeader); t != "" {
+		return t
+	}
+	if t, err := c.Cookie(cartTokenCookie); err == nil {
+		return t
+	}
+	return ""
+}
+
+// AddToGuestCart godoc
+// @Summary Add item to guest cart
+// @Description Add a product to an anonymous cart. A new cart token is issued (header X-Cart-Token and cookie) when none is sent.
+// @Tags Guest Cart
+// @Accept json
+// @Produce json
+// @Param X-Cart-Token header string false "Guest cart token"
+// @Param payload body AddToCartReq true "Add to cart payload"
+// @Success 201 {object} handler.CartResponse
+// @Failure 400 {object} map[string]string
+// @Failure 422 {object} handler.QuantityErrorResponse
+// @Failure 500 {object} map[string]string
+// @Router /cart/guest [post]
+func AddToGuestCart(gr repo.GuestCartRepository, pr *repo.ProductRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in AddToCartReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		token := cartToken(c)
+		if token == "" {
+			token = uuid.New().String()
+		}
+
+		p, ok := getProduct(c, pr, in.ProductID)
+		if !ok {
+			return
+		}
+
+		items, err := gr.ListGuest(token)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// guests have no placed orders; per-customer limits apply once logged in
+		if !checkQuantity(c, p, lineQuantity(items, p.ProductID)+in.Quantity, 0) {
+			return
+		}
+
+		item := model.Cart{
+			ProductID: p.ProductID,
+			Quantity:  in.Quantity,
+			Price:     p.Price,
+			Subtotal:  p.Price * int64(in.Quantity),
+		}
+
+		addedItem, err := gr.AddGuestItems(token, &item, repo.MaxQuantity(p, 0))
+		if errors.Is(err, repo.ErrQuantityLimit) {
+			overLimit(c, p, addedItem.Quantity+in.Quantity, 0)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header(CartTokenHeader, token)
+		c.SetCookie(cartTokenCookie, token, cartTokenMaxAge, "/", "", false, true)
+		c.JSON(http.StatusCreated, CartResponse{
+			ID:        addedItem.ID,
+			ProductID: addedItem.ProductID,
+			Quantity:  addedItem.Quantity,
+			Price:     addedItem.Price,
+			Subtotal:  addedItem.Subtotal,
+		})
+	}
+}
+
+// GetGuestCart godoc
+// @Summary List guest cart items
+// @Tags Guest Cart
+// @Produce json
+// @Param X-Cart-Token header string true "Guest cart token"
+// @Success 200 {array} handler.CartResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/guest [get]
+func GetGuestCart(gr repo.GuestCartRepository, pr *repo.ProductRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := cartToken(c)
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing cart token"})
+			return
+		}
+
+		items, err := gr.ListGuest(token)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		ids := make([]uint, 0, len(items))
+		for _, it := range items {
+			ids = append(ids, it.ProductID)
+		}
+		deleted, err := pr.DeletedIDs(ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		resp := make([]CartResponse, 0)
+		for _, it := range items {
+			resp = append(resp, CartResponse{
+				ID:          it.ID,
+				ProductID:   it.ProductID,
+				Quantity:    it.Quantity,
+				Price:       it.Price,
+				Subtotal:    it.Subtotal,
+				Unavailable: deleted[it.ProductID],
+			})
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// UpdateGuestQuantity godoc
+// @Summary Update guest cart item quantity
+// @Tags Guest Cart
+// @Accept json
+// @Produce json
+// @Param X-Cart-Token header string true "Guest cart token"
+// @Param id path int true "Product ID of the cart line"
+// @Param payload body UpdateQuantityReq true "New quantity"
+// @Success 200 {object} handler.CartResponse
+// @Success 204 "Line removed"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 422 {object} handler.QuantityErrorResponse
+// @Failure 500 {object} map[string]string
+// @Router /cart/guest/{id} [put]
+func UpdateGuestQuantity(gr repo.GuestCartRepository, pr *repo.ProductRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		var body UpdateQuantityReq
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !validQuantity(c, *body.Quantity) {
+			return
+		}
+
+		token := cartToken(c)
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing cart token"})
+			return
+		}
+
+		if *body.Quantity > 0 {
+			p, ok := getProduct(c, pr, uint(id64))
+			if !ok {
+				return
+			}
+			if !checkQuantity(c, p, *body.Quantity, 0) {
+				return
+			}
+		}
+
+		updated, err := gr.UpdateGuestQuantity(token, uint(id64), *body.Quantity)
+		if err != nil {
+			if errors.Is(err, repo.ErrItemNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "item not found in cart"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if updated.Quantity == 0 {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		c.JSON(http.StatusOK, CartResponse{
+			ID:        updated.ID,
+			ProductID: updated.ProductID,
+			Quantity:  updated.Quantity,
+			Price:     updated.Price,
+			Subtotal:  updated.Subtotal,
+		})
+	}
+}
+
+// RemoveGuestItem godoc
+// @Summary Remove an item from guest cart
+// @Tags Guest Cart
+// @Param X-Cart-Token header string true "Guest cart token"
+// @Param id path int true "Product ID of the cart line"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /cart/guest/{id} [delete]
+func RemoveGuestItem(gr repo.GuestCartRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		token := cartToken(c)
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing cart token"})
+			return
+		}
+
+		if err := gr.RemoveGuest(token, uint(id64)); err != nil {
+			if errors.Is(err, repo.ErrItemNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// MergeCart godoc
+// @Summary Merge guest cart into user cart
+// @Description Called after login: guest lines are added to the user's cart (quantities combined, capped at stock and purchase limits) and the guest cart is removed.
+// @Tags Cart
+// @Produce json
+// @Param X-Cart-Token header string true "Guest cart token"
+// @Success 200 {object} repo.MergeResult
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/merge [post]
+// @Security BearerAuth
+func MergeCart(r repo.CartRepository, gr repo.GuestCartRepository, pr *repo.ProductRepo, pu *repo.PurchaseRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		token := cartToken(c)
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing cart token"})
+			return
+		}
+
+		res, err := repo.MergeGuestCart(r, gr, pr, pu, token, userID)
+		if err != nil {
+			log.Printf("[cart-handler] merge failed for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// guest cart is gone
+		c.SetCookie(cartTokenCookie, "", -1, "/", "", false, true)
+		c.JSON(http.StatusOK, res)
+	}
+}
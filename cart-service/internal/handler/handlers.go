@@ -16,15 +16,26 @@ import (
 	"github.com/phanthehoang2503/small-project/internal/util"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
-	"gorm.io/gorm"
 )
 
 // struct Product for decode product service response
 type Product struct {
-	ID    uint   `json:"id"`
-	Name  string `json:"name"`
-	Price int64  `json:"price"`
-	Stock int    `json:"stock"`
+	ID             uint   `json:"id"`
+	Name           string `json:"name"`
+	Price          int64  `json:"price"`
+	Stock          int    `json:"stock"`
+	Category       string `json:"category"`
+	MaxPerOrder    int    `json:"max_per_order"`
+	MaxPerCustomer int    `json:"max_per_customer"`
+}
+
+// productClient fetches products missing from the snapshots from product-service
+var productClient = &http.Client{Timeout: 3 * time.Second}
+
+// UseProductClient makes product lookups go through client, e.g. one sending
+// service tokens.
+func UseProductClient(client *http.Client) {
+	productClient = client
 }
 
 // AddToCartReq struct used for request body
@@ -33,18 +44,29 @@ type AddToCartReq struct {
 	Quantity  int  `json:"quantity" example:"3" binding:"required,min=1"`
 }
 
-// UpdateQuantityReq struct for update endpoint
+// UpdateQuantityReq struct for update endpoint; 0 removes the line
 type UpdateQuantityReq struct {
-	Quantity int `json:"quantity" example:"2" binding:"required"`
+	Quantity *int `json:"quantity" example:"2" binding:"required"`
+}
+
+// QuantityErrorResponse is returned when a quantity breaks a purchase rule.
+// Code is one of invalid_quantity, product_unavailable, insufficient_stock,
+// max_per_order_exceeded and max_per_customer_exceeded.
+type QuantityErrorResponse struct {
+	Error       string `json:"error" example:"at most 2 per order"`
+	Code        string `json:"code" example:"max_per_order_exceeded"`
+	ProductID   uint   `json:"product_id,omitempty" example:"10"`
+	MaxQuantity int    `json:"max_quantity" example:"2"` // most units the cart may hold
 }
 
 // CartResponse struct is a public view of cart item
 type CartResponse struct {
-	ID        uint  `json:"id" example:"1"`
-	ProductID uint  `json:"product_id" example:"10"`
-	Quantity  int   `json:"quantity" example:"2"`
-	Price     int64 `json:"price" example:"10000"`
-	Subtotal  int64 `json:"subtotal" example:"20000"`
+	ID          uint  `json:"id" example:"1"`
+	ProductID   uint  `json:"product_id" example:"10"`
+	Quantity    int   `json:"quantity" example:"2"`
+	Price       int64 `json:"price" example:"10000"`
+	Subtotal    int64 `json:"subtotal" example:"20000"`
+	Unavailable bool  `json:"unavailable" example:"false"` // product was deleted, line cannot be checked out
 }
 
 // AddToCart godoc
@@ -57,10 +79,11 @@ type CartResponse struct {
 // @Success 201 {object} handler.CartResponse
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 422 {object} handler.QuantityErrorResponse
 // @Failure 500 {object} map[string]string
 // @Router /cart [post]
 // @Security BearerAuth
-func AddToCart(r repo.CartRepository, pr *repo.ProductRepo) gin.HandlerFunc {
+func AddToCart(r repo.CartRepository, pr *repo.ProductRepo, pu *repo.PurchaseRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var in AddToCartReq
 		if err := c.ShouldBindJSON(&in); err != nil {
@@ -74,66 +97,23 @@ func AddToCart(r repo.CartRepository, pr *repo.ProductRepo) gin.HandlerFunc {
 			return
 		}
 
-		p, err := pr.Get(in.ProductID)
-		if err != nil {
-			base := os.Getenv("PRODUCT_SERVICE_URL")
-			if base == "" {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "product not found (and service url not set)"})
-				return
-			}
-			url := fmt.Sprintf("%s/%d", base, in.ProductID)
-			req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", url, nil)
-			otel.GetTextMapPropagator().Inject(c.Request.Context(), propagation.HeaderCarrier(req.Header))
-			client := &http.Client{Timeout: 3 * time.Second}
-			var resp *http.Response
-			var reqErr error
-
-			for i := 0; i < 3; i++ {
-				resp, reqErr = client.Do(req)
-				if reqErr == nil && resp.StatusCode == http.StatusOK {
-					break
-				}
-				// Log retry
-				if reqErr != nil {
-					log.Printf("[cart-handler] attempt %d failed: %v", i+1, reqErr)
-				} else {
-					log.Printf("[cart-handler] attempt %d returned status: %d", i+1, resp.StatusCode)
-					resp.Body.Close()
-				}
-				time.Sleep(200 * time.Millisecond)
-			}
-
-			if reqErr != nil {
-				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to connect to product service"})
-				return
-			}
-			if resp.StatusCode != http.StatusOK {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "product not found"})
-				return
-			}
-			defer resp.Body.Close()
-
-			var prod Product
-			if err := json.NewDecoder(resp.Body).Decode(&prod); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode product"})
-				return
-			}
-
-			// Upsert into snapshot
-			snapshot := model.ProductSnapshot{
-				ProductID: prod.ID,
-				Name:      prod.Name,
-				Price:     prod.Price,
-				Stock:     prod.Stock,
-			}
-			if err := pr.Upsert(snapshot); err != nil {
-				log.Printf("failed to upsert snapshot: %v", err)
-			}
-			p = &snapshot
+		p, ok := getProduct(c, pr, in.ProductID)
+		if !ok {
+			return
 		}
 
-		if in.Quantity > p.Stock {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient stock"})
+		items, err := r.List(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		purchased, err := pu.Purchased(userID, []uint{p.ProductID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// the quantity is added to the line already in the cart
+		if !checkQuantity(c, p, lineQuantity(items, p.ProductID)+in.Quantity, purchased[p.ProductID]) {
 			return
 		}
 
@@ -145,7 +125,12 @@ func AddToCart(r repo.CartRepository, pr *repo.ProductRepo) gin.HandlerFunc {
 			Subtotal:  p.Price * int64(in.Quantity),
 		}
 
-		addedItem, err := r.AddNewItems(&item)
+		// checked again by the store, against adds made since the check above
+		addedItem, err := r.AddNewItems(&item, repo.MaxQuantity(p, purchased[p.ProductID]))
+		if errors.Is(err, repo.ErrQuantityLimit) {
+			overLimit(c, p, addedItem.Quantity+in.Quantity, purchased[p.ProductID])
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -163,19 +148,22 @@ func AddToCart(r repo.CartRepository, pr *repo.ProductRepo) gin.HandlerFunc {
 
 // UpdateQuantity godoc
 // @Summary Update cart item quantity
+// @Description Set the quantity of a cart line. A quantity of 0 removes the line; negative quantities are rejected.
 // @Tags Cart
 // @Accept json
 // @Produce json
-// @Param id path int true "Cart item ID"
+// @Param id path int true "Product ID of the cart line"
 // @Param payload body UpdateQuantityReq true "New quantity"
 // @Success 200 {object} handler.CartResponse
+// @Success 204 "Line removed"
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 422 {object} handler.QuantityErrorResponse
 // @Failure 500 {object} map[string]string
 // @Router /cart/{id} [put]
 // @Security BearerAuth
-func UpdateQuantity(r repo.CartRepository) gin.HandlerFunc {
+func UpdateQuantity(r repo.CartRepository, pr *repo.ProductRepo, pu *repo.PurchaseRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
 		if err != nil {
@@ -190,21 +178,44 @@ func UpdateQuantity(r repo.CartRepository) gin.HandlerFunc {
 			return
 		}
 
+		if !validQuantity(c, *body.Quantity) {
+			return
+		}
+
 		userID, err := util.GetUserID(c)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			return
 		}
 
-		updated, err := r.UpdateQuantity(userID, id, body.Quantity)
+		if *body.Quantity > 0 {
+			p, ok := getProduct(c, pr, id)
+			if !ok {
+				return
+			}
+			purchased, err := pu.Purchased(userID, []uint{id})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !checkQuantity(c, p, *body.Quantity, purchased[id]) {
+				return
+			}
+		}
+
+		updated, err := r.UpdateQuantity(userID, id, *body.Quantity)
 		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
+			if errors.Is(err, repo.ErrItemNotFound) {
 				c.JSON(http.StatusNotFound, gin.H{"error": "item not found in cart"})
 				return
 			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if updated.Quantity == 0 {
+			c.Status(http.StatusNoContent)
+			return
+		}
 
 		c.JSON(http.StatusOK, CartResponse{
 			ID:        updated.ID,
@@ -225,7 +236,7 @@ func UpdateQuantity(r repo.CartRepository) gin.HandlerFunc {
 // @Failure 500 {object} map[string]string
 // @Router /cart [get]
 // @Security BearerAuth
-func GetCart(r repo.CartRepository) gin.HandlerFunc {
+func GetCart(r repo.CartRepository, pr *repo.ProductRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := util.GetUserID(c)
 		if err != nil {
@@ -239,14 +250,25 @@ func GetCart(r repo.CartRepository) gin.HandlerFunc {
 			return
 		}
 
+		ids := make([]uint, 0, len(items))
+		for _, it := range items {
+			ids = append(ids, it.ProductID)
+		}
+		deleted, err := pr.DeletedIDs(ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
 		resp := make([]CartResponse, 0)
 		for _, it := range items {
 			resp = append(resp, CartResponse{
-				ID:        it.ID,
-				ProductID: it.ProductID,
-				Quantity:  it.Quantity,
-				Price:     it.Price,
-				Subtotal:  it.Subtotal,
+				ID:          it.ID,
+				ProductID:   it.ProductID,
+				Quantity:    it.Quantity,
+				Price:       it.Price,
+				Subtotal:    it.Subtotal,
+				Unavailable: deleted[it.ProductID],
 			})
 		}
 
@@ -257,7 +279,7 @@ func GetCart(r repo.CartRepository) gin.HandlerFunc {
 // RemoveItem godoc
 // @Summary Remove an item from cart
 // @Tags Cart
-// @Param id path int true "Cart item ID"
+// @Param id path int true "Product ID of the cart line"
 // @Success 204
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
@@ -280,7 +302,7 @@ func RemoveItem(r repo.CartRepository) gin.HandlerFunc {
 		}
 
 		if err := r.Remove(userID, id); err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
+			if errors.Is(err, repo.ErrItemNotFound) {
 				c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
 				return
 			}
@@ -316,3 +338,127 @@ func ClearCart(r repo.CartRepository) gin.HandlerFunc {
 		c.Status(http.StatusNoContent)
 	}
 }
+
+// validQuantity rejects negative quantities, writing a 422
+func validQuantity(c *gin.Context, quantity int) bool {
+	if quantity < 0 {
+		c.JSON(http.StatusUnprocessableEntity, QuantityErrorResponse{
+			Error: "quantity cannot be negative",
+			Code:  repo.CodeInvalidQuantity,
+		})
+		return false
+	}
+	return true
+}
+
+// checkQuantity validates the quantity a cart line would reach against stock
+// and the product's purchase limits. On failure it writes a 422 with the rule's code.
+func checkQuantity(c *gin.Context, p *model.ProductSnapshot, quantity, purchased int) bool {
+	err := repo.CheckQuantity(p, quantity, purchased)
+	if err == nil {
+		return true
+	}
+	var qe *repo.QuantityError
+	if errors.As(err, &qe) {
+		c.JSON(http.StatusUnprocessableEntity, QuantityErrorResponse{
+			Error:       qe.Error(),
+			Code:        qe.Code,
+			ProductID:   qe.ProductID,
+			MaxQuantity: qe.MaxQuantity,
+		})
+		return false
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	return false
+}
+
+// overLimit writes the 422 for an add the store refused because the line
+// would reach quantity, more than MaxQuantity.
+func overLimit(c *gin.Context, p *model.ProductSnapshot, quantity, purchased int) {
+	if !checkQuantity(c, p, quantity, purchased) {
+		return
+	}
+	// the store's limit is MaxQuantity(p, purchased), which quantity exceeds
+	// only by breaking one of the rules checkQuantity reports
+	c.JSON(http.StatusUnprocessableEntity, QuantityErrorResponse{
+		Error:       "insufficient stock",
+		Code:        repo.CodeInsufficientStock,
+		ProductID:   p.ProductID,
+		MaxQuantity: repo.MaxQuantity(p, purchased),
+	})
+}
+
+// lineQuantity is the quantity of the product already in the cart
+func lineQuantity(items []model.Cart, productID uint) int {
+	for _, it := range items {
+		if it.ProductID == productID {
+			return it.Quantity
+		}
+	}
+	return 0
+}
+
+// getProduct returns the product snapshot, fetching it from product-service on a miss.
+// On failure it writes the error response and returns false.
+func getProduct(c *gin.Context, pr *repo.ProductRepo, productID uint) (*model.ProductSnapshot, bool) {
+	p, err := pr.Get(productID)
+	if err != nil {
+		base := os.Getenv("PRODUCT_SERVICE_URL")
+		if base == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "product not found (and service url not set)"})
+			return nil, false
+		}
+		url := fmt.Sprintf("%s/%d", base, productID)
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", url, nil)
+		otel.GetTextMapPropagator().Inject(c.Request.Context(), propagation.HeaderCarrier(req.Header))
+		var resp *http.Response
+		var reqErr error
+
+		for i := 0; i < 3; i++ {
+			resp, reqErr = productClient.Do(req)
+			if reqErr == nil && resp.StatusCode == http.StatusOK {
+				break
+			}
+			// Log retry
+			if reqErr != nil {
+				log.Printf("[cart-handler] attempt %d failed: %v", i+1, reqErr)
+			} else {
+				log.Printf("[cart-handler] attempt %d returned status: %d", i+1, resp.StatusCode)
+				resp.Body.Close()
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		if reqErr != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to connect to product service"})
+			return nil, false
+		}
+		if resp.StatusCode != http.StatusOK {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "product not found"})
+			return nil, false
+		}
+		defer resp.Body.Close()
+
+		var prod Product
+		if err := json.NewDecoder(resp.Body).Decode(&prod); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode product"})
+			return nil, false
+		}
+
+		// Upsert into snapshot
+		snapshot := model.ProductSnapshot{
+			ProductID:      prod.ID,
+			Name:           prod.Name,
+			Price:          prod.Price,
+			Stock:          prod.Stock,
+			Category:       prod.Category,
+			MaxPerOrder:    prod.MaxPerOrder,
+			MaxPerCustomer: prod.MaxPerCustomer,
+		}
+		if err := pr.Upsert(snapshot); err != nil {
+			log.Printf("failed to upsert snapshot: %v", err)
+		}
+		p = &snapshot
+	}
+	return p, true
+}
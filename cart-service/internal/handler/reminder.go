@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/util"
+)
+
+// ReminderReq turns abandoned cart reminders on or off
+type ReminderReq struct {
+	Enabled *bool `json:"enabled" binding:"required" example:"false"`
+}
+
+// ReminderResponse is the user's reminder setting
+type ReminderResponse struct {
+	Enabled bool   `json:"enabled" example:"true"`
+	Email   string `json:"email" example:"user@example.com"` // address of the last login
+}
+
+// GetReminders godoc
+// @Summary Get abandoned cart reminder setting
+// @Tags Cart
+// @Produce json
+// @Success 200 {object} handler.ReminderResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/reminders [get]
+// @Security BearerAuth
+func GetReminders(ar *repo.ActivityRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		pref, err := ar.Preference(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ReminderResponse{Enabled: !pref.OptedOut, Email: pref.Email})
+	}
+}
+
+// SetReminders godoc
+// @Summary Opt in or out of abandoned cart reminders
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param payload body ReminderReq true "Reminder setting"
+// @Success 200 {object} handler.ReminderResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/reminders [put]
+// @Security BearerAuth
+func SetReminders(ar *repo.ActivityRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in ReminderReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		pref, err := ar.SetOptOut(userID, !*in.Enabled)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ReminderResponse{Enabled: !pref.OptedOut, Email: pref.Email})
+	}
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/util"
+)
+
+// GetCartSummary godoc
+// @Summary Get the priced cart
+// @Description Revalidates each line against the latest product data (price changes, stock, deleted products) and applies the cart's coupon and returns item count, subtotal, discount (with a per-line breakdown), tax and total. `valid` is false when a line cannot be checked out or the coupon no longer applies (`coupon_error`).
+// @Tags Cart
+// @Produce json
+// @Success 200 {object} repo.Summary
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/summary [get]
+// @Security BearerAuth
+func GetCartSummary(r repo.CartRepository, s *repo.Summarizer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		items, err := r.List(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		sum, err := s.Summarize(userID, items)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, sum)
+	}
+}
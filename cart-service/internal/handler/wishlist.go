@@ -0,0 +1,480 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/util"
+	"gorm.io/gorm"
+)
+
+// WishlistReq is the body to create or update a wishlist
+type WishlistReq struct {
+	Name        string `json:"name" binding:"required,max=100" example:"Birthday"`
+	NotifyEmail string `json:"notify_email" binding:"omitempty,email" example:"user@example.com"`
+}
+
+// WishlistItemReq adds a product to a wishlist
+type WishlistItemReq struct {
+	ProductID uint `json:"product_id" binding:"required" example:"10"`
+}
+
+// MoveToCartReq is the quantity put in the cart, 1 by default
+type MoveToCartReq struct {
+	Quantity int `json:"quantity" binding:"omitempty,min=1" example:"1"`
+}
+
+// MoveToWishlistReq picks the wishlist a cart line goes to
+type MoveToWishlistReq struct {
+	WishlistID uint `json:"wishlist_id" binding:"required" example:"1"`
+}
+
+// uintParam parses a path parameter, writing a 400 on failure
+func uintParam(c *gin.Context, name string) (uint, bool) {
+	v, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+		return 0, false
+	}
+	return uint(v), true
+}
+
+// wishlistError maps repo errors to responses
+func wishlistError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "wishlist not found"})
+	case errors.Is(err, repo.ErrItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not in wishlist"})
+	case errors.Is(err, repo.ErrWishlistExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// ListWishlists godoc
+// @Summary List wishlists
+// @Tags Wishlists
+// @Produce json
+// @Success 200 {array} model.Wishlist
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /wishlists [get]
+// @Security BearerAuth
+func ListWishlists(wr *repo.WishlistRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		lists, err := wr.List(userID)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, lists)
+	}
+}
+
+// CreateWishlist godoc
+// @Summary Create a wishlist
+// @Description Create a named wishlist. When notify_email is set, price drops of its products are emailed there.
+// @Tags Wishlists
+// @Accept json
+// @Produce json
+// @Param payload body WishlistReq true "Wishlist"
+// @Success 201 {object} model.Wishlist
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /wishlists [post]
+// @Security BearerAuth
+func CreateWishlist(wr *repo.WishlistRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in WishlistReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		w, err := wr.Create(userID, in.Name, in.NotifyEmail)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, w)
+	}
+}
+
+// GetWishlist godoc
+// @Summary Get a wishlist
+// @Tags Wishlists
+// @Produce json
+// @Param id path int true "Wishlist ID"
+// @Success 200 {object} model.Wishlist
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /wishlists/{id} [get]
+// @Security BearerAuth
+func GetWishlist(wr *repo.WishlistRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		w, err := wr.Get(userID, id)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, w)
+	}
+}
+
+// UpdateWishlist godoc
+// @Summary Rename a wishlist or change its notification email
+// @Tags Wishlists
+// @Accept json
+// @Produce json
+// @Param id path int true "Wishlist ID"
+// @Param payload body WishlistReq true "Wishlist"
+// @Success 200 {object} model.Wishlist
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /wishlists/{id} [put]
+// @Security BearerAuth
+func UpdateWishlist(wr *repo.WishlistRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in WishlistReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		w, err := wr.Update(userID, id, in.Name, in.NotifyEmail)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, w)
+	}
+}
+
+// DeleteWishlist godoc
+// @Summary Delete a wishlist
+// @Tags Wishlists
+// @Param id path int true "Wishlist ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /wishlists/{id} [delete]
+// @Security BearerAuth
+func DeleteWishlist(wr *repo.WishlistRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		if err := wr.Delete(userID, id); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// AddWishlistItem godoc
+// @Summary Add a product to a wishlist
+// @Tags Wishlists
+// @Accept json
+// @Produce json
+// @Param id path int true "Wishlist ID"
+// @Param payload body WishlistItemReq true "Product"
+// @Success 201 {object} model.WishlistItem
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /wishlists/{id}/items [post]
+// @Security BearerAuth
+func AddWishlistItem(wr *repo.WishlistRepo, pr *repo.ProductRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in WishlistItemReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		p, ok := getProduct(c, pr, in.ProductID)
+		if !ok {
+			return
+		}
+		if p.Deleted {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "product is no longer available"})
+			return
+		}
+
+		item, err := wr.AddItem(userID, id, p.ProductID, p.Price)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, item)
+	}
+}
+
+// RemoveWishlistItem godoc
+// @Summary Remove a product from a wishlist
+// @Tags Wishlists
+// @Param id path int true "Wishlist ID"
+// @Param product_id path int true "Product ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /wishlists/{id}/items/{product_id} [delete]
+// @Security BearerAuth
+func RemoveWishlistItem(wr *repo.WishlistRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		productID, ok := uintParam(c, "product_id")
+		if !ok {
+			return
+		}
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		if err := wr.RemoveItem(userID, id, productID); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// MoveWishlistItemToCart godoc
+// @Summary Move a wishlist product to the cart
+// @Description Adds the product to the cart at the current price and removes it from the wishlist.
+// @Tags Wishlists
+// @Accept json
+// @Produce json
+// @Param id path int true "Wishlist ID"
+// @Param product_id path int true "Product ID"
+// @Param payload body MoveToCartReq false "Quantity (default 1)"
+// @Success 200 {object} handler.CartResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 422 {object} handler.QuantityErrorResponse
+// @Failure 500 {object} map[string]string
+// @Router /wishlists/{id}/items/{product_id}/move-to-cart [post]
+// @Security BearerAuth
+func MoveWishlistItemToCart(wr *repo.WishlistRepo, r repo.CartRepository, pr *repo.ProductRepo, pu *repo.PurchaseRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		productID, ok := uintParam(c, "product_id")
+		if !ok {
+			return
+		}
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in MoveToCartReq
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&in); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if in.Quantity == 0 {
+			in.Quantity = 1
+		}
+
+		w, err := wr.Get(userID, id)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		found := false
+		for _, it := range w.Items {
+			if it.ProductID == productID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			wishlistError(c, repo.ErrItemNotFound)
+			return
+		}
+
+		p, ok := getProduct(c, pr, productID)
+		if !ok {
+			return
+		}
+		items, err := r.List(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		purchased, err := pu.Purchased(userID, []uint{productID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !checkQuantity(c, p, lineQuantity(items, productID)+in.Quantity, purchased[productID]) {
+			return
+		}
+
+		added, err := r.AddNewItems(&model.Cart{
+			UserID:    userID,
+			ProductID: p.ProductID,
+			Quantity:  in.Quantity,
+			Price:     p.Price,
+		}, repo.MaxQuantity(p, purchased[productID]))
+		if errors.Is(err, repo.ErrQuantityLimit) {
+			overLimit(c, p, added.Quantity+in.Quantity, purchased[productID])
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := wr.RemoveItem(userID, id, productID); err != nil {
+			wishlistError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, CartResponse{
+			ID:        added.ID,
+			ProductID: added.ProductID,
+			Quantity:  added.Quantity,
+			Price:     added.Price,
+			Subtotal:  added.Subtotal,
+		})
+	}
+}
+
+// MoveCartItemToWishlist godoc
+// @Summary Move a cart line to a wishlist
+// @Description Saves the product for later and removes the line from the cart.
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID of the cart line"
+// @Param payload body MoveToWishlistReq true "Target wishlist"
+// @Success 200 {object} model.WishlistItem
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/{id}/move-to-wishlist [post]
+// @Security BearerAuth
+func MoveCartItemToWishlist(r repo.CartRepository, wr *repo.WishlistRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in MoveToWishlistReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		items, err := r.List(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var line *model.Cart
+		for i := range items {
+			if items[i].ProductID == productID {
+				line = &items[i]
+				break
+			}
+		}
+		if line == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "item not found in cart"})
+			return
+		}
+
+		saved, err := wr.AddItem(userID, in.WishlistID, line.ProductID, line.Price)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if err := r.Remove(userID, productID); err != nil && !errors.Is(err, repo.ErrItemNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, saved)
+	}
+}
@@ -6,9 +6,10 @@ import (
 
 type Cart struct {
 	gorm.Model `swaggerignore:"true"`
-	UserID     uint  `json:"user_id" gorm:"index"`
-	ProductID  uint  `json:"product_id"`
-	Quantity   int   `json:"quantity"`
-	Price      int64 `json:"price"`
-	Subtotal   int64 `json:"subtotal"`
+	UserID     uint   `json:"user_id" gorm:"index;uniqueIndex:idx_cart_line,where:deleted_at IS NULL"`
+	GuestToken string `json:"-" gorm:"index;not null;default:'';uniqueIndex:idx_cart_line"` // set instead of UserID for guest carts
+	ProductID  uint   `json:"product_id" gorm:"uniqueIndex:idx_cart_line"`                  // one line per product and cart
+	Quantity   int    `json:"quantity"`
+	Price      int64  `json:"price"`
+	Subtotal   int64  `json:"subtotal"`
 }
@@ -0,0 +1,48 @@
+package model
+
+import "time"
+
+// Coupon types
+const (
+	CouponPercent = "percent" // Value is a percentage of the eligible subtotal
+	CouponFixed   = "fixed"   // Value is an amount off the eligible subtotal
+)
+
+// Coupon is a discount code. Scoping by products or categories limits which
+// cart lines it applies to; with neither it applies to the whole cart.
+type Coupon struct {
+	ID           uint       `gorm:"primaryKey" json:"id" example:"1"`
+	Code         string     `gorm:"size:32;uniqueIndex;not null" json:"code" example:"SPRING10"`
+	Type         string     `gorm:"size:16;not null" json:"type" example:"percent"`
+	Value        int64      `json:"value" example:"10"`
+	MaxDiscount  int64      `json:"max_discount" example:"50000"` // cap for percent coupons, 0 = none
+	MinSpend     int64      `json:"min_spend" example:"100000"`   // minimum eligible subtotal
+	UsageLimit   int        `json:"usage_limit" example:"100"`    // total redemptions, 0 = unlimited
+	PerUserLimit int        `json:"per_user_limit" example:"1"`   // redemptions per user, 0 = unlimited
+	UsedCount    int        `json:"used_count" example:"0"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	ProductIDs   []uint     `gorm:"serializer:json" json:"product_ids"`
+	Categories   []string   `gorm:"serializer:json" json:"categories"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// CouponRedemption is a coupon used by an order. It is removed again when
+// the order is cancelled.
+type CouponRedemption struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CouponID  uint      `gorm:"index;not null" json:"coupon_id"`
+	Code      string    `gorm:"size:32" json:"code"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	OrderUUID string    `gorm:"size:36;uniqueIndex" json:"order_uuid"`
+	Discount  int64     `json:"discount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CartCoupon is the coupon a user applied to their cart.
+type CartCoupon struct {
+	UserID    uint   `gorm:"primaryKey"`
+	Code      string `gorm:"size:32"`
+	UpdatedAt time.Time
+}
@@ -3,9 +3,13 @@ package model
 import "time"
 
 type ProductSnapshot struct {
-	ProductID uint      `gorm:"primaryKey;column:product_id" json:"product_id"`
-	Name      string    `json:"name"`
-	Price     int64     `json:"price"`
-	Stock     int       `json:"stock"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ProductID      uint      `gorm:"primaryKey;column:product_id" json:"product_id"`
+	Name           string    `json:"name"`
+	Price          int64     `json:"price"`
+	Stock          int       `json:"stock"`
+	Category       string    `json:"category"`
+	MaxPerOrder    int       `json:"max_per_order"`    // 0 = no limit
+	MaxPerCustomer int       `json:"max_per_customer"` // 0 = no limit
+	Deleted        bool      `json:"deleted"`          // tombstone: product was deleted, cart lines become unavailable
+	UpdatedAt      time.Time `json:"updated_at"`
 }
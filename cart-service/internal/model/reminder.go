@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// CartActivity is the last change to a user's cart, used to find abandoned
+// carts. RemindedAt is set when a reminder went out for the current activity.
+type CartActivity struct {
+	UserID         uint      `gorm:"primaryKey"`
+	LastActivityAt time.Time `gorm:"index;not null"`
+	RemindedAt     *time.Time
+}
+
+// ReminderPreference holds where and whether to send cart reminders to a user.
+// Email comes from the user's last login.
+type ReminderPreference struct {
+	UserID    uint      `gorm:"primaryKey" json:"-"`
+	Email     string    `gorm:"size:255" json:"email" example:"user@example.com"`
+	OptedOut  bool      `gorm:"not null;default:false" json:"opted_out" example:"false"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// OrderCartLine is a cart line cleared when an order was placed. The lines
+// are kept until the order is paid so the cart can be given back if the
+// order fails.
+type OrderCartLine struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	OrderUUID string    `gorm:"size:36;index" json:"order_uuid"`
+	UserID    uint      `json:"user_id"`
+	ProductID uint      `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	Price     int64     `json:"price"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CustomerPurchase is a product a customer holds in a placed order, counted
+// against the product's per-customer limit.
+type CustomerPurchase struct {
+	OrderUUID string `gorm:"primaryKey;size:36"`
+	ProductID uint   `gorm:"primaryKey;index:idx_purchase_user_product,priority:2"`
+	UserID    uint   `gorm:"index:idx_purchase_user_product,priority:1;not null"`
+	Quantity  int
+	CreatedAt time.Time
+}
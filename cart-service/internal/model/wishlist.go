@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// Wishlist is a named list of products a user keeps for later. Unlike the
+// cart it does not expire.
+type Wishlist struct {
+	ID          uint           `gorm:"primaryKey" json:"id" example:"1"`
+	UserID      uint           `gorm:"not null;uniqueIndex:idx_wishlist_user_name" json:"user_id" example:"7"`
+	Name        string         `gorm:"size:100;not null;uniqueIndex:idx_wishlist_user_name" json:"name" example:"Birthday"`
+	NotifyEmail string         `json:"notify_email" example:"user@example.com"` // price drops are emailed here when set
+	Items       []WishlistItem `gorm:"constraint:OnDelete:CASCADE;" json:"items"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+type WishlistItem struct {
+	ID           uint      `gorm:"primaryKey" json:"-"`
+	WishlistID   uint      `gorm:"not null;uniqueIndex:idx_wishlist_product" json:"-"`
+	ProductID    uint      `gorm:"not null;uniqueIndex:idx_wishlist_product;index" json:"product_id" example:"10"`
+	PriceAtAdded int64     `json:"price_at_added" example:"12000"`
+	CreatedAt    time.Time `json:"created_at"`
+}
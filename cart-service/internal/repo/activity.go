@@ -0,0 +1,154 @@
+package repo
+
+import (
+	"log"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ActivityRepo tracks the last activity of user carts and the reminder
+// preferences, whatever the cart backend.
+type ActivityRepo struct {
+	DB *gorm.DB
+}
+
+func NewActivityRepo(db *gorm.DB) *ActivityRepo {
+	return &ActivityRepo{DB: db}
+}
+
+// Touch records activity on the user's cart now.
+func (r *ActivityRepo) Touch(userID uint) error {
+	return r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_activity_at"}),
+	}).Create(&model.CartActivity{UserID: userID, LastActivityAt: time.Now().UTC()}).Error
+}
+
+// Forget drops the activity of an emptied cart.
+func (r *ActivityRepo) Forget(userID uint) error {
+	return r.DB.Delete(&model.CartActivity{}, userID).Error
+}
+
+// ForgetUser drops the activity and reminder preference of a deleted user.
+func (r *ActivityRepo) ForgetUser(userID uint) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&model.CartActivity{}, userID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.ReminderPreference{}, userID).Error
+	})
+}
+
+// AbandonedCart is a cart idle long enough for a reminder.
+type AbandonedCart struct {
+	UserID         uint
+	Email          string
+	LastActivityAt time.Time
+}
+
+// Abandoned returns carts idle since before the cutoff that were not reminded
+// of since their last activity, for users with an email who did not opt out.
+func (r *ActivityRepo) Abandoned(cutoff time.Time, limit int) ([]AbandonedCart, error) {
+	var carts []AbandonedCart
+	err := r.DB.Table("cart_activities a").
+		Select("a.user_id, p.email, a.last_activity_at").
+		Joins("JOIN reminder_preferences p ON p.user_id = a.user_id").
+		Where("a.last_activity_at <= ?", cutoff).
+		Where("a.reminded_at IS NULL OR a.reminded_at < a.last_activity_at").
+		Where("p.email <> '' AND p.opted_out = ?", false).
+		Order("a.last_activity_at").
+		Limit(limit).
+		Scan(&carts).Error
+	return carts, err
+}
+
+// MarkReminded records that the reminder for the cart's current activity was sent.
+func (r *ActivityRepo) MarkReminded(userID uint, at time.Time) error {
+	return r.DB.Model(&model.CartActivity{}).
+		Where("user_id = ?", userID).
+		Update("reminded_at", at).Error
+}
+
+// SetEmail stores the address reminders go to.
+func (r *ActivityRepo) SetEmail(userID uint, email string) error {
+	return r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "updated_at"}),
+	}).Create(&model.ReminderPreference{UserID: userID, Email: email, UpdatedAt: time.Now()}).Error
+}
+
+// SetOptOut turns cart reminders off or back on for the user.
+func (r *ActivityRepo) SetOptOut(userID uint, optedOut bool) (model.ReminderPreference, error) {
+	pref := model.ReminderPreference{UserID: userID, OptedOut: optedOut, UpdatedAt: time.Now()}
+	if err := r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"opted_out", "updated_at"}),
+	}).Create(&pref).Error; err != nil {
+		return model.ReminderPreference{}, err
+	}
+	return r.Preference(userID)
+}
+
+// Preference returns the user's reminder preference; users start opted in.
+func (r *ActivityRepo) Preference(userID uint) (model.ReminderPreference, error) {
+	var pref model.ReminderPreference
+	err := r.DB.Where("user_id = ?", userID).Limit(1).Find(&pref).Error
+	pref.UserID = userID
+	return pref, err
+}
+
+// activityStore records activity on user carts around another store. Guest
+// carts are not tracked: there is nobody to remind.
+type activityStore struct {
+	Store
+	activity *ActivityRepo
+}
+
+// TrackActivity wraps a cart store so that changes to user carts update
+// their last activity.
+func TrackActivity(s Store, ar *ActivityRepo) Store {
+	return &activityStore{Store: s, activity: ar}
+}
+
+func (s *activityStore) touch(userID uint) {
+	if err := s.activity.Touch(userID); err != nil {
+		log.Printf("[cart-activity] failed to record activity for user %d: %v", userID, err)
+	}
+}
+
+func (s *activityStore) AddNewItems(i *model.Cart, max int) (model.Cart, error) {
+	res, err := s.Store.AddNewItems(i, max)
+	if err == nil {
+		s.touch(i.UserID)
+	}
+	return res, err
+}
+
+func (s *activityStore) UpdateQuantity(userID, id uint, quantity int) (model.Cart, error) {
+	res, err := s.Store.UpdateQuantity(userID, id, quantity)
+	if err == nil {
+		s.touch(userID)
+	}
+	return res, err
+}
+
+func (s *activityStore) Remove(userID, id uint) error {
+	err := s.Store.Remove(userID, id)
+	if err == nil {
+		s.touch(userID)
+	}
+	return err
+}
+
+func (s *activityStore) ClearCart(userID uint) error {
+	err := s.Store.ClearCart(userID)
+	if err == nil {
+		if err := s.activity.Forget(userID); err != nil {
+			log.Printf("[cart-activity] failed to forget activity for user %d: %v", userID, err)
+		}
+	}
+	return err
+}
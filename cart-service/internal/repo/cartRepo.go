@@ -2,115 +2,273 @@ package repo
 
 import (
 	"errors"
+	"time"
 
 	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+// CartRepo stores carts in Postgres, one row per line. Lines are identified by
+// product ID, like in Redis; a cart expires ttl after its last change.
 type CartRepo struct {
-	DB *gorm.DB
+	DB  *gorm.DB
+	TTL time.Duration
 }
 
-func NewCartRepo(db *gorm.DB) *CartRepo {
-	return &CartRepo{DB: db}
+func NewCartRepo(db *gorm.DB, ttl time.Duration) *CartRepo {
+	return &CartRepo{DB: db, TTL: ttl}
 }
 
-func (d *CartRepo) AddNewItems(i *model.Cart) (model.Cart, error) { // i: item
-	tx := d.DB.Begin()
-	if tx.Error != nil {
-		return model.Cart{}, tx.Error
-	}
+func (d *CartRepo) AddNewItems(i *model.Cart, max int) (model.Cart, error) { // i: item
+	return d.addItem(Owner{UserID: i.UserID}, i, max)
+}
 
-	// Optional safety for panic
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-			panic(r)
-		}
-	}()
+func (d *CartRepo) List(UserID uint) ([]model.Cart, error) {
+	return d.list(Owner{UserID: UserID})
+}
 
-	var exist model.Cart
-	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-		Where("user_id = ? AND product_id = ?", i.UserID, i.ProductID).
-		First(&exist).Error
+func (d *CartRepo) UpdateQuantity(userID, id uint, quantity int) (model.Cart, error) {
+	return d.updateQuantity(Owner{UserID: userID}, id, quantity)
+}
 
-	// unexpected DB error (not "not found")
-	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		tx.Rollback()
-		return model.Cart{}, err
-	}
+func (d *CartRepo) Remove(UserID, id uint) error {
+	return d.remove(Owner{UserID: UserID}, id)
+}
 
-	//case 1: item does NOT exist → create new row
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		i.Subtotal = i.Price * int64(i.Quantity) // subtotal = price * qty
+func (d *CartRepo) ClearCart(userID uint) error {
+	return d.clear(Owner{UserID: userID})
+}
 
-		if err := tx.Create(i).Error; err != nil {
-			tx.Rollback()
-			return model.Cart{}, err
-		}
+func (d *CartRepo) AddGuestItems(token string, i *model.Cart, max int) (model.Cart, error) {
+	return d.addItem(Owner{GuestToken: token}, i, max)
+}
 
-		if err := tx.Commit().Error; err != nil {
-			return model.Cart{}, err
+func (d *CartRepo) ListGuest(token string) ([]model.Cart, error) {
+	return d.list(Owner{GuestToken: token})
+}
+
+func (d *CartRepo) UpdateGuestQuantity(token string, id uint, quantity int) (model.Cart, error) {
+	return d.updateQuantity(Owner{GuestToken: token}, id, quantity)
+}
+
+func (d *CartRepo) RemoveGuest(token string, id uint) error {
+	return d.remove(Owner{GuestToken: token}, id)
+}
+
+func (d *CartRepo) ClearGuest(token string) error {
+	return d.clear(Owner{GuestToken: token})
+}
+
+// EachCart calls fn with every non-expired cart.
+func (d *CartRepo) EachCart(fn func(owner Owner, items []model.Cart) error) error {
+	var owners []Owner
+	if err := d.live(d.DB.Model(&model.Cart{})).
+		Distinct("user_id", "guest_token").
+		Scan(&owners).Error; err != nil {
+		return err
+	}
+
+	for _, o := range owners {
+		items, err := d.list(o)
+		if err != nil {
+			return err
+		}
+		if err := fn(o, items); err != nil {
+			return err
 		}
-		return *i, nil
 	}
+	return nil
+}
 
-	//case 2: item exists → update qty + subtotal
-	exist.Quantity += i.Quantity
-	exist.Subtotal = exist.Price * int64(exist.Quantity)
+// scope restricts a query to the owner's lines
+func scope(tx *gorm.DB, o Owner) *gorm.DB {
+	if o.GuestToken != "" {
+		return tx.Where("guest_token = ?", o.GuestToken)
+	}
+	return tx.Where("user_id = ? AND guest_token = ''", o.UserID)
+}
 
-	if err := tx.Save(&exist).Error; err != nil {
-		tx.Rollback()
-		return model.Cart{}, err
+// live hides lines of expired carts
+func (d *CartRepo) live(tx *gorm.DB) *gorm.DB {
+	if d.TTL <= 0 {
+		return tx
 	}
+	return tx.Where("updated_at > ?", time.Now().Add(-d.TTL))
+}
 
-	if err := tx.Commit().Error; err != nil {
-		return model.Cart{}, err
+// expire drops the owner's cart if it outlived the TTL, so stale quantities
+// are not added to.
+func (d *CartRepo) expire(tx *gorm.DB, o Owner) error {
+	if d.TTL <= 0 {
+		return nil
 	}
+	return scope(tx, o).Where("updated_at <= ?", time.Now().Add(-d.TTL)).Delete(&model.Cart{}).Error
+}
 
-	return exist, nil
+// touch refreshes the TTL of the whole cart, as EXPIRE does in Redis
+func touch(tx *gorm.DB, o Owner) error {
+	return scope(tx.Model(&model.Cart{}), o).Update("updated_at", time.Now()).Error
 }
 
-func (d *CartRepo) List(UserID uint) ([]model.Cart, error) {
-	var items []model.Cart
-	err := d.DB.Where(("user_id = ?"), UserID).Find(&items).Error
-	return items, err
+// addLine inserts the line or, if the owner already has the product, adds
+// to its quantity in the same statement, so concurrent first adds of a
+// product end up in one line as with the Redis script. The existing line
+// keeps its price, and is left alone if it would hold more than max units.
+func addLine(max int) clause.OnConflict {
+	c := clause.OnConflict{
+		Columns:     []clause.Column{{Name: "user_id"}, {Name: "guest_token"}, {Name: "product_id"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "deleted_at IS NULL"}}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"quantity":   gorm.Expr("carts.quantity + EXCLUDED.quantity"),
+			"subtotal":   gorm.Expr("carts.price * (carts.quantity + EXCLUDED.quantity)"),
+			"updated_at": gorm.Expr("EXCLUDED.updated_at"),
+		}),
+	}
+	if max >= 0 {
+		c.Where = clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "carts.quantity + EXCLUDED.quantity <= ?", Vars: []interface{}{max}},
+		}}
+	}
+	return c
 }
 
-func (d *CartRepo) UpdateQuantity(userID, id uint, quantity int) (model.Cart, error) {
-	var item model.Cart
-	if err := d.DB.Where(("user_id = ? AND id = ?"), userID, id).First(&item).Error; err != nil {
+func (d *CartRepo) addItem(o Owner, i *model.Cart, max int) (model.Cart, error) {
+	line := model.Cart{
+		UserID:     o.UserID,
+		GuestToken: o.GuestToken,
+		ProductID:  i.ProductID,
+		Quantity:   i.Quantity,
+		Price:      i.Price,
+		Subtotal:   i.Price * int64(i.Quantity), // subtotal = price * qty
+	}
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		if err := d.expire(tx, o); err != nil {
+			return err
+		}
+		if max >= 0 && line.Quantity > max {
+			return ErrQuantityLimit
+		}
+		res := tx.Clauses(addLine(max), clause.Returning{}).Create(&line)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			// the existing line would go over max
+			return ErrQuantityLimit
+		}
+		return touch(tx, o)
+	})
+	if errors.Is(err, ErrQuantityLimit) {
+		var current model.Cart
+		err := d.live(scope(d.DB, o)).Where("product_id = ?", i.ProductID).First(&current).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.Cart{}, err
+		}
+		return asLine(model.Cart{ProductID: i.ProductID, Quantity: current.Quantity}), ErrQuantityLimit
+	}
+	if err != nil {
 		return model.Cart{}, err
 	}
+	return asLine(line), nil
+}
 
-	if quantity == 0 {
-		if err := d.DB.Delete(&model.Cart{}, id).Error; err != nil {
-			return model.Cart{}, err
+// MigrateCarts creates or updates the carts table. Duplicate lines of a
+// product, which concurrent adds could create before lines were unique, are
+// merged first so the unique index can be built.
+func MigrateCarts(db *gorm.DB) error {
+	if db.Migrator().HasTable(&model.Cart{}) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(`
+				UPDATE carts SET quantity = dup.total, subtotal = carts.price * dup.total
+				FROM (
+					SELECT min(id) AS keep, sum(quantity) AS total
+					FROM carts WHERE deleted_at IS NULL
+					GROUP BY user_id, guest_token, product_id
+					HAVING count(*) > 1
+				) dup
+				WHERE carts.id = dup.keep`).Error; err != nil {
+				return err
+			}
+			return tx.Exec(`
+				UPDATE carts SET deleted_at = now()
+				WHERE deleted_at IS NULL AND id NOT IN (
+					SELECT min(id) FROM carts WHERE deleted_at IS NULL
+					GROUP BY user_id, guest_token, product_id
+				)`).Error
+		})
+		if err != nil {
+			return err
 		}
-		return model.Cart{}, gorm.ErrRecordNotFound
 	}
+	return db.AutoMigrate(&model.Cart{})
+}
 
-	item.Quantity = quantity
-	item.Subtotal = item.Price * int64(quantity)
+func (d *CartRepo) list(o Owner) ([]model.Cart, error) {
+	var items []model.Cart
+	if err := d.live(scope(d.DB, o)).Order("id").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i] = asLine(items[i])
+	}
+	return items, nil
+}
+
+func (d *CartRepo) updateQuantity(o Owner, productID uint, quantity int) (model.Cart, error) {
+	var item model.Cart
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		err := d.live(scope(tx.Clauses(clause.Locking{Strength: "UPDATE"}), o)).
+			Where("product_id = ?", productID).
+			First(&item).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrItemNotFound
+		}
+		if err != nil {
+			return err
+		}
 
-	if err := d.DB.Save(&item).Error; err != nil {
+		if quantity <= 0 {
+			if err := tx.Delete(&item).Error; err != nil {
+				return err
+			}
+			item = model.Cart{}
+			return touch(tx, o)
+		}
+
+		item.Quantity = quantity
+		item.Subtotal = item.Price * int64(quantity)
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		return touch(tx, o)
+	})
+	if err != nil {
 		return model.Cart{}, err
 	}
-	return item, nil
+	if item.Quantity == 0 {
+		return model.Cart{}, nil
+	}
+	return asLine(item), nil
 }
 
-func (d *CartRepo) Remove(UserID, id uint) error {
-	res := d.DB.Where("user_id = ? AND id = ?", UserID, id).Delete(&model.Cart{})
+func (d *CartRepo) remove(o Owner, productID uint) error {
+	res := d.live(scope(d.DB, o)).Where("product_id = ?", productID).Delete(&model.Cart{})
 	if res.Error != nil {
 		return res.Error
 	}
 	if res.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+		return ErrItemNotFound
 	}
 	return nil
 }
 
-func (d *CartRepo) ClearCart(userID uint) error {
-	return d.DB.Where("user_id = ?", userID).Delete(&model.Cart{}).Error
+func (d *CartRepo) clear(o Owner) error {
+	return scope(d.DB, o).Delete(&model.Cart{}).Error
+}
+
+// asLine exposes the product ID as the line ID, matching the Redis backend
+func asLine(c model.Cart) model.Cart {
+	c.ID = c.ProductID
+	return c
 }
@@ -0,0 +1,190 @@
+package repo
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrCouponNotStarted    = errors.New("coupon is not valid yet")
+	ErrCouponExpired       = errors.New("coupon has expired")
+	ErrCouponUsedUp        = errors.New("coupon usage limit reached")
+	ErrCouponUserLimit     = errors.New("you have already used this coupon")
+	ErrCouponMinSpend      = errors.New("cart does not reach the coupon's minimum spend")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to any item in the cart")
+)
+
+type CouponRepo struct {
+	DB *gorm.DB
+}
+
+func NewCouponRepo(db *gorm.DB) *CouponRepo {
+	return &CouponRepo{DB: db}
+}
+
+// normalizeCode makes coupon codes case-insensitive
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (r *CouponRepo) Create(c model.Coupon) (model.Coupon, error) {
+	c.Code = normalizeCode(c.Code)
+	c.UsedCount = 0
+	if err := r.DB.Create(&c).Error; err != nil {
+		return model.Coupon{}, err
+	}
+	return c, nil
+}
+
+func (r *CouponRepo) List() ([]model.Coupon, error) {
+	var coupons []model.Coupon
+	err := r.DB.Order("id DESC").Find(&coupons).Error
+	return coupons, err
+}
+
+func (r *CouponRepo) Get(code string) (model.Coupon, error) {
+	var c model.Coupon
+	err := r.DB.Where("code = ?", normalizeCode(code)).First(&c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.Coupon{}, ErrCouponNotFound
+	}
+	return c, err
+}
+
+// CheckUsage returns an error when the coupon cannot be used by the user anymore.
+func (r *CouponRepo) CheckUsage(c model.Coupon, userID uint) error {
+	return checkUsage(r.DB, c, userID)
+}
+
+func checkUsage(tx *gorm.DB, c model.Coupon, userID uint) error {
+	if c.UsageLimit > 0 && c.UsedCount >= c.UsageLimit {
+		return ErrCouponUsedUp
+	}
+	if c.PerUserLimit > 0 {
+		var used int64
+		if err := tx.Model(&model.CouponRedemption{}).
+			Where("coupon_id = ? AND user_id = ?", c.ID, userID).
+			Count(&used).Error; err != nil {
+			return err
+		}
+		if int(used) >= c.PerUserLimit {
+			return ErrCouponUserLimit
+		}
+	}
+	return nil
+}
+
+// Apply sets the coupon of the user's cart.
+func (r *CouponRepo) Apply(userID uint, code string) error {
+	return r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"code", "updated_at"}),
+	}).Create(&model.CartCoupon{UserID: userID, Code: normalizeCode(code), UpdatedAt: time.Now()}).Error
+}
+
+// Applied returns the code applied to the user's cart, or "".
+func (r *CouponRepo) Applied(userID uint) (string, error) {
+	var cc model.CartCoupon
+	err := r.DB.First(&cc, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return cc.Code, err
+}
+
+func (r *CouponRepo) RemoveApplied(userID uint) error {
+	return r.DB.Delete(&model.CartCoupon{}, userID).Error
+}
+
+// Redeem records the use of a coupon by an order. The coupon row is locked
+// while discount computes the amount, so usage limits hold under concurrent
+// checkouts. Redeeming the same order twice returns the first redemption.
+func (r *CouponRepo) Redeem(code string, userID uint, orderUUID string, discount func(c model.Coupon) (int64, error)) (model.CouponRedemption, error) {
+	var red model.CouponRedemption
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var c model.Coupon
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code = ?", normalizeCode(code)).
+			First(&c).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCouponNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("order_uuid = ?", orderUUID).First(&red).Error
+		if err == nil {
+			return nil // already redeemed
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err := checkUsage(tx, c, userID); err != nil {
+			return err
+		}
+		amount, err := discount(c)
+		if err != nil {
+			return err
+		}
+
+		red = model.CouponRedemption{
+			CouponID:  c.ID,
+			Code:      c.Code,
+			UserID:    userID,
+			OrderUUID: orderUUID,
+			Discount:  amount,
+		}
+		if err := tx.Create(&red).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.Coupon{}).Where("id = ?", c.ID).
+			Update("used_count", gorm.Expr("used_count + 1")).Error
+	})
+	return red, err
+}
+
+// Redemption returns the coupon use recorded for the user's order, or nil
+// if the order redeemed none.
+func (r *CouponRepo) Redemption(orderUUID string, userID uint) (*model.CouponRedemption, error) {
+	var red model.CouponRedemption
+	err := r.DB.Where("order_uuid = ? AND user_id = ?", orderUUID, userID).First(&red).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &red, nil
+}
+
+// Release gives back the coupon use of a cancelled order and returns the
+// released redemption, if any. userID 0 skips the owner check. Releasing
+// twice is a no-op.
+func (r *CouponRepo) Release(orderUUID string, userID uint) ([]model.CouponRedemption, error) {
+	var released []model.CouponRedemption
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		q := tx.Clauses(clause.Returning{}).Where("order_uuid = ?", orderUUID)
+		if userID != 0 {
+			q = q.Where("user_id = ?", userID)
+		}
+		if err := q.Delete(&released).Error; err != nil {
+			return err
+		}
+		for _, red := range released {
+			if err := tx.Model(&model.Coupon{}).Where("id = ? AND used_count > 0", red.CouponID).
+				Update("used_count", gorm.Expr("used_count - 1")).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return released, err
+}
@@ -1,11 +1,54 @@
 package repo
 
-import "github.com/phanthehoang2503/small-project/cart-service/internal/model"
+import (
+	"errors"
 
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+)
+
+// ErrItemNotFound is returned by both backends when a cart line does not exist.
+var ErrItemNotFound = errors.New("item not found in cart")
+
+// ErrQuantityLimit is returned by both backends when an add would take a line
+// past the most units it may hold. Nothing is added; the returned item has the
+// line's current quantity.
+var ErrQuantityLimit = errors.New("cart line quantity limit reached")
+
+// NoLimit lets an add grow a line without a limit, for lines that were
+// already checked (e.g. restored after a failed order).
+const NoLimit = -1
+
+// CartRepository is implemented by the Redis and Postgres backends. Cart lines
+// are identified by product ID; UpdateQuantity with a quantity <= 0 removes the
+// line and returns an empty item. Adds check the line against max (usually
+// MaxQuantity) atomically, so concurrent adds cannot together go over it.
 type CartRepository interface {
-	AddNewItems(i *model.Cart) (model.Cart, error)
+	AddNewItems(i *model.Cart, max int) (model.Cart, error)
 	List(UserID uint) ([]model.Cart, error)
 	UpdateQuantity(userID, id uint, quantity int) (model.Cart, error)
 	Remove(UserID, id uint) error
 	ClearCart(userID uint) error
 }
+
+// GuestCartRepository stores carts of anonymous shoppers, keyed by cart token.
+type GuestCartRepository interface {
+	AddGuestItems(token string, i *model.Cart, max int) (model.Cart, error)
+	ListGuest(token string) ([]model.Cart, error)
+	UpdateGuestQuantity(token string, id uint, quantity int) (model.Cart, error)
+	RemoveGuest(token string, id uint) error
+	ClearGuest(token string) error
+}
+
+// Owner identifies a cart: a user, or a guest cart token.
+type Owner struct {
+	UserID     uint
+	GuestToken string
+}
+
+// Store is a complete cart backend.
+type Store interface {
+	CartRepository
+	GuestCartRepository
+	// EachCart calls fn with every stored cart, used to migrate between backends.
+	EachCart(fn func(owner Owner, items []model.Cart) error) error
+}
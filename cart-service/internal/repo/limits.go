@@ -0,0 +1,129 @@
+package repo
+
+import (
+	"fmt"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Quantity rule codes, shared with the summary issues. Clients map them to messages.
+const (
+	CodeInvalidQuantity   = "invalid_quantity"
+	CodeUnavailable       = "product_unavailable"
+	CodeInsufficientStock = IssueInsufficientStock
+	CodeMaxPerOrder       = IssueMaxPerOrder
+	CodeMaxPerCustomer    = IssueMaxPerCustomer
+)
+
+// QuantityError is a cart quantity that breaks a purchase rule.
+type QuantityError struct {
+	Code        string
+	ProductID   uint
+	MaxQuantity int // most units of the product the cart may hold
+}
+
+func (e *QuantityError) Error() string {
+	switch e.Code {
+	case CodeUnavailable:
+		return "product is no longer available"
+	case CodeMaxPerOrder:
+		return fmt.Sprintf("at most %d per order", e.MaxQuantity)
+	case CodeMaxPerCustomer:
+		return fmt.Sprintf("purchase limit reached, you can add %d more", e.MaxQuantity)
+	case CodeInsufficientStock:
+		return "insufficient stock"
+	}
+	return "invalid quantity"
+}
+
+// MaxQuantity is the most units of the product a cart may hold, given what
+// the customer already has in placed orders.
+func MaxQuantity(p *model.ProductSnapshot, purchased int) int {
+	max := p.Stock
+	if p.MaxPerOrder > 0 && p.MaxPerOrder < max {
+		max = p.MaxPerOrder
+	}
+	if p.MaxPerCustomer > 0 && p.MaxPerCustomer-purchased < max {
+		max = p.MaxPerCustomer - purchased
+	}
+	if max < 0 {
+		max = 0
+	}
+	return max
+}
+
+// CheckQuantity validates the quantity a cart line would reach.
+func CheckQuantity(p *model.ProductSnapshot, quantity, purchased int) error {
+	qe := &QuantityError{ProductID: p.ProductID, MaxQuantity: MaxQuantity(p, purchased)}
+	switch {
+	case p.Deleted:
+		qe.Code = CodeUnavailable
+	case p.MaxPerOrder > 0 && quantity > p.MaxPerOrder:
+		qe.Code = CodeMaxPerOrder
+	case p.MaxPerCustomer > 0 && purchased+quantity > p.MaxPerCustomer:
+		qe.Code = CodeMaxPerCustomer
+	case quantity > p.Stock:
+		qe.Code = CodeInsufficientStock
+	default:
+		return nil
+	}
+	return qe
+}
+
+// PurchaseRepo keeps the units each customer holds in placed orders, for
+// per-customer limits. Rows are removed when the order fails.
+type PurchaseRepo struct {
+	DB *gorm.DB
+}
+
+func NewPurchaseRepo(db *gorm.DB) *PurchaseRepo {
+	return &PurchaseRepo{DB: db}
+}
+
+// Record stores the lines of a placed order. Recording an order twice is a no-op.
+func (r *PurchaseRepo) Record(orderUUID string, userID uint, items []model.Cart) error {
+	if len(items) == 0 {
+		return nil
+	}
+	rows := make([]model.CustomerPurchase, 0, len(items))
+	for _, it := range items {
+		rows = append(rows, model.CustomerPurchase{
+			OrderUUID: orderUUID,
+			UserID:    userID,
+			ProductID: it.ProductID,
+			Quantity:  it.Quantity,
+		})
+	}
+	return r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// Release drops the lines of an order that failed.
+func (r *PurchaseRepo) Release(orderUUID string) error {
+	return r.DB.Where("order_uuid = ?", orderUUID).Delete(&model.CustomerPurchase{}).Error
+}
+
+// Purchased returns the units of the given products the user holds in placed orders.
+func (r *PurchaseRepo) Purchased(userID uint, ids []uint) (map[uint]int, error) {
+	out := make(map[uint]int, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	var rows []struct {
+		ProductID uint
+		Quantity  int
+	}
+	if err := r.DB.Model(&model.CustomerPurchase{}).
+		Select("product_id, SUM(quantity) AS quantity").
+		Where("user_id = ? AND product_id IN ?", userID, ids).
+		Group("product_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		out[row.ProductID] = row.Quantity
+	}
+	return out, nil
+}
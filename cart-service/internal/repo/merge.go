@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"errors"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+)
+
+// MergeResult reports what happened to the guest cart lines.
+type MergeResult struct {
+	Merged   int    `json:"merged" example:"2"`   // lines added to the user's cart
+	Adjusted []uint `json:"adjusted" example:"3"` // products capped at available stock or purchase limits
+	Dropped  []uint `json:"dropped" example:"7"`  // products no longer available
+}
+
+// MergeGuestCart moves a guest cart into the user's cart, adding quantities of
+// products present in both, capped at the stock known from the snapshot and
+// the product's purchase limits. The guest cart is removed afterwards.
+func MergeGuestCart(cr CartRepository, gr GuestCartRepository, pr *ProductRepo, pu *PurchaseRepo, token string, userID uint) (MergeResult, error) {
+	var res MergeResult
+
+	guest, err := gr.ListGuest(token)
+	if err != nil {
+		return res, err
+	}
+	if len(guest) == 0 {
+		return res, nil
+	}
+
+	current, err := cr.List(userID)
+	if err != nil {
+		return res, err
+	}
+	ids := make([]uint, 0, len(guest))
+	for _, g := range guest {
+		ids = append(ids, g.ProductID)
+	}
+	purchased, err := pu.Purchased(userID, ids)
+	if err != nil {
+		return res, err
+	}
+
+	byProduct := make(map[uint]model.Cart, len(current))
+	for _, it := range current {
+		byProduct[it.ProductID] = it
+	}
+
+	for _, g := range guest {
+		snap, err := pr.Get(g.ProductID)
+		if err != nil || snap.Deleted {
+			res.Dropped = append(res.Dropped, g.ProductID)
+			continue
+		}
+
+		existing, inCart := byProduct[g.ProductID]
+		want := existing.Quantity + g.Quantity
+		max := MaxQuantity(snap, purchased[g.ProductID])
+		if want > max {
+			want = max
+			res.Adjusted = append(res.Adjusted, g.ProductID)
+		}
+		if want <= existing.Quantity {
+			continue
+		}
+
+		if inCart {
+			if _, err := cr.UpdateQuantity(userID, existing.ID, want); err != nil {
+				return res, err
+			}
+		} else {
+			_, err := cr.AddNewItems(&model.Cart{
+				UserID:    userID,
+				ProductID: g.ProductID,
+				Quantity:  want,
+				Price:     snap.Price,
+			}, max)
+			if errors.Is(err, ErrQuantityLimit) {
+				// a concurrent add took the line to its limit first
+				if want == existing.Quantity+g.Quantity {
+					res.Adjusted = append(res.Adjusted, g.ProductID)
+				}
+				continue
+			}
+			if err != nil {
+				return res, err
+			}
+		}
+		res.Merged++
+	}
+
+	return res, gr.ClearGuest(token)
+}
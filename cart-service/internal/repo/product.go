@@ -20,12 +20,35 @@ func (r *ProductRepo) Upsert(s model.ProductSnapshot) error {
 	s.UpdatedAt = time.Now().UTC()
 	return r.db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "product_id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name", "price", "stock", "updated_at"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "price", "stock", "category", "max_per_order", "max_per_customer", "deleted", "updated_at"}),
 	}).Create(&s).Error
 }
 
-func (r *ProductRepo) Delete(id uint) error {
-	return r.db.Delete(&model.ProductSnapshot{}, id).Error
+// MarkDeleted keeps the snapshot as a tombstone so carts still referencing
+// the product can show it as unavailable.
+func (r *ProductRepo) MarkDeleted(id uint) error {
+	return r.db.Model(&model.ProductSnapshot{}).
+		Where("product_id = ?", id).
+		Updates(map[string]interface{}{"deleted": true, "updated_at": time.Now().UTC()}).Error
+}
+
+// DeletedIDs returns which of the given products are tombstoned.
+func (r *ProductRepo) DeletedIDs(ids []uint) (map[uint]bool, error) {
+	deleted := make(map[uint]bool)
+	if len(ids) == 0 {
+		return deleted, nil
+	}
+
+	var found []uint
+	if err := r.db.Model(&model.ProductSnapshot{}).
+		Where("product_id IN ? AND deleted = ?", ids, true).
+		Pluck("product_id", &found).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range found {
+		deleted[id] = true
+	}
+	return deleted, nil
 }
 
 func (r *ProductRepo) Get(id uint) (*model.ProductSnapshot, error) {
@@ -33,3 +56,21 @@ func (r *ProductRepo) Get(id uint) (*model.ProductSnapshot, error) {
 	err := r.db.First(&p, id).Error
 	return &p, err
 }
+
+// GetMany returns the snapshots of the given products, keyed by product ID.
+// Products without a snapshot are missing from the map.
+func (r *ProductRepo) GetMany(ids []uint) (map[uint]model.ProductSnapshot, error) {
+	snaps := make(map[uint]model.ProductSnapshot, len(ids))
+	if len(ids) == 0 {
+		return snaps, nil
+	}
+
+	var found []model.ProductSnapshot
+	if err := r.db.Where("product_id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range found {
+		snaps[s.ProductID] = s
+	}
+	return snaps, nil
+}
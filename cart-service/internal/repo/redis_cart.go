@@ -3,128 +3,312 @@ package repo
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
 	"github.com/redis/go-redis/v9"
 )
 
+// A cart is one hash. Each product has three fields so the quantity can be
+// changed with HINCRBY/HSET without rewriting the item:
+//
+//	i:<product_id>  item JSON (price, created_at) written once
+//	q:<product_id>  quantity
+//	u:<product_id>  last update, unix millis
+const (
+	itemPrefix    = "i:"
+	qtyPrefix     = "q:"
+	updatedPrefix = "u:"
+)
+
+// redisItem is the immutable part of a cart line
+type redisItem struct {
+	ProductID uint      `json:"product_id"`
+	UserID    uint      `json:"user_id"`
+	Price     int64     `json:"price"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KEYS[1] cart key
+// ARGV product id, item json, quantity delta, now (ms), ttl (s, 0 = none),
+// most units the line may hold (-1 = no limit)
+// When the line would go over, nothing is added and the reply is {empty, quantity, limit}.
+var addItemScript = redis.NewScript(`
+local pid = ARGV[1]
+local max = tonumber(ARGV[6])
+if max >= 0 then
+  local cur = tonumber(redis.call('HGET', KEYS[1], 'q:' .. pid) or '0')
+  if cur + tonumber(ARGV[3]) > max then
+    return {'', cur, 'limit'}
+  end
+end
+redis.call('HSETNX', KEYS[1], 'i:' .. pid, ARGV[2])
+local q = redis.call('HINCRBY', KEYS[1], 'q:' .. pid, ARGV[3])
+redis.call('HSET', KEYS[1], 'u:' .. pid, ARGV[4])
+if tonumber(ARGV[5]) > 0 then
+  redis.call('EXPIRE', KEYS[1], ARGV[5])
+end
+return {redis.call('HGET', KEYS[1], 'i:' .. pid), q}
+`)
+
+// KEYS[1] cart key
+// ARGV product id, quantity, now (ms), ttl (s, 0 = none)
+// Returns nil when the item is not in the cart.
+var setQuantityScript = redis.NewScript(`
+local pid = ARGV[1]
+local item = redis.call('HGET', KEYS[1], 'i:' .. pid)
+if not item then
+  return false
+end
+local q = tonumber(ARGV[2])
+if q <= 0 then
+  redis.call('HDEL', KEYS[1], 'i:' .. pid, 'q:' .. pid, 'u:' .. pid)
+  return {item, 0}
+end
+redis.call('HSET', KEYS[1], 'q:' .. pid, q, 'u:' .. pid, ARGV[3])
+if tonumber(ARGV[4]) > 0 then
+  redis.call('EXPIRE', KEYS[1], ARGV[4])
+end
+return {item, q}
+`)
+
 type RedisCartRepo struct {
 	client *redis.Client
+	ttl    time.Duration // how long an untouched cart is kept
 }
 
-func NewRedisCartRepo(addr string) *RedisCartRepo {
+func NewRedisCartRepo(addr string, ttl time.Duration) *RedisCartRepo {
 	return &RedisCartRepo{
 		client: redis.NewClient(&redis.Options{
 			Addr: addr,
 		}),
+		ttl: ttl,
 	}
 }
 
-func (r *RedisCartRepo) AddNewItems(i *model.Cart) (model.Cart, error) {
+func userKey(userID uint) string {
+	return fmt.Sprintf("cart:%d", userID)
+}
+
+func guestKey(token string) string {
+	return "cart:guest:" + token
+}
+
+func (r *RedisCartRepo) AddNewItems(i *model.Cart, max int) (model.Cart, error) {
+	return r.addItem(userKey(i.UserID), i, max)
+}
+
+func (r *RedisCartRepo) List(UserID uint) ([]model.Cart, error) {
+	return r.list(userKey(UserID))
+}
+
+func (r *RedisCartRepo) UpdateQuantity(userID, id uint, quantity int) (model.Cart, error) {
+	return r.updateQuantity(userKey(userID), id, quantity)
+}
+
+func (r *RedisCartRepo) Remove(UserID, id uint) error {
+	return r.remove(userKey(UserID), id)
+}
+
+func (r *RedisCartRepo) ClearCart(userID uint) error {
+	return r.clear(userKey(userID))
+}
+
+// Guest carts live next to user carts, keyed by an opaque cart token.
+
+func (r *RedisCartRepo) AddGuestItems(token string, i *model.Cart, max int) (model.Cart, error) {
+	return r.addItem(guestKey(token), i, max)
+}
+
+func (r *RedisCartRepo) ListGuest(token string) ([]model.Cart, error) {
+	return r.list(guestKey(token))
+}
+
+func (r *RedisCartRepo) UpdateGuestQuantity(token string, id uint, quantity int) (model.Cart, error) {
+	return r.updateQuantity(guestKey(token), id, quantity)
+}
+
+func (r *RedisCartRepo) RemoveGuest(token string, id uint) error {
+	return r.remove(guestKey(token), id)
+}
+
+func (r *RedisCartRepo) ClearGuest(token string) error {
+	return r.clear(guestKey(token))
+}
+
+func (r *RedisCartRepo) addItem(key string, i *model.Cart, max int) (model.Cart, error) {
 	ctx := context.Background()
-	key := fmt.Sprintf("cart:%d", i.UserID)
-	field := strconv.Itoa(int(i.ProductID))
-
-	// Get existing item if any
-	var current model.Cart
-	val, err := r.client.HGet(ctx, key, field).Result()
-	if err == nil {
-		_ = json.Unmarshal([]byte(val), &current)
-		// Update existing
-		current.Quantity += i.Quantity
-		current.Subtotal = current.Price * int64(current.Quantity)
-		current.UpdatedAt = time.Now()
-	} else {
-		// Create new
-		i.ID = i.ProductID // Use ProductID as ID in Redis context
-		i.Subtotal = i.Price * int64(i.Quantity)
-		i.CreatedAt = time.Now()
-		i.UpdatedAt = time.Now()
-		current = *i
-	}
-
-	data, err := json.Marshal(current)
+	now := time.Now()
+
+	data, err := json.Marshal(redisItem{
+		ProductID: i.ProductID,
+		UserID:    i.UserID,
+		Price:     i.Price,
+		CreatedAt: now,
+	})
 	if err != nil {
 		return model.Cart{}, err
 	}
 
-	// Save to hash
-	if err := r.client.HSet(ctx, key, field, data).Err(); err != nil {
+	res, err := addItemScript.Run(ctx, r.client, []string{key},
+		i.ProductID, data, i.Quantity, now.UnixMilli(), r.ttlSeconds(), max).Slice()
+	if err != nil {
 		return model.Cart{}, err
 	}
-
-	// Set TTL for cart (e.g. 24 hours)
-	r.client.Expire(ctx, key, 24*time.Hour)
-
-	return current, nil
+	if len(res) == 3 {
+		qty, _ := res[1].(int64)
+		return asLine(model.Cart{ProductID: i.ProductID, Quantity: int(qty)}), ErrQuantityLimit
+	}
+	return scriptResult(res, now)
 }
 
-func (r *RedisCartRepo) List(UserID uint) ([]model.Cart, error) {
+func (r *RedisCartRepo) list(key string) ([]model.Cart, error) {
 	ctx := context.Background()
-	key := fmt.Sprintf("cart:%d", UserID)
 
 	val, err := r.client.HGetAll(ctx, key).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	items := make([]model.Cart, 0, len(val))
-	for _, v := range val {
-		var item model.Cart
-		if err := json.Unmarshal([]byte(v), &item); err == nil {
-			items = append(items, item)
+	items := make([]model.Cart, 0, len(val)/3)
+	for field, v := range val {
+		pid, ok := strings.CutPrefix(field, itemPrefix)
+		if !ok {
+			continue
 		}
+
+		qty, err := strconv.Atoi(val[qtyPrefix+pid])
+		if err != nil {
+			return nil, fmt.Errorf("cart %s: bad quantity for product %s: %w", key, pid, err)
+		}
+		if qty <= 0 {
+			continue
+		}
+		updated, _ := strconv.ParseInt(val[updatedPrefix+pid], 10, 64)
+
+		item, err := decodeItem(v, qty, time.UnixMilli(updated))
+		if err != nil {
+			return nil, fmt.Errorf("cart %s: %w", key, err)
+		}
+		items = append(items, item)
 	}
 
 	return items, nil
 }
 
-func (r *RedisCartRepo) UpdateQuantity(userID, id uint, quantity int) (model.Cart, error) {
+func (r *RedisCartRepo) updateQuantity(key string, id uint, quantity int) (model.Cart, error) {
 	ctx := context.Background()
-	key := fmt.Sprintf("cart:%d", userID)
-	field := strconv.Itoa(int(id))
+	now := time.Now()
 
-	val, err := r.client.HGet(ctx, key, field).Result()
+	res, err := setQuantityScript.Run(ctx, r.client, []string{key},
+		id, quantity, now.UnixMilli(), r.ttlSeconds()).Slice()
+	if errors.Is(err, redis.Nil) {
+		return model.Cart{}, ErrItemNotFound
+	}
 	if err != nil {
-		return model.Cart{}, fmt.Errorf("item not found")
+		return model.Cart{}, err
 	}
 
-	var item model.Cart
-	if err := json.Unmarshal([]byte(val), &item); err != nil {
+	item, err := scriptResult(res, now)
+	if err != nil {
 		return model.Cart{}, err
 	}
-
-	if quantity <= 0 {
-		r.client.HDel(ctx, key, field)
+	if item.Quantity == 0 {
 		return model.Cart{}, nil
 	}
-
-	item.Quantity = quantity
-	item.Subtotal = item.Price * int64(quantity)
-	item.UpdatedAt = time.Now()
-
-	data, _ := json.Marshal(item)
-	if err := r.client.HSet(ctx, key, field, data).Err(); err != nil {
-		return model.Cart{}, err
-	}
-
-	r.client.Expire(ctx, key, 24*time.Hour)
 	return item, nil
 }
 
-func (r *RedisCartRepo) Remove(UserID, id uint) error {
+func (r *RedisCartRepo) remove(key string, id uint) error {
 	ctx := context.Background()
-	key := fmt.Sprintf("cart:%d", UserID)
 	// 'id' here is interpreted as ProductID
-	field := strconv.Itoa(int(id))
-	return r.client.HDel(ctx, key, field).Err()
+	pid := strconv.Itoa(int(id))
+	n, err := r.client.HDel(ctx, key, itemPrefix+pid, qtyPrefix+pid, updatedPrefix+pid).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrItemNotFound
+	}
+	return nil
 }
 
-func (r *RedisCartRepo) ClearCart(userID uint) error {
+func (r *RedisCartRepo) clear(key string) error {
 	ctx := context.Background()
-	key := fmt.Sprintf("cart:%d", userID)
 	return r.client.Del(ctx, key).Err()
 }
+
+// EachCart calls fn with every stored cart.
+func (r *RedisCartRepo) EachCart(fn func(owner Owner, items []model.Cart) error) error {
+	ctx := context.Background()
+
+	iter := r.client.Scan(ctx, 0, "cart:*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		var owner Owner
+		if token, ok := strings.CutPrefix(key, "cart:guest:"); ok {
+			owner.GuestToken = token
+		} else {
+			id, err := strconv.ParseUint(strings.TrimPrefix(key, "cart:"), 10, 64)
+			if err != nil {
+				continue // not a cart
+			}
+			owner.UserID = uint(id)
+		}
+
+		items, err := r.list(key)
+		if err != nil {
+			return err
+		}
+		if err := fn(owner, items); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (r *RedisCartRepo) ttlSeconds() int {
+	if r.ttl <= 0 {
+		return 0
+	}
+	return max(int(r.ttl.Seconds()), 1)
+}
+
+// scriptResult turns the {item json, quantity} reply of the cart scripts into a cart line
+func scriptResult(res []interface{}, updated time.Time) (model.Cart, error) {
+	if len(res) != 2 {
+		return model.Cart{}, fmt.Errorf("unexpected script reply: %v", res)
+	}
+	raw, ok := res[0].(string)
+	if !ok {
+		return model.Cart{}, fmt.Errorf("unexpected script reply: %v", res)
+	}
+	qty, ok := res[1].(int64)
+	if !ok {
+		return model.Cart{}, fmt.Errorf("unexpected script reply: %v", res)
+	}
+	return decodeItem(raw, int(qty), updated)
+}
+
+func decodeItem(raw string, qty int, updated time.Time) (model.Cart, error) {
+	var it redisItem
+	if err := json.Unmarshal([]byte(raw), &it); err != nil {
+		return model.Cart{}, fmt.Errorf("corrupt cart item: %w", err)
+	}
+
+	var c model.Cart
+	c.ID = it.ProductID // Use ProductID as ID in Redis context
+	c.CreatedAt = it.CreatedAt
+	c.UpdatedAt = updated
+	c.UserID = it.UserID
+	c.ProductID = it.ProductID
+	c.Quantity = qty
+	c.Price = it.Price
+	c.Subtotal = it.Price * int64(qty)
+	return c, nil
+}
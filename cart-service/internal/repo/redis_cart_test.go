@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+)
+
+func newTestRedisCart(t *testing.T, ttl time.Duration) (*RedisCartRepo, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	r := NewRedisCartRepo(mr.Addr(), ttl)
+	t.Cleanup(func() { r.client.Close() })
+	return r, mr
+}
+
+func TestRedisCartParallelAddsLoseNoUpdates(t *testing.T) {
+	r, _ := newTestRedisCart(t, time.Hour)
+
+	const workers, perAdd = 50, 2
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.AddNewItems(&model.Cart{UserID: 7, ProductID: 42, Quantity: perAdd, Price: 1500}, NoLimit); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("AddNewItems: %v", err)
+	}
+
+	items, err := r.List(7)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("got %d lines, want 1: %+v", len(items), items)
+	}
+	if got, want := items[0].Quantity, workers*perAdd; got != want {
+		t.Errorf("quantity = %d, want %d", got, want)
+	}
+	if got, want := items[0].Subtotal, int64(workers*perAdd)*1500; got != want {
+		t.Errorf("subtotal = %d, want %d", got, want)
+	}
+}
+
+func TestRedisCartParallelAddsAndUpdates(t *testing.T) {
+	r, _ := newTestRedisCart(t, time.Hour)
+
+	if _, err := r.AddGuestItems("tok", &model.Cart{ProductID: 1, Quantity: 1, Price: 100}, NoLimit); err != nil {
+		t.Fatalf("AddGuestItems: %v", err)
+	}
+
+	// adds to other products must survive concurrent quantity changes
+	const workers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func(pid uint) {
+			defer wg.Done()
+			if _, err := r.AddGuestItems("tok", &model.Cart{ProductID: pid, Quantity: 1, Price: 100}, NoLimit); err != nil {
+				t.Errorf("AddGuestItems: %v", err)
+			}
+		}(uint(i + 2))
+		go func(q int) {
+			defer wg.Done()
+			if _, err := r.UpdateGuestQuantity("tok", 1, q); err != nil {
+				t.Errorf("UpdateGuestQuantity: %v", err)
+			}
+		}(i + 1)
+	}
+	wg.Wait()
+
+	items, err := r.ListGuest("tok")
+	if err != nil {
+		t.Fatalf("ListGuest: %v", err)
+	}
+	if len(items) != workers+1 {
+		t.Fatalf("got %d lines, want %d", len(items), workers+1)
+	}
+	for _, it := range items {
+		if it.ProductID != 1 && it.Quantity != 1 {
+			t.Errorf("product %d quantity = %d, want 1", it.ProductID, it.Quantity)
+		}
+		if it.Subtotal != it.Price*int64(it.Quantity) {
+			t.Errorf("product %d subtotal = %d, want %d", it.ProductID, it.Subtotal, it.Price*int64(it.Quantity))
+		}
+	}
+}
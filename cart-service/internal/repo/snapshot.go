@@ -0,0 +1,59 @@
+package repo
+
+import (
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrderCartRepo keeps the cart that was cleared for an order.
+type OrderCartRepo struct {
+	DB *gorm.DB
+}
+
+func NewOrderCartRepo(db *gorm.DB) *OrderCartRepo {
+	return &OrderCartRepo{DB: db}
+}
+
+// Save stores the cart lines of an order. Saving the same order twice is a no-op,
+// so redelivered events don't duplicate lines.
+func (r *OrderCartRepo) Save(orderUUID string, userID uint, items []model.Cart) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Model(&model.OrderCartLine{}).Where("order_uuid = ?", orderUUID).Count(&n).Error; err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+
+		lines := make([]model.OrderCartLine, 0, len(items))
+		for _, it := range items {
+			lines = append(lines, model.OrderCartLine{
+				OrderUUID: orderUUID,
+				UserID:    userID,
+				ProductID: it.ProductID,
+				Quantity:  it.Quantity,
+				Price:     it.Price,
+			})
+		}
+		return tx.Create(&lines).Error
+	})
+}
+
+// Take removes and returns the saved lines of an order.
+func (r *OrderCartRepo) Take(orderUUID string) ([]model.OrderCartLine, error) {
+	var lines []model.OrderCartLine
+	err := r.DB.Clauses(clause.Returning{}).
+		Where("order_uuid = ?", orderUUID).
+		Delete(&lines).Error
+	return lines, err
+}
+
+// Discard drops the saved lines once the order went through.
+func (r *OrderCartRepo) Discard(orderUUID string) error {
+	return r.DB.Where("order_uuid = ?", orderUUID).Delete(&model.OrderCartLine{}).Error
+}
@@ -0,0 +1,41 @@
+package repo
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Cart backends selectable with CART_BACKEND
+const (
+	BackendRedis    = "redis"
+	BackendPostgres = "postgres"
+)
+
+// DefaultCartTTL is used when CART_TTL is not set
+const DefaultCartTTL = 24 * time.Hour
+
+// ParseTTL reads a CART_TTL value; empty means DefaultCartTTL and "0" never expires.
+func ParseTTL(v string) (time.Duration, error) {
+	if v == "" {
+		return DefaultCartTTL, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cart ttl %q: %w", v, err)
+	}
+	return d, nil
+}
+
+// OpenStore returns the cart backend by name. ttl <= 0 keeps carts forever.
+func OpenStore(backend string, db *gorm.DB, redisAddr string, ttl time.Duration) (Store, error) {
+	switch backend {
+	case BackendRedis, "":
+		return NewRedisCartRepo(redisAddr, ttl), nil
+	case BackendPostgres:
+		return NewCartRepo(db, ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown cart backend %q", backend)
+	}
+}
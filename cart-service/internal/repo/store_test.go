@@ -0,0 +1,443 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The conformance suite runs against every cart backend: Redis always (in
+// memory, with miniredis), Postgres when CART_TEST_POSTGRES_DSN is set, e.g.
+// "host=localhost user=postgres password=postgres dbname=cart_test sslmode=disable".
+
+const testTTL = time.Hour
+
+type testBackend struct {
+	store Store
+	// age makes the owner's cart older than the TTL
+	age func(t *testing.T, o Owner)
+}
+
+// nextOwner hands out user IDs and guest tokens no other test uses, so runs
+// against a shared database do not see each other's carts.
+var nextOwner = uint64(time.Now().UnixNano() % 1e9 * 1000)
+
+func newOwner(t *testing.T, guest bool) Owner {
+	n := atomic.AddUint64(&nextOwner, 1)
+	if guest {
+		return Owner{GuestToken: fmt.Sprintf("test-%d", n)}
+	}
+	return Owner{UserID: uint(n)}
+}
+
+func testBackends(t *testing.T) map[string]func(t *testing.T) testBackend {
+	backends := map[string]func(t *testing.T) testBackend{
+		BackendRedis: func(t *testing.T) testBackend {
+			r, mr := newTestRedisCart(t, testTTL)
+			return testBackend{
+				store: r,
+				age: func(t *testing.T, o Owner) {
+					key := userKey(o.UserID)
+					if o.GuestToken != "" {
+						key = guestKey(o.GuestToken)
+					}
+					mr.SetTTL(key, time.Millisecond)
+					mr.FastForward(time.Second)
+				},
+			}
+		},
+	}
+
+	dsn := os.Getenv("CART_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Log("CART_TEST_POSTGRES_DSN not set, skipping the postgres backend")
+		return backends
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connect to postgres: %v", err)
+	}
+	if err := MigrateCarts(db); err != nil {
+		t.Fatalf("migrate carts: %v", err)
+	}
+	backends[BackendPostgres] = func(t *testing.T) testBackend {
+		return testBackend{
+			store: NewCartRepo(db, testTTL),
+			age: func(t *testing.T, o Owner) {
+				old := time.Now().Add(-2 * testTTL)
+				if err := scope(db.Model(&model.Cart{}), o).UpdateColumn("updated_at", old).Error; err != nil {
+					t.Fatalf("age cart: %v", err)
+				}
+			},
+		}
+	}
+	return backends
+}
+
+// forEachBackend runs fn against every backend, with an owner of its own
+// that is cleaned up afterwards.
+func forEachBackend(t *testing.T, fn func(t *testing.T, b testBackend)) {
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			fn(t, open(t))
+		})
+	}
+}
+
+// cartOf wraps the user and guest methods behind one owner
+type cartOf struct {
+	s Store
+	o Owner
+}
+
+func (c cartOf) add(productID uint, qty int, price int64) (model.Cart, error) {
+	return c.addUpTo(productID, qty, price, NoLimit)
+}
+
+func (c cartOf) addUpTo(productID uint, qty int, price int64, max int) (model.Cart, error) {
+	item := &model.Cart{UserID: c.o.UserID, ProductID: productID, Quantity: qty, Price: price}
+	if c.o.GuestToken != "" {
+		return c.s.AddGuestItems(c.o.GuestToken, item, max)
+	}
+	return c.s.AddNewItems(item, max)
+}
+
+func (c cartOf) list() ([]model.Cart, error) {
+	if c.o.GuestToken != "" {
+		return c.s.ListGuest(c.o.GuestToken)
+	}
+	return c.s.List(c.o.UserID)
+}
+
+func (c cartOf) update(productID uint, qty int) (model.Cart, error) {
+	if c.o.GuestToken != "" {
+		return c.s.UpdateGuestQuantity(c.o.GuestToken, productID, qty)
+	}
+	return c.s.UpdateQuantity(c.o.UserID, productID, qty)
+}
+
+func (c cartOf) remove(productID uint) error {
+	if c.o.GuestToken != "" {
+		return c.s.RemoveGuest(c.o.GuestToken, productID)
+	}
+	return c.s.Remove(c.o.UserID, productID)
+}
+
+func (c cartOf) clear() error {
+	if c.o.GuestToken != "" {
+		return c.s.ClearGuest(c.o.GuestToken)
+	}
+	return c.s.ClearCart(c.o.UserID)
+}
+
+func newCart(t *testing.T, b testBackend, guest bool) cartOf {
+	c := cartOf{s: b.store, o: newOwner(t, guest)}
+	t.Cleanup(func() { _ = c.clear() })
+	return c
+}
+
+func mustList(t *testing.T, c cartOf) map[uint]model.Cart {
+	t.Helper()
+	items, err := c.list()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	byProduct := make(map[uint]model.Cart, len(items))
+	for _, it := range items {
+		if _, dup := byProduct[it.ProductID]; dup {
+			t.Fatalf("product %d has more than one line: %+v", it.ProductID, items)
+		}
+		byProduct[it.ProductID] = it
+	}
+	return byProduct
+}
+
+func checkLine(t *testing.T, it model.Cart, productID uint, qty int, price int64) {
+	t.Helper()
+	if it.ID != productID || it.ProductID != productID {
+		t.Errorf("line id/product = %d/%d, want %d", it.ID, it.ProductID, productID)
+	}
+	if it.Quantity != qty {
+		t.Errorf("product %d quantity = %d, want %d", productID, it.Quantity, qty)
+	}
+	if it.Price != price || it.Subtotal != price*int64(qty) {
+		t.Errorf("product %d price/subtotal = %d/%d, want %d/%d", productID, it.Price, it.Subtotal, price, price*int64(qty))
+	}
+}
+
+func TestStoreAddMergesLines(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		for _, guest := range []bool{false, true} {
+			c := newCart(t, b, guest)
+
+			it, err := c.add(10, 2, 500)
+			if err != nil {
+				t.Fatalf("add: %v", err)
+			}
+			checkLine(t, it, 10, 2, 500)
+
+			// the line keeps the price it was added at
+			it, err = c.add(10, 3, 700)
+			if err != nil {
+				t.Fatalf("add again: %v", err)
+			}
+			checkLine(t, it, 10, 5, 500)
+
+			if _, err := c.add(11, 1, 900); err != nil {
+				t.Fatalf("add other: %v", err)
+			}
+
+			lines := mustList(t, c)
+			if len(lines) != 2 {
+				t.Fatalf("got %d lines, want 2", len(lines))
+			}
+			checkLine(t, lines[10], 10, 5, 500)
+			checkLine(t, lines[11], 11, 1, 900)
+		}
+	})
+}
+
+func TestStoreUpdateQuantity(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		c := newCart(t, b, false)
+		if _, err := c.add(20, 1, 300); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+
+		it, err := c.update(20, 4)
+		if err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		checkLine(t, it, 20, 4, 300)
+		checkLine(t, mustList(t, c)[20], 20, 4, 300)
+
+		// 0 removes the line and returns an empty item
+		it, err = c.update(20, 0)
+		if err != nil {
+			t.Fatalf("update to 0: %v", err)
+		}
+		if it != (model.Cart{}) {
+			t.Errorf("update to 0 returned %+v, want an empty item", it)
+		}
+		if n := len(mustList(t, c)); n != 0 {
+			t.Errorf("got %d lines after update to 0, want 0", n)
+		}
+
+		if _, err := c.update(21, 2); !errors.Is(err, ErrItemNotFound) {
+			t.Errorf("update unknown line: err = %v, want ErrItemNotFound", err)
+		}
+
+		// a removed line can be added again
+		if _, err := c.add(20, 2, 300); err != nil {
+			t.Fatalf("add after removal: %v", err)
+		}
+		checkLine(t, mustList(t, c)[20], 20, 2, 300)
+	})
+}
+
+func TestStoreRemoveAndClear(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		c := newCart(t, b, true)
+		for _, pid := range []uint{30, 31, 32} {
+			if _, err := c.add(pid, 1, 100); err != nil {
+				t.Fatalf("add: %v", err)
+			}
+		}
+
+		if err := c.remove(31); err != nil {
+			t.Fatalf("remove: %v", err)
+		}
+		if err := c.remove(31); !errors.Is(err, ErrItemNotFound) {
+			t.Errorf("remove twice: err = %v, want ErrItemNotFound", err)
+		}
+		if lines := mustList(t, c); len(lines) != 2 {
+			t.Errorf("got %d lines after remove, want 2", len(lines))
+		}
+
+		if err := c.clear(); err != nil {
+			t.Fatalf("clear: %v", err)
+		}
+		if n := len(mustList(t, c)); n != 0 {
+			t.Errorf("got %d lines after clear, want 0", n)
+		}
+	})
+}
+
+func TestStoreOwnersAreSeparate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		user := newCart(t, b, false)
+		guest := newCart(t, b, true)
+
+		if _, err := user.add(40, 1, 100); err != nil {
+			t.Fatalf("add user: %v", err)
+		}
+		if _, err := guest.add(40, 5, 100); err != nil {
+			t.Fatalf("add guest: %v", err)
+		}
+
+		checkLine(t, mustList(t, user)[40], 40, 1, 100)
+		checkLine(t, mustList(t, guest)[40], 40, 5, 100)
+
+		if err := guest.clear(); err != nil {
+			t.Fatalf("clear guest: %v", err)
+		}
+		checkLine(t, mustList(t, user)[40], 40, 1, 100)
+	})
+}
+
+func TestStoreExpiredCartStartsOver(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		c := newCart(t, b, false)
+		if _, err := c.add(50, 3, 100); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+
+		b.age(t, c.o)
+		if n := len(mustList(t, c)); n != 0 {
+			t.Fatalf("got %d lines in an expired cart, want 0", n)
+		}
+		if _, err := c.update(50, 1); !errors.Is(err, ErrItemNotFound) {
+			t.Errorf("update in an expired cart: err = %v, want ErrItemNotFound", err)
+		}
+
+		// stale quantities are not added to
+		it, err := c.add(50, 1, 100)
+		if err != nil {
+			t.Fatalf("add after expiry: %v", err)
+		}
+		checkLine(t, it, 50, 1, 100)
+	})
+}
+
+func TestStoreConcurrentFirstAdds(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		c := newCart(t, b, false)
+
+		const workers = 20
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if _, err := c.add(60, 1, 250); err != nil {
+					t.Errorf("add: %v", err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		lines := mustList(t, c)
+		if len(lines) != 1 {
+			t.Fatalf("got %d lines, want 1", len(lines))
+		}
+		checkLine(t, lines[60], 60, workers, 250)
+	})
+}
+
+func TestStoreAddLimit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		for _, guest := range []bool{false, true} {
+			c := newCart(t, b, guest)
+
+			// a first add over the limit adds nothing
+			it, err := c.addUpTo(80, 4, 100, 3)
+			if !errors.Is(err, ErrQuantityLimit) {
+				t.Fatalf("add over limit: err = %v, want ErrQuantityLimit", err)
+			}
+			if it.ProductID != 80 || it.Quantity != 0 {
+				t.Errorf("refused add returned %+v, want product 80 with quantity 0", it)
+			}
+			if n := len(mustList(t, c)); n != 0 {
+				t.Fatalf("got %d lines after a refused add, want 0", n)
+			}
+
+			if _, err := c.addUpTo(80, 2, 100, 3); err != nil {
+				t.Fatalf("add: %v", err)
+			}
+			it, err = c.addUpTo(80, 2, 100, 3)
+			if !errors.Is(err, ErrQuantityLimit) {
+				t.Fatalf("add past limit: err = %v, want ErrQuantityLimit", err)
+			}
+			if it.Quantity != 2 {
+				t.Errorf("refused add returned quantity %d, want the line's 2", it.Quantity)
+			}
+			it, err = c.addUpTo(80, 1, 100, 3)
+			if err != nil {
+				t.Fatalf("add up to limit: %v", err)
+			}
+			checkLine(t, it, 80, 3, 100)
+			checkLine(t, mustList(t, c)[80], 80, 3, 100)
+		}
+	})
+}
+
+func TestStoreConcurrentAddsKeepLimit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		c := newCart(t, b, false)
+
+		const workers, limit = 20, 5
+		var wg sync.WaitGroup
+		var refused atomic.Int32
+		start := make(chan struct{})
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				_, err := c.addUpTo(90, 1, 250, limit)
+				if errors.Is(err, ErrQuantityLimit) {
+					refused.Add(1)
+				} else if err != nil {
+					t.Errorf("add: %v", err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		checkLine(t, mustList(t, c)[90], 90, limit, 250)
+		if n := refused.Load(); n != workers-limit {
+			t.Errorf("%d adds refused, want %d", n, workers-limit)
+		}
+	})
+}
+
+func TestStoreEachCart(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		user := newCart(t, b, false)
+		guest := newCart(t, b, true)
+		if _, err := user.add(70, 2, 100); err != nil {
+			t.Fatalf("add user: %v", err)
+		}
+		if _, err := guest.add(71, 1, 100); err != nil {
+			t.Fatalf("add guest: %v", err)
+		}
+
+		seen := map[Owner][]model.Cart{}
+		if err := b.store.EachCart(func(o Owner, items []model.Cart) error {
+			seen[o] = items
+			return nil
+		}); err != nil {
+			t.Fatalf("EachCart: %v", err)
+		}
+
+		if items := seen[user.o]; len(items) != 1 || items[0].ProductID != 70 || items[0].Quantity != 2 {
+			t.Errorf("user cart = %+v, want product 70 x2", items)
+		}
+		if items := seen[guest.o]; len(items) != 1 || items[0].ProductID != 71 {
+			t.Errorf("guest cart = %+v, want product 71", items)
+		}
+	})
+}
@@ -0,0 +1,353 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+)
+
+// Line issues reported by the cart summary
+const (
+	IssuePriceChanged      = "price_changed"             // price differs from when the item was added; the current price is used
+	IssueInsufficientStock = "insufficient_stock"        // quantity is above the stock
+	IssueUnavailable       = "unavailable"               // product was deleted or is unknown
+	IssueMaxPerOrder       = "max_per_order_exceeded"    // quantity is above the product's per-order limit
+	IssueMaxPerCustomer    = "max_per_customer_exceeded" // with the customer's placed orders, quantity is above the per-customer limit
+)
+
+// SummaryLine is a cart line revalidated against the product snapshot.
+type SummaryLine struct {
+	ProductID   uint     `json:"product_id" example:"10"`
+	Name        string   `json:"name" example:"Keyboard"`
+	Category    string   `json:"category,omitempty" example:"peripherals"`
+	Quantity    int      `json:"quantity" example:"2"`
+	Price       int64    `json:"price" example:"12000"`      // current price
+	CartPrice   int64    `json:"cart_price" example:"10000"` // price when added to the cart
+	Subtotal    int64    `json:"subtotal" example:"24000"`
+	Stock       int      `json:"stock" example:"5"`
+	MaxQuantity int      `json:"max_quantity" example:"5"` // most units the cart may hold, from stock and purchase limits
+	Issues      []string `json:"issues,omitempty" example:"price_changed"`
+}
+
+// LineDiscount is the part of a coupon discount allocated to one line.
+type LineDiscount struct {
+	Code      string `json:"code" example:"SPRING10"`
+	ProductID uint   `json:"product_id" example:"10"`
+	Amount    int64  `json:"amount" example:"2400"`
+}
+
+// Summary is the priced cart, used by the client and by order-service at checkout.
+type Summary struct {
+	Lines     []SummaryLine `json:"lines"`
+	ItemCount int           `json:"item_count" example:"2"`
+	Subtotal  int64         `json:"subtotal" example:"24000"`
+	Discount  int64         `json:"discount" example:"0"`
+	Tax       int64         `json:"tax" example:"2400"`
+	Total     int64         `json:"total" example:"26400"`
+	Valid     bool          `json:"valid" example:"true"` // no line is unavailable or short of stock, and the coupon applies
+
+	Coupon      string         `json:"coupon,omitempty" example:"SPRING10"`
+	CouponError string         `json:"coupon_error,omitempty" example:"coupon has expired"`
+	Discounts   []LineDiscount `json:"discounts,omitempty"`
+}
+
+// Summarizer prices carts with the latest product snapshots.
+type Summarizer struct {
+	Products   *ProductRepo
+	Coupons    *CouponRepo
+	Purchases  *PurchaseRepo
+	TaxRateBps int // tax in basis points of the discounted subtotal, 1000 = 10%
+}
+
+func NewSummarizer(pr *ProductRepo, cr *CouponRepo, pu *PurchaseRepo, taxRateBps int) *Summarizer {
+	return &Summarizer{Products: pr, Coupons: cr, Purchases: pu, TaxRateBps: taxRateBps}
+}
+
+// Summarize revalidates each line, applies the user's coupon and computes
+// the totals. A coupon that no longer applies makes the summary invalid.
+func (s *Summarizer) Summarize(userID uint, items []model.Cart) (Summary, error) {
+	sum, err := s.lines(userID, items)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	code, err := s.Coupons.Applied(userID)
+	if err != nil {
+		return Summary{}, err
+	}
+	if code != "" {
+		sum.Coupon = code
+		c, err := s.Coupons.Get(code)
+		if err == nil {
+			err = s.Coupons.CheckUsage(c, userID)
+		}
+		if err == nil {
+			err = sum.applyCoupon(c, time.Now())
+		}
+		if err != nil {
+			if !IsCouponError(err) {
+				return Summary{}, err
+			}
+			sum.CouponError = err.Error()
+			sum.Valid = false
+		}
+	}
+
+	s.total(&sum)
+	return sum, nil
+}
+
+// Redeem prices the cart and records the use of the applied coupon by the
+// order. Without a coupon it only prices the cart. Redeeming the same order
+// again returns the discount recorded the first time.
+func (s *Summarizer) Redeem(userID uint, orderUUID string, items []model.Cart) (Summary, error) {
+	sum, err := s.lines(userID, items)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	// the cart's coupon is removed with the cart, so a repeated call is
+	// recognized by the order, not by the applied coupon
+	prev, err := s.Coupons.Redemption(orderUUID, userID)
+	if err != nil {
+		return Summary{}, err
+	}
+	if prev != nil {
+		if err := s.redeemed(&sum, *prev); err != nil {
+			return Summary{}, err
+		}
+		s.total(&sum)
+		return sum, nil
+	}
+
+	code, err := s.Coupons.Applied(userID)
+	if err != nil {
+		return Summary{}, err
+	}
+	if code != "" {
+		sum.Coupon = code
+		priced := false
+		red, err := s.Coupons.Redeem(code, userID, orderUUID, func(c model.Coupon) (int64, error) {
+			if err := sum.applyCoupon(c, time.Now()); err != nil {
+				return 0, err
+			}
+			priced = true
+			return sum.Discount, nil
+		})
+		if err != nil {
+			return Summary{}, err
+		}
+		// a concurrent call for the same order redeemed it first
+		if !priced {
+			if err := s.redeemed(&sum, red); err != nil {
+				return Summary{}, err
+			}
+		}
+	}
+
+	s.total(&sum)
+	return sum, nil
+}
+
+// redeemed puts the discount of an earlier redemption on the summary,
+// spread over the lines the coupon covers as when it was redeemed.
+func (s *Summarizer) redeemed(sum *Summary, red model.CouponRedemption) error {
+	sum.Coupon = red.Code
+	c, err := s.Coupons.Get(red.Code)
+	if err != nil && !errors.Is(err, ErrCouponNotFound) {
+		return err
+	}
+	eligible, base := sum.eligible(c)
+	amount := red.Discount
+	if amount > sum.Subtotal {
+		amount = sum.Subtotal
+	}
+	if amount > base {
+		// the lines changed since; keep the amount, drop the breakdown
+		eligible, base = nil, 0
+	}
+	sum.spread(c.Code, eligible, base, amount)
+	return nil
+}
+
+// lines revalidates each cart line against the product snapshots and the
+// purchase limits.
+func (s *Summarizer) lines(userID uint, items []model.Cart) (Summary, error) {
+	sum := Summary{Lines: make([]SummaryLine, 0, len(items)), Valid: true}
+
+	ids := make([]uint, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.ProductID)
+	}
+	snaps, err := s.Products.GetMany(ids)
+	if err != nil {
+		return Summary{}, err
+	}
+	purchased, err := s.Purchases.Purchased(userID, ids)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	for _, it := range items {
+		line := SummaryLine{
+			ProductID: it.ProductID,
+			Quantity:  it.Quantity,
+			Price:     it.Price,
+			CartPrice: it.Price,
+		}
+
+		snap, ok := snaps[it.ProductID]
+		switch {
+		case !ok || snap.Deleted:
+			line.Issues = append(line.Issues, IssueUnavailable)
+		default:
+			line.Name = snap.Name
+			line.Category = snap.Category
+			line.Stock = snap.Stock
+			if snap.Price != it.Price {
+				line.Price = snap.Price
+				line.Issues = append(line.Issues, IssuePriceChanged)
+			}
+			line.MaxQuantity = MaxQuantity(&snap, purchased[it.ProductID])
+			if snap.MaxPerOrder > 0 && it.Quantity > snap.MaxPerOrder {
+				line.Issues = append(line.Issues, IssueMaxPerOrder)
+			}
+			if snap.MaxPerCustomer > 0 && purchased[it.ProductID]+it.Quantity > snap.MaxPerCustomer {
+				line.Issues = append(line.Issues, IssueMaxPerCustomer)
+			}
+			if it.Quantity > snap.Stock {
+				line.Issues = append(line.Issues, IssueInsufficientStock)
+			}
+		}
+		line.Subtotal = line.Price * int64(line.Quantity)
+
+		for _, issue := range line.Issues {
+			if issue != IssuePriceChanged {
+				sum.Valid = false
+			}
+		}
+
+		sum.ItemCount += line.Quantity
+		sum.Subtotal += line.Subtotal
+		sum.Lines = append(sum.Lines, line)
+	}
+	return sum, nil
+}
+
+func (s *Summarizer) total(sum *Summary) {
+	taxable := sum.Subtotal - sum.Discount
+	sum.Tax = taxable * int64(s.TaxRateBps) / 10000
+	sum.Total = taxable + sum.Tax
+}
+
+// applyCoupon checks the coupon's validity window, scope and minimum spend,
+// and spreads its discount over the eligible lines.
+func (sum *Summary) applyCoupon(c model.Coupon, now time.Time) error {
+	if c.StartsAt != nil && now.Before(*c.StartsAt) {
+		return ErrCouponNotStarted
+	}
+	if c.EndsAt != nil && !now.Before(*c.EndsAt) {
+		return ErrCouponExpired
+	}
+
+	eligible, base := sum.eligible(c)
+	if len(eligible) == 0 {
+		return ErrCouponNotApplicable
+	}
+	if base < c.MinSpend {
+		return ErrCouponMinSpend
+	}
+
+	var amount int64
+	switch c.Type {
+	case model.CouponPercent:
+		amount = base * c.Value / 100
+		if c.MaxDiscount > 0 && amount > c.MaxDiscount {
+			amount = c.MaxDiscount
+		}
+	default:
+		amount = c.Value
+	}
+	if amount > base {
+		amount = base
+	}
+
+	sum.spread(c.Code, eligible, base, amount)
+	return nil
+}
+
+// eligible returns the indexes of the lines the coupon covers and the sum
+// of their subtotals.
+func (sum *Summary) eligible(c model.Coupon) ([]int, int64) {
+	var idx []int
+	var base int64
+	for i, l := range sum.Lines {
+		if !hasIssue(l, IssueUnavailable) && couponCovers(c, l) {
+			idx = append(idx, i)
+			base += l.Subtotal
+		}
+	}
+	return idx, base
+}
+
+// spread sets the discount, split over the eligible lines in proportion to
+// their subtotals; the remainder goes to the last line.
+func (sum *Summary) spread(code string, eligible []int, base, amount int64) {
+	sum.Discounts = sum.Discounts[:0]
+	var given int64
+	for n, i := range eligible {
+		l := sum.Lines[i]
+		part := amount - given
+		if n < len(eligible)-1 && base > 0 {
+			part = amount * l.Subtotal / base
+		}
+		given += part
+		if part > 0 {
+			sum.Discounts = append(sum.Discounts, LineDiscount{Code: code, ProductID: l.ProductID, Amount: part})
+		}
+	}
+	sum.Discount = amount
+}
+
+// couponCovers reports whether the coupon's product/category scope includes
+// the line. A coupon without scope covers every line.
+func couponCovers(c model.Coupon, l SummaryLine) bool {
+	if len(c.ProductIDs) == 0 && len(c.Categories) == 0 {
+		return true
+	}
+	for _, id := range c.ProductIDs {
+		if id == l.ProductID {
+			return true
+		}
+	}
+	for _, cat := range c.Categories {
+		if cat != "" && cat == l.Category {
+			return true
+		}
+	}
+	return false
+}
+
+func hasIssue(l SummaryLine, issue string) bool {
+	for _, i := range l.Issues {
+		if i == issue {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCouponError reports whether err means the coupon cannot be used, as
+// opposed to a storage failure.
+func IsCouponError(err error) bool {
+	for _, e := range []error{
+		ErrCouponNotFound, ErrCouponNotStarted, ErrCouponExpired, ErrCouponUsedUp,
+		ErrCouponUserLimit, ErrCouponMinSpend, ErrCouponNotApplicable,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
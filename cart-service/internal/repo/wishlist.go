@@ -0,0 +1,158 @@
+package repo
+
+import (
+	"errors"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrWishlistExists = errors.New("a wishlist with this name already exists")
+
+type WishlistRepo struct {
+	DB *gorm.DB
+}
+
+func NewWishlistRepo(db *gorm.DB) *WishlistRepo {
+	return &WishlistRepo{DB: db}
+}
+
+// WishlistWatcher is a wishlist that holds a product and wants price-drop emails.
+type WishlistWatcher struct {
+	UserID       uint
+	Email        string
+	WishlistName string
+}
+
+// nameTaken reports whether the user has another wishlist with this name
+func (r *WishlistRepo) nameTaken(userID, exceptID uint, name string) (bool, error) {
+	var count int64
+	err := r.DB.Model(&model.Wishlist{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, exceptID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *WishlistRepo) Create(userID uint, name, email string) (model.Wishlist, error) {
+	taken, err := r.nameTaken(userID, 0, name)
+	if err != nil {
+		return model.Wishlist{}, err
+	}
+	if taken {
+		return model.Wishlist{}, ErrWishlistExists
+	}
+
+	w := model.Wishlist{UserID: userID, Name: name, NotifyEmail: email, Items: []model.WishlistItem{}}
+	if err := r.DB.Create(&w).Error; err != nil {
+		return model.Wishlist{}, err
+	}
+	return w, nil
+}
+
+func (r *WishlistRepo) List(userID uint) ([]model.Wishlist, error) {
+	var lists []model.Wishlist
+	err := r.DB.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		Where("user_id = ?", userID).
+		Order("id").
+		Find(&lists).Error
+	return lists, err
+}
+
+// Get returns gorm.ErrRecordNotFound unless the wishlist belongs to the user.
+func (r *WishlistRepo) Get(userID, id uint) (model.Wishlist, error) {
+	var w model.Wishlist
+	err := r.DB.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		Where("user_id = ?", userID).
+		First(&w, id).Error
+	return w, err
+}
+
+func (r *WishlistRepo) Update(userID, id uint, name, email string) (model.Wishlist, error) {
+	taken, err := r.nameTaken(userID, id, name)
+	if err != nil {
+		return model.Wishlist{}, err
+	}
+	if taken {
+		return model.Wishlist{}, ErrWishlistExists
+	}
+
+	res := r.DB.Model(&model.Wishlist{}).
+		Where("id = ? AND user_id = ?", id, userID).
+		Updates(map[string]interface{}{"name": name, "notify_email": email})
+	if res.Error != nil {
+		return model.Wishlist{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return model.Wishlist{}, gorm.ErrRecordNotFound
+	}
+	return r.Get(userID, id)
+}
+
+func (r *WishlistRepo) Delete(userID, id uint) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&model.Wishlist{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("wishlist_id = ?", id).Delete(&model.WishlistItem{}).Error
+	})
+}
+
+// DeleteAll removes every wishlist of the user, for deleted accounts.
+func (r *WishlistRepo) DeleteAll(userID uint) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("wishlist_id IN (?)", tx.Model(&model.Wishlist{}).Select("id").Where("user_id = ?", userID)).
+			Delete(&model.WishlistItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.Wishlist{}).Error
+	})
+}
+
+// AddItem puts a product on the wishlist; adding it twice keeps the first entry.
+func (r *WishlistRepo) AddItem(userID, wishlistID, productID uint, price int64) (model.WishlistItem, error) {
+	if _, err := r.Get(userID, wishlistID); err != nil {
+		return model.WishlistItem{}, err
+	}
+
+	item := model.WishlistItem{WishlistID: wishlistID, ProductID: productID, PriceAtAdded: price}
+	if err := r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&item).Error; err != nil {
+		return model.WishlistItem{}, err
+	}
+	if item.ID == 0 {
+		// already there
+		err := r.DB.Where("wishlist_id = ? AND product_id = ?", wishlistID, productID).First(&item).Error
+		return item, err
+	}
+	return item, nil
+}
+
+func (r *WishlistRepo) RemoveItem(userID, wishlistID, productID uint) error {
+	if _, err := r.Get(userID, wishlistID); err != nil {
+		return err
+	}
+
+	res := r.DB.Where("wishlist_id = ? AND product_id = ?", wishlistID, productID).Delete(&model.WishlistItem{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrItemNotFound
+	}
+	return nil
+}
+
+// Watchers returns the wishlists holding the product that have a notify email.
+func (r *WishlistRepo) Watchers(productID uint) ([]WishlistWatcher, error) {
+	var watchers []WishlistWatcher
+	err := r.DB.Table("wishlist_items wi").
+		Select("w.user_id, w.notify_email AS email, w.name AS wishlist_name").
+		Joins("JOIN wishlists w ON w.id = wi.wishlist_id").
+		Where("wi.product_id = ? AND w.notify_email <> ''", productID).
+		Scan(&watchers).Error
+	return watchers, err
+}
@@ -8,15 +8,66 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func RegisterRoutes(r *gin.Engine, cartRepo repo.CartRepository, productRepo *repo.ProductRepo, jwtSecret []byte) {
+func RegisterRoutes(r *gin.Engine, cartRepo repo.CartRepository, guestRepo repo.GuestCartRepository, productRepo *repo.ProductRepo, summarizer *repo.Summarizer, wishlistRepo *repo.WishlistRepo, couponRepo *repo.CouponRepo, purchaseRepo *repo.PurchaseRepo, activityRepo *repo.ActivityRepo, verifier *middleware.Verifier) {
 	r.Use(otelgin.Middleware("cart-service"))
+
+	// Guest carts are identified by the X-Cart-Token header (or cart_token cookie)
+	guest := r.Group("/cart/guest")
+	{
+		guest.POST("", handler.AddToGuestCart(guestRepo, productRepo))
+		guest.GET("", handler.GetGuestCart(guestRepo, productRepo))
+		guest.PUT("/:id", handler.UpdateGuestQuantity(guestRepo, productRepo))
+		guest.DELETE("/:id", handler.RemoveGuestItem(guestRepo))
+	}
+
+	// A user's own cart; service tokens are refused, even acting for a user
 	api := r.Group("/cart")
-	api.Use(middleware.JWTMiddleware(jwtSecret))
+	api.Use(middleware.JWTMiddleware(verifier), middleware.RequireUserToken())
 	{
-		api.POST("", handler.AddToCart(cartRepo, productRepo))
-		api.GET("", handler.GetCart(cartRepo))
-		api.PUT("/:id", handler.UpdateQuantity(cartRepo))
+		api.POST("", handler.AddToCart(cartRepo, productRepo, purchaseRepo))
+		api.GET("", handler.GetCart(cartRepo, productRepo))
+		api.PUT("/coupon", handler.ApplyCoupon(cartRepo, couponRepo, summarizer))
+		api.DELETE("/coupon", handler.RemoveCoupon(couponRepo))
+		api.GET("/reminders", handler.GetReminders(activityRepo))
+		api.PUT("/reminders", handler.SetReminders(activityRepo))
+		api.POST("/merge", handler.MergeCart(cartRepo, guestRepo, productRepo, purchaseRepo))
+		api.PUT("/:id", handler.UpdateQuantity(cartRepo, productRepo, purchaseRepo))
 		api.DELETE("/:id", handler.RemoveItem(cartRepo))
 		api.DELETE("", handler.ClearCart(cartRepo))
+		api.POST("/:id/move-to-wishlist", handler.MoveCartItemToWishlist(cartRepo, wishlistRepo))
+	}
+
+	// The summary is also read by order-service at checkout, for the user it
+	// names
+	r.GET("/cart/summary", middleware.JWTMiddleware(verifier), middleware.RequireUserOrService("order-service"), handler.GetCartSummary(cartRepo, summarizer))
+
+	// Coupon redemptions are recorded by order-service at checkout, for the
+	// user it names
+	redemptions := r.Group("/cart/coupon/redemptions")
+	redemptions.Use(middleware.JWTMiddleware(verifier), middleware.RequireService("order-service"), middleware.RequireUser())
+	{
+		redemptions.POST("", handler.RedeemCoupon(cartRepo, summarizer))
+		redemptions.DELETE("/:order_uuid", handler.ReleaseCoupon(couponRepo))
+	}
+
+	wishlists := r.Group("/wishlists")
+	wishlists.Use(middleware.JWTMiddleware(verifier), middleware.RequireUserToken())
+	{
+		wishlists.GET("", handler.ListWishlists(wishlistRepo))
+		wishlists.POST("", handler.CreateWishlist(wishlistRepo))
+		wishlists.GET("/:id", handler.GetWishlist(wishlistRepo))
+		wishlists.PUT("/:id", handler.UpdateWishlist(wishlistRepo))
+		wishlists.DELETE("/:id", handler.DeleteWishlist(wishlistRepo))
+		wishlists.POST("/:id/items", handler.AddWishlistItem(wishlistRepo, productRepo))
+		wishlists.DELETE("/:id/items/:product_id", handler.RemoveWishlistItem(wishlistRepo))
+		wishlists.POST("/:id/items/:product_id/move-to-cart", handler.MoveWishlistItemToCart(wishlistRepo, cartRepo, productRepo, purchaseRepo))
+	}
+
+	// Coupon administration
+	coupons := r.Group("/coupons")
+	coupons.Use(middleware.JWTMiddleware(verifier), middleware.RequirePermission(middleware.PermCouponsManage))
+	{
+		coupons.POST("", handler.CreateCoupon(couponRepo))
+		coupons.GET("", handler.ListCoupons(couponRepo))
 	}
 }
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+// Service tokens must be refused before reaching a handler, so the test
+// registers the routes without repositories.
+func TestUserRoutesRejectServiceTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := middleware.GenerateSigningKey(middleware.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := middleware.NewSigner([]middleware.SigningKey{key}, "", "auth-service", "small-project")
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+	service := func(client string) string {
+		tok, _, err := signer.IssueService(client, []string{"cart-service"}, 0)
+		if err != nil {
+			t.Fatalf("issue service token: %v", err)
+		}
+		return "Bearer " + tok
+	}
+	verifier := middleware.NewLocalVerifier(signer)
+	verifier.AcceptServiceTokens("cart-service")
+
+	r := gin.New()
+	RegisterRoutes(r, nil, nil, nil, nil, nil, nil, nil, nil, verifier)
+
+	tests := []struct {
+		method, path, client string
+		onBehalfOf           bool
+	}{
+		{http.MethodGet, "/cart", "order-service", true},
+		{http.MethodPost, "/cart", "product-service", true},
+		{http.MethodDelete, "/cart", "order-service", true},
+		{http.MethodPost, "/cart/merge", "order-service", true},
+		{http.MethodPut, "/cart/coupon", "order-service", true},
+		{http.MethodGet, "/wishlists", "order-service", true},
+		{http.MethodPost, "/wishlists/1/items", "product-service", true},
+		{http.MethodGet, "/cart/summary", "product-service", true},
+		{http.MethodGet, "/cart/summary", "order-service", false},
+		{http.MethodPost, "/cart/coupon/redemptions", "product-service", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.client+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", service(tt.client))
+			if tt.onBehalfOf {
+				req.Header.Set(middleware.HeaderOnBehalfOf, "7")
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
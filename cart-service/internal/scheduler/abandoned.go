@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
+	"github.com/phanthehoang2503/small-project/internal/broker"
+	"github.com/phanthehoang2503/small-project/internal/event"
+	"github.com/phanthehoang2503/small-project/internal/message"
+)
+
+const abandonedBatchSize = 100
+
+// AbandonedCarts publishes cart.abandoned for user carts left idle for
+// idleAfter, once per period of inactivity.
+type AbandonedCarts struct {
+	carts     repo.CartRepository
+	products  *repo.ProductRepo
+	activity  *repo.ActivityRepo
+	idleAfter time.Duration
+	interval  time.Duration
+}
+
+func NewAbandonedCarts(cr repo.CartRepository, pr *repo.ProductRepo, ar *repo.ActivityRepo, idleAfter, interval time.Duration) *AbandonedCarts {
+	if idleAfter <= 0 {
+		idleAfter = 4 * time.Hour
+	}
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	return &AbandonedCarts{
+		carts:     cr,
+		products:  pr,
+		activity:  ar,
+		idleAfter: idleAfter,
+		interval:  interval,
+	}
+}
+
+// Start checks for abandoned carts now and then every interval until ctx is cancelled.
+func (s *AbandonedCarts) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		log.Printf("[abandoned-carts] started, idle after=%s interval=%s", s.idleAfter, s.interval)
+		s.run(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("[abandoned-carts] stopped")
+				return
+			case <-ticker.C:
+				s.run(ctx)
+			}
+		}
+	}()
+}
+
+func (s *AbandonedCarts) run(ctx context.Context) {
+	sent := 0
+	for {
+		due, err := s.activity.Abandoned(time.Now().UTC().Add(-s.idleAfter), abandonedBatchSize)
+		if err != nil {
+			log.Printf("[abandoned-carts] failed to list idle carts: %v", err)
+			return
+		}
+		if len(due) == 0 {
+			break
+		}
+		for _, cart := range due {
+			if err := s.remind(ctx, cart); err != nil {
+				log.Printf("[abandoned-carts] failed to remind user %d: %v", cart.UserID, err)
+				return // retried next run
+			}
+			sent++
+		}
+	}
+	if sent > 0 {
+		log.Printf("[abandoned-carts] published %d reminder(s)", sent)
+	}
+}
+
+func (s *AbandonedCarts) remind(ctx context.Context, cart repo.AbandonedCart) error {
+	items, err := s.carts.List(cart.UserID)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]uint, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.ProductID)
+	}
+	snaps, err := s.products.GetMany(ids)
+	if err != nil {
+		return err
+	}
+
+	msg := message.CartAbandoned{
+		UserID:         cart.UserID,
+		Email:          cart.Email,
+		LastActivityAt: cart.LastActivityAt,
+	}
+	for _, it := range items {
+		snap, ok := snaps[it.ProductID]
+		if !ok || snap.Deleted {
+			continue
+		}
+		line := message.CartAbandonedItem{
+			ProductID: it.ProductID,
+			Name:      snap.Name,
+			Quantity:  it.Quantity,
+			Price:     snap.Price,
+			Subtotal:  snap.Price * int64(it.Quantity),
+		}
+		msg.Items = append(msg.Items, line)
+		msg.Total += line.Subtotal
+	}
+
+	// the cart expired or holds nothing that can still be bought
+	if len(msg.Items) == 0 {
+		return s.activity.Forget(cart.UserID)
+	}
+
+	if err := broker.PublishJSON(ctx, event.ExchangeCart, event.RoutingKeyCartAbandoned, msg); err != nil {
+		return err
+	}
+	return s.activity.MarkReminded(cart.UserID, time.Now().UTC())
+}
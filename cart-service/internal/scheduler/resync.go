@@ -0,0 +1,219 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/cart-service/internal/model"
+	"github.com/phanthehoang2503/small-project/cart-service/internal/repo"
+)
+
+const resyncPageSize = 200
+
+// productChange mirrors an item of product-service's GET /products/changes
+type productChange struct {
+	ID             uint      `json:"id"`
+	Name           string    `json:"name"`
+	Price          int64     `json:"price"`
+	Stock          int       `json:"stock"`
+	Category       string    `json:"category"`
+	MaxPerOrder    int       `json:"max_per_order"`
+	MaxPerCustomer int       `json:"max_per_customer"`
+	Deleted        bool      `json:"deleted"`
+	ModifiedAt     time.Time `json:"modified_at"`
+}
+
+type changesPage struct {
+	Items       []productChange `json:"items"`
+	NextSince   time.Time       `json:"next_since"`
+	NextAfterID uint            `json:"next_after_id"`
+}
+
+// Drift counts the snapshots a resync had to fix.
+type Drift struct {
+	Checked  int // products read from product-service
+	Missing  int // no snapshot yet
+	Price    int
+	Stock    int
+	Name     int
+	Category int
+	Limits   int // max per order / per customer
+	Deleted  int // deleted upstream, snapshot was live
+	Revived  int // live upstream, snapshot was a tombstone
+}
+
+func (d Drift) fixed() int {
+	return d.Missing + d.Price + d.Stock + d.Name + d.Category + d.Limits + d.Deleted + d.Revived
+}
+
+// SnapshotResync reconciles product snapshots with product-service, in case
+// product events were missed. The first run reads the whole catalog, later
+// runs only what changed since the last one.
+type SnapshotResync struct {
+	products *repo.ProductRepo
+	baseURL  string // product-service /products URL
+	interval time.Duration
+	client   *http.Client
+
+	since   time.Time
+	afterID uint
+}
+
+func NewSnapshotResync(pr *repo.ProductRepo, baseURL string, interval time.Duration) *SnapshotResync {
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	return &SnapshotResync{
+		products: pr,
+		baseURL:  baseURL,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Start runs the resync now and then every interval until ctx is cancelled.
+func (s *SnapshotResync) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		log.Printf("[snapshot-resync] started, interval=%s", s.interval)
+		s.run(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("[snapshot-resync] stopped")
+				return
+			case <-ticker.C:
+				s.run(ctx)
+			}
+		}
+	}()
+}
+
+func (s *SnapshotResync) run(ctx context.Context) {
+	var drift Drift
+	for {
+		page, err := s.fetch(ctx)
+		if err != nil {
+			log.Printf("[snapshot-resync] failed to fetch changes: %v", err)
+			break
+		}
+		if err := s.reconcile(page.Items, &drift); err != nil {
+			log.Printf("[snapshot-resync] failed to reconcile snapshots: %v", err)
+			break
+		}
+
+		// only move the cursor once the page is applied
+		s.since, s.afterID = page.NextSince, page.NextAfterID
+		if len(page.Items) < resyncPageSize {
+			break
+		}
+	}
+
+	if drift.fixed() > 0 {
+		log.Printf("[snapshot-resync] fixed %d of %d snapshots: missing=%d price=%d stock=%d name=%d category=%d limits=%d deleted=%d revived=%d",
+			drift.fixed(), drift.Checked, drift.Missing, drift.Price, drift.Stock, drift.Name, drift.Category, drift.Limits, drift.Deleted, drift.Revived)
+	} else {
+		log.Printf("[snapshot-resync] %d products checked, no drift", drift.Checked)
+	}
+}
+
+func (s *SnapshotResync) fetch(ctx context.Context) (changesPage, error) {
+	q := url.Values{}
+	if !s.since.IsZero() {
+		q.Set("since", s.since.Format(time.RFC3339Nano))
+	}
+	q.Set("after_id", strconv.FormatUint(uint64(s.afterID), 10))
+	q.Set("limit", strconv.Itoa(resyncPageSize))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/changes?"+q.Encode(), nil)
+	if err != nil {
+		return changesPage{}, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return changesPage{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return changesPage{}, fmt.Errorf("product service returned status %d", resp.StatusCode)
+	}
+
+	var page changesPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return changesPage{}, err
+	}
+	return page, nil
+}
+
+func (s *SnapshotResync) reconcile(items []productChange, drift *Drift) error {
+	ids := make([]uint, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.ID)
+	}
+	snaps, err := s.products.GetMany(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, it := range items {
+		drift.Checked++
+		snap, ok := snaps[it.ID]
+
+		if it.Deleted {
+			if ok && !snap.Deleted {
+				drift.Deleted++
+				if err := s.products.MarkDeleted(it.ID); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		switch {
+		case !ok:
+			drift.Missing++
+		case snap.Deleted:
+			drift.Revived++
+		case snap.Price != it.Price || snap.Stock != it.Stock || snap.Name != it.Name ||
+			snap.Category != it.Category || snap.MaxPerOrder != it.MaxPerOrder || snap.MaxPerCustomer != it.MaxPerCustomer:
+			if snap.Price != it.Price {
+				drift.Price++
+			}
+			if snap.Stock != it.Stock {
+				drift.Stock++
+			}
+			if snap.Name != it.Name {
+				drift.Name++
+			}
+			if snap.Category != it.Category {
+				drift.Category++
+			}
+			if snap.MaxPerOrder != it.MaxPerOrder || snap.MaxPerCustomer != it.MaxPerCustomer {
+				drift.Limits++
+			}
+		default:
+			continue // in sync
+		}
+
+		if err := s.products.Upsert(model.ProductSnapshot{
+			ProductID:      it.ID,
+			Name:           it.Name,
+			Price:          it.Price,
+			Stock:          it.Stock,
+			Category:       it.Category,
+			MaxPerOrder:    it.MaxPerOrder,
+			MaxPerCustomer: it.MaxPerCustomer,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -49,6 +49,7 @@ function Initialize-Environment {
   Write-Log "HEADER" "Environment Setup"
     
   Write-Log "INFO" "Authenticating via Gateway..."
+  # test@example.com creates the products too, so it must be listed in ADMIN_EMAILS
   try { Request "POST" "$GatewayURL/auth/register" @{ email = "test@example.com"; username = "tester"; password = "password" } | Out-Null } catch {}
   $token = (Request "POST" "$GatewayURL/auth/login" @{ login = "test@example.com"; password = "password" }).token
   Write-Log "INFO" "Token acquired."
@@ -63,7 +64,7 @@ function Test-HappyPath($token) {
   Write-Log "HEADER" "Test 1: Successful Order (Happy Path)"
 
   Write-Log "STEP" "Creating Product & Adding to Cart"
-  $prod = (Request "POST" "$GatewayURL/products" @( @{ name = "Valid Product"; price = 1000; stock = 100 } ))[0]
+  $prod = (Request "POST" "$GatewayURL/products" @( @{ name = "Valid Product"; price = 1000; stock = 100 } ) $token)[0]
   Request "POST" "$GatewayURL/cart" @{ product_id = $prod.id; quantity = 2 } $token | Out-Null
     
   Write-Log "STEP" "Checking Out"
@@ -84,11 +85,11 @@ function Test-StockFailure($token) {
   Write-Log "HEADER" "Test 2: Stock Failure (Insufficient Stock)"
 
   Write-Log "STEP" "Setting up Stock Failure Scenario"
-  $prod = (Request "POST" "$GatewayURL/products" @( @{ name = "Fail Product"; price = 1000; stock = 100 } ))[0]
+  $prod = (Request "POST" "$GatewayURL/products" @( @{ name = "Fail Product"; price = 1000; stock = 100 } ) $token)[0]
     
   Request "POST" "$GatewayURL/cart" @{ product_id = $prod.id; quantity = 50 } $token | Out-Null
     
-  Request "PUT" "$GatewayURL/products/$($prod.id)" @{ name = $prod.name; price = $prod.price; stock = 0 } | Out-Null
+  Request "PUT" "$GatewayURL/products/$($prod.id)" @{ name = $prod.name; price = $prod.price; stock = 0 } $token | Out-Null
   Write-Log "INFO" "Stock sabotaged to 0."
 
   Write-Log "STEP" "Checking Out"
@@ -106,7 +107,7 @@ function Test-SagaRollback($token) {
 
   Write-Log "STEP" "Creating Test Products"
   # Product A: Safe (Should be returned)
-  $prodA = (Request "POST" "$GatewayURL/products" @( @{ name = "Safe Product"; price = 100; stock = 50 } ))[0]
+  $prodA = (Request "POST" "$GatewayURL/products" @( @{ name = "Safe Product"; price = 100; stock = 50 } ) $token)[0]
   # Product B: Expensive (Causes Payment Failure if > limit, or we force failures)
   # NOTE: To guarantee payment failure, we'll use the 'Cursed Product' ID if implemented, or just rely on a high price/specific user condition if the logic exists.
   # Based on payment service (which I haven't seen deep logic for), I'll assume standard flow succeeds. 
@@ -115,7 +116,7 @@ function Test-SagaRollback($token) {
   # Actually, the user asked for this scenario. I will assume there IS a way to trigger it.
   # In `demo.ps1` previously, `Test-PaymentFailure` tried "Cursed Product". Let's reuse that concept.
   
-  $prodB = (Request "POST" "$GatewayURL/products" @( @{ name = "Cursed Product"; price = 99999999; stock = 50 } ))[0] 
+  $prodB = (Request "POST" "$GatewayURL/products" @( @{ name = "Cursed Product"; price = 99999999; stock = 50 } ) $token)[0] 
   # Assuming high price triggers failure or similar logic exists/simulated.
 
   try { Invoke-RestMethod -Method DELETE -Uri "$GatewayURL/cart" -Headers @{ "Authorization" = "Bearer $token" } -ErrorAction SilentlyContinue | Out-Null } catch {}
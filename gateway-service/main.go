@@ -17,7 +17,10 @@ func main() {
 		"/cart":     "http://cart-service:8082",
 		"/orders":   "http://order-service:8083",
 		"/auth":     "http://auth-service:8084",
+		"/users":    "http://auth-service:8084",
 		"/payments": "http://payment-service:8086",
+		// token signing keys, for verifiers outside the cluster
+		"/.well-known": "http://auth-service:8084",
 	}
 
 	for prefix, target := range routes {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -12,12 +13,19 @@ import (
 	"go.opentelemetry.io/otel"
 )
 
+// HeaderAuthorization is the message header carrying the publisher's
+// service token, as "Bearer <token>".
+const HeaderAuthorization = "authorization"
+
 type Broker struct {
 	url          string
 	conn         *amqp.Connection
 	mu           sync.Mutex
 	pubChan      *amqp.Channel
 	pubChanMutex sync.Mutex
+
+	token  func(ctx context.Context, exchange string) (string, error)        // see AuthenticateWith
+	verify func(ctx context.Context, routingKey, authorization string) error // see VerifyWith
 }
 
 var Global *Broker
@@ -170,6 +178,20 @@ func (b *Broker) BindQueue(queue, exchange string, routingKeys []string) error {
 	return nil
 }
 
+// AuthenticateWith makes PublishJSON send the token returned for the
+// exchange with each message; an empty token sends none. Call it before
+// publishing.
+func (b *Broker) AuthenticateWith(token func(ctx context.Context, exchange string) (string, error)) {
+	b.token = token
+}
+
+// VerifyWith makes consumers check each message with verify, given its
+// routing key and HeaderAuthorization, before handling it. Messages it
+// rejects are dead-lettered. Call it before Consume.
+func (b *Broker) VerifyWith(verify func(ctx context.Context, routingKey, authorization string) error) {
+	b.verify = verify
+}
+
 // PublishJSON publishes a JSON payload with Context Propagation.
 func (b *Broker) PublishJSON(ctx context.Context, exchange, routingKey string, payload any) error {
 	body, err := json.Marshal(payload)
@@ -181,6 +203,16 @@ func (b *Broker) PublishJSON(ctx context.Context, exchange, routingKey string, p
 	headers := make(amqp.Table)
 	otel.GetTextMapPropagator().Inject(ctx, AMQPCarrier(headers))
 
+	if b.token != nil {
+		token, err := b.token(ctx, exchange)
+		if err != nil {
+			return fmt.Errorf("failed to get a token for %s: %w", routingKey, err)
+		}
+		if token != "" {
+			headers[HeaderAuthorization] = "Bearer " + token
+		}
+	}
+
 	// Retry logic for publishing
 	for i := 0; i < 3; i++ {
 		ch, err := b.getPubChannel()
@@ -237,6 +269,7 @@ func (b *Broker) Consume(queue string, handler func(ctx context.Context, routing
 		return err
 	}
 
+	verify := b.verify
 	go func() {
 		log.Printf("RabbitMQ: consuming from queue %q", queue)
 		for msg := range msgs {
@@ -246,6 +279,14 @@ func (b *Broker) Consume(queue string, handler func(ctx context.Context, routing
 			}
 			ctx := otel.GetTextMapPropagator().Extract(context.Background(), AMQPCarrier(msg.Headers))
 
+			if verify != nil {
+				if err := verify(ctx, msg.RoutingKey, AMQPCarrier(msg.Headers).Get(HeaderAuthorization)); err != nil {
+					log.Printf("RabbitMQ: rejected %s message on queue %q: %v", msg.RoutingKey, queue, err)
+					_ = msg.Nack(false, false)
+					continue
+				}
+			}
+
 			if err := handler(ctx, msg.RoutingKey, msg.Body); err != nil {
 				// FAILURE STRATEGY: Do NOT requeue. Send to DLX (if configured).
 				// If no DLX, message is dropped.
@@ -1,10 +1,14 @@
 package event
 
+import "strings"
+
 // Exchanges
 const (
 	ExchangeLogs    = "logs_exchange"
 	ExchangeProduct = "product_exchange"
 	ExchangeOrder   = "order_exchange"
+	ExchangeUser    = "user_exchange"
+	ExchangeCart    = "cart_exchange"
 )
 
 // Routing keys
@@ -15,9 +19,10 @@ const (
 	RoutingKeyLogWarn  = "log.warn"
 
 	// product domain
-	RoutingKeyProductCreated = "product.created"
-	RoutingKeyProductUpdated = "product.updated"
-	RoutingKeyProductDeleted = "product.deleted"
+	RoutingKeyProductCreated  = "product.created"
+	RoutingKeyProductUpdated  = "product.updated"
+	RoutingKeyProductDeleted  = "product.deleted"
+	RoutingKeyProductRestored = "product.restored"
 
 	// order domain
 	// order domain
@@ -31,4 +36,48 @@ const (
 	// inventory domain
 	RoutingKeyInventoryReserved          = "inventory.reserved"
 	RoutingKeyInventoryReservationFailed = "inventory.reservation.failed"
+	RoutingKeyInventoryLow               = "inventory.low"           // published on product exchange
+	RoutingKeyInventoryRestocked         = "inventory.restocked"     // published on product exchange
+	RoutingKeyInventoryBackInStock       = "inventory.back_in_stock" // published on product exchange
+
+	// cart domain
+	RoutingKeyWishlistPriceDropped = "wishlist.price_dropped"
+	RoutingKeyCartAbandoned        = "cart.abandoned"
+
+	// user domain
+	RoutingKeyUserLoggedIn               = "user.logged_in"
+	RoutingKeyUserRegistered             = "user.registered"
+	RoutingKeyUserVerificationRequested  = "user.verification_requested"
+	RoutingKeyUserPasswordResetRequested = "user.password_reset_requested"
+	RoutingKeyUserLockedOut              = "user.locked_out"
+	RoutingKeyUserEmailChangeRequested   = "user.email_change_requested"
+	RoutingKeyUserDeleted                = "user.deleted"
 )
+
+// AudienceEvents is the audience of the service tokens publishers send with
+// their messages, see broker.HeaderAuthorization. It is not a service, so
+// these tokens cannot call any API.
+const AudienceEvents = "events"
+
+// publishers are the services allowed to publish each domain's events, by
+// routing key prefix.
+var publishers = map[string]string{
+	"product.":   "product-service",
+	"inventory.": "product-service",
+	"order.":     "order-service",
+	"payment.":   "payment-service",
+	"wishlist.":  "cart-service",
+	"cart.":      "cart-service",
+	"user.":      "auth-service",
+}
+
+// PublisherOf returns the service allowed to publish routingKey, or "" for
+// keys no service publishes.
+func PublisherOf(routingKey string) string {
+	for prefix, service := range publishers {
+		if strings.HasPrefix(routingKey, prefix) {
+			return service
+		}
+	}
+	return ""
+}
@@ -44,6 +44,12 @@ func ConnectRabbit() *broker.Broker {
 	if err := b.DeclareTopicExchange(event.ExchangeOrder); err != nil {
 		log.Fatalf("failed to declare order exchange: %v", err)
 	}
+	if err := b.DeclareTopicExchange(event.ExchangeUser); err != nil {
+		log.Fatalf("failed to declare user exchange: %v", err)
+	}
+	if err := b.DeclareTopicExchange(event.ExchangeCart); err != nil {
+		log.Fatalf("failed to declare cart exchange: %v", err)
+	}
 
 	log.Println("RabbitMQ ready in service")
 	return b
@@ -0,0 +1,22 @@
+package helper
+
+import (
+	"log"
+	"os"
+
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+	"github.com/redis/go-redis/v9"
+)
+
+// UseTokenDenylist makes JWTMiddleware reject revoked access tokens, using
+// the Redis at REDIS_URL. Without REDIS_URL revocation is not checked.
+func UseTokenDenylist() *middleware.RedisDenylist {
+	addr := os.Getenv("REDIS_URL")
+	if addr == "" {
+		log.Println("REDIS_URL not set, revoked tokens are not checked")
+		return nil
+	}
+	d := middleware.NewRedisDenylist(redis.NewClient(&redis.Options{Addr: addr}))
+	middleware.UseDenylist(d)
+	return d
+}
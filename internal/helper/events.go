@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/internal/broker"
+	"github.com/phanthehoang2503/small-project/internal/event"
+	"github.com/phanthehoang2503/small-project/internal/serviceauth"
+)
+
+// SignEvents makes b send a service token of clientID with each message it
+// publishes, from auth-service's SERVICE_TOKEN_URL. The client needs the
+// events audience in SERVICE_CLIENTS. Without a client secret messages are
+// sent without a token, and consumers reject them.
+func SignEvents(b *broker.Broker, clientID string) {
+	secret := ServiceClientSecret(clientID)
+	if secret == "" {
+		log.Printf("no client secret for %s, its events are not authenticated", clientID)
+		return
+	}
+	tokenURL := os.Getenv("SERVICE_TOKEN_URL")
+	if tokenURL == "" {
+		tokenURL = "http://auth-service:8084/auth/token"
+	}
+	src := serviceauth.NewTokenSource(tokenURL, clientID, secret, event.AudienceEvents)
+	b.AuthenticateWith(serviceauth.MessageToken(src.Token))
+}
+
+// VerifyEvents makes b's consumers accept only messages carrying a service
+// token of the service that publishes them, verified like access tokens
+// (see TokenVerifier). Tokens are accepted EVENTS_TOKEN_LEEWAY (default 24h)
+// past their expiry, for messages that waited in a queue.
+// EVENTS_ALLOW_UNAUTHENTICATED=true also accepts messages without a token,
+// while publishers are being given client secrets.
+func VerifyEvents(b *broker.Broker) {
+	leeway, err := time.ParseDuration(os.Getenv("EVENTS_TOKEN_LEEWAY"))
+	if err != nil {
+		leeway = 24 * time.Hour
+	}
+	v := TokenVerifier()
+	v.Leeway = leeway
+
+	allowMissing := os.Getenv("EVENTS_ALLOW_UNAUTHENTICATED") == "true"
+	if allowMissing {
+		log.Println("EVENTS_ALLOW_UNAUTHENTICATED enabled, accepting messages without a service token")
+	}
+	b.VerifyWith(serviceauth.MessageVerifier(v, allowMissing))
+}
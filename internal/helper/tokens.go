@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+	"github.com/phanthehoang2503/small-project/internal/serviceauth"
+)
+
+// JWT_ISSUER and JWT_AUDIENCE must match between auth-service and the services
+// verifying its tokens.
+func tokenIssuer() string {
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		return iss
+	}
+	return "auth-service"
+}
+
+func tokenAudience() string {
+	if aud := os.Getenv("JWT_AUDIENCE"); aud != "" {
+		return aud
+	}
+	return "small-project"
+}
+
+// legacyHS256 reports whether JWT_LEGACY_HS256 opts in to shared-secret tokens
+func legacyHS256() bool {
+	return os.Getenv("JWT_LEGACY_HS256") == "true"
+}
+
+// TokenVerifier verifies access tokens against the JWKS published by
+// auth-service at JWKS_URL, cached for JWKS_CACHE_TTL. HS256 tokens signed
+// with JWT_SECRET are also accepted when JWT_LEGACY_HS256=true.
+func TokenVerifier() *middleware.Verifier {
+	url := os.Getenv("JWKS_URL")
+	if url == "" {
+		url = "http://auth-service:8084/.well-known/jwks.json"
+	}
+	cacheTTL, err := time.ParseDuration(os.Getenv("JWKS_CACHE_TTL"))
+	if err != nil {
+		cacheTTL = 10 * time.Minute
+	}
+
+	v := middleware.NewVerifier(url, tokenIssuer(), tokenAudience(), cacheTTL)
+	if legacyHS256() {
+		log.Println("JWT_LEGACY_HS256 enabled, accepting HS256 tokens signed with JWT_SECRET")
+		v.AllowLegacyHS256([]byte(os.Getenv("JWT_SECRET")))
+	}
+	return v
+}
+
+// TokenSigner loads the keys auth-service signs access tokens with from
+// JWT_SIGNING_KEYS_DIR, signing with JWT_ACTIVE_KID. Without a key directory
+// an Ed25519 key is generated at startup, so tokens do not survive a restart.
+// JWT_LEGACY_HS256=true signs HS256 tokens with JWT_SECRET instead.
+func TokenSigner() *middleware.Signer {
+	if legacyHS256() {
+		log.Println("JWT_LEGACY_HS256 enabled, signing HS256 tokens with JWT_SECRET")
+		return middleware.NewLegacySigner([]byte(os.Getenv("JWT_SECRET")), tokenIssuer(), tokenAudience())
+	}
+
+	var keys []middleware.SigningKey
+	if dir := os.Getenv("JWT_SIGNING_KEYS_DIR"); dir != "" {
+		loaded, err := middleware.LoadSigningKeys(dir)
+		if err != nil {
+			log.Fatalf("failed to load signing keys: %v", err)
+		}
+		keys = loaded
+	}
+	if len(keys) == 0 {
+		log.Println("no signing keys in JWT_SIGNING_KEYS_DIR, using a generated Ed25519 key")
+		key, err := middleware.GenerateSigningKey(middleware.AlgEdDSA)
+		if err != nil {
+			log.Fatalf("failed to generate signing key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+
+	s, err := middleware.NewSigner(keys, os.Getenv("JWT_ACTIVE_KID"), tokenIssuer(), tokenAudience())
+	if err != nil {
+		log.Fatalf("failed to create token signer: %v", err)
+	}
+	return s
+}
+
+// ServiceClientSecret returns the client secret of a service from
+// <SERVICE>_CLIENT_SECRET, e.g. ORDER_SERVICE_CLIENT_SECRET for order-service.
+// auth-service reads the same variables to check them.
+func ServiceClientSecret(clientID string) string {
+	name := strings.ToUpper(strings.ReplaceAll(clientID, "-", "_"))
+	return os.Getenv(name + "_CLIENT_SECRET")
+}
+
+// ServiceClient returns an HTTP client that calls the audience service as
+// clientID, with tokens from auth-service's SERVICE_TOKEN_URL. Without a
+// client secret requests are sent without a token.
+func ServiceClient(clientID, audience string, timeout time.Duration) *http.Client {
+	secret := ServiceClientSecret(clientID)
+	if secret == "" {
+		log.Printf("no client secret for %s, calls to %s are not authenticated", clientID, audience)
+		return &http.Client{Timeout: timeout}
+	}
+	tokenURL := os.Getenv("SERVICE_TOKEN_URL")
+	if tokenURL == "" {
+		tokenURL = "http://auth-service:8084/auth/token"
+	}
+	return serviceauth.NewClient(serviceauth.NewTokenSource(tokenURL, clientID, secret, audience), timeout)
+}
@@ -0,0 +1,37 @@
+package message
+
+import "time"
+
+// WishlistPriceDropped is published by cart-service when a product on
+// wishlists with a notification email gets cheaper.
+type WishlistPriceDropped struct {
+	ProductID uint              `json:"product_id"`
+	Name      string            `json:"name"`
+	OldPrice  int64             `json:"old_price"`
+	NewPrice  int64             `json:"new_price"`
+	Watchers  []WishlistWatcher `json:"watchers"`
+}
+
+type WishlistWatcher struct {
+	UserID    uint     `json:"user_id"`
+	Email     string   `json:"email"`
+	Wishlists []string `json:"wishlists"` // names of the user's lists holding the product
+}
+
+// CartAbandoned is published by cart-service when a user's cart has been
+// idle for a while, so the user can be reminded of it.
+type CartAbandoned struct {
+	UserID         uint                `json:"user_id"`
+	Email          string              `json:"email"`
+	Items          []CartAbandonedItem `json:"items"`
+	Total          int64               `json:"total"`
+	LastActivityAt time.Time           `json:"last_activity_at"`
+}
+
+type CartAbandonedItem struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	Price     int64  `json:"price"`
+	Subtotal  int64  `json:"subtotal"`
+}
@@ -0,0 +1,30 @@
+package message
+
+type InventoryLow struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	Stock     int    `json:"stock"`
+	Threshold int    `json:"threshold"`
+}
+
+type InventoryRestocked struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	Stock     int    `json:"stock"`
+	Threshold int    `json:"threshold"`
+}
+
+// InventoryBackInStock is published when an out of stock product can be
+// bought again, with the users who asked to be told.
+type InventoryBackInStock struct {
+	ProductID   uint              `json:"product_id"`
+	Name        string            `json:"name"`
+	Stock       int               `json:"stock"`
+	Subscribers []StockSubscriber `json:"subscribers"`
+}
+
+// StockSubscriber is a user waiting for a back-in-stock email
+type StockSubscriber struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+}
@@ -1,12 +1,13 @@
 package message
 
 type OrderRequested struct {
-	CorrelationID string      `json:"correlation_id"`
-	OrderUUID     string      `json:"order_uuid"`
-	UserID        uint        `json:"user_id"`
-	Total         int64       `json:"total"`
-	Currency      string      `json:"currency"`
-	Items         []OrderItem `json:"items"`
+	CorrelationID  string      `json:"correlation_id"`
+	OrderUUID      string      `json:"order_uuid"`
+	UserID         uint        `json:"user_id"`
+	Total          int64       `json:"total"`
+	Currency       string      `json:"currency"`
+	ShippingRegion string      `json:"shipping_region,omitempty"`
+	Items          []OrderItem `json:"items"`
 }
 
 type OrderItem struct {
@@ -27,11 +28,20 @@ type StockFailed struct {
 
 // New structs for Refactor
 type InventoryReserved struct {
-	CorrelationID string `json:"correlation_id"`
-	OrderUUID     string `json:"order_uuid"`
-	UserID        uint   `json:"user_id"`
-	Total         int64  `json:"total"`
-	Currency      string `json:"currency"`
+	CorrelationID string            `json:"correlation_id"`
+	OrderUUID     string            `json:"order_uuid"`
+	UserID        uint              `json:"user_id"`
+	Total         int64             `json:"total"`
+	Currency      string            `json:"currency"`
+	Allocations   []StockAllocation `json:"allocations"`
+}
+
+// StockAllocation is the quantity of a product shipped from one warehouse
+type StockAllocation struct {
+	ProductID     uint   `json:"product_id"`
+	WarehouseID   uint   `json:"warehouse_id"`
+	WarehouseCode string `json:"warehouse_code"`
+	Quantity      int    `json:"quantity"`
 }
 
 type InventoryReservationFailed struct {
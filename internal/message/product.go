@@ -1,8 +1,11 @@
 package message
 
 type ProductMessage struct {
-	ID    uint   `json:"id"`
-	Name  string `json:"name"`
-	Price int64  `json:"price"`
-	Stock int    `json:"stock"`
+	ID             uint   `json:"id"`
+	Name           string `json:"name"`
+	Price          int64  `json:"price"`
+	Stock          int    `json:"stock"`
+	Category       string `json:"category,omitempty"`
+	MaxPerOrder    int    `json:"max_per_order,omitempty"`
+	MaxPerCustomer int    `json:"max_per_customer,omitempty"`
 }
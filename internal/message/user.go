@@ -0,0 +1,62 @@
+package message
+
+import "time"
+
+// UserLoggedIn is published by auth-service after a successful login.
+// CartToken is the guest cart token sent with the login request, if any.
+type UserLoggedIn struct {
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	CartToken string `json:"cart_token,omitempty"`
+}
+
+// UserRegistered is published by auth-service when an account is created,
+// and UserVerificationRequested when the user asks for a new link. Both carry
+// the email verification token and the link to send.
+type UserRegistered struct {
+	UserID            uint      `json:"user_id"`
+	Email             string    `json:"email"`
+	Username          string    `json:"username"`
+	VerificationToken string    `json:"verification_token"`
+	VerifyURL         string    `json:"verify_url"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+type UserVerificationRequested = UserRegistered
+
+// PasswordResetRequested is published by auth-service when a user asks to
+// reset a forgotten password.
+type PasswordResetRequested struct {
+	UserID     uint      `json:"user_id"`
+	Email      string    `json:"email"`
+	ResetToken string    `json:"reset_token"`
+	ResetURL   string    `json:"reset_url"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// UserLockedOut is published by auth-service when failed logins lock an
+// account, so its owner can be warned.
+type UserLockedOut struct {
+	UserID uint      `json:"user_id"`
+	Email  string    `json:"email"`
+	IP     string    `json:"ip"` // client of the failure that locked it
+	Until  time.Time `json:"until"`
+}
+
+// EmailChangeRequested is published by auth-service when a user asks to
+// change their email. It goes to the new address, which must be confirmed.
+type EmailChangeRequested struct {
+	UserID       uint      `json:"user_id"`
+	Email        string    `json:"email"` // the new address
+	OldEmail     string    `json:"old_email"`
+	ConfirmToken string    `json:"confirm_token"`
+	ConfirmURL   string    `json:"confirm_url"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// UserDeleted is published by auth-service when a user deletes their
+// account, so other services can purge or anonymize the user's data.
+type UserDeleted struct {
+	UserID    uint      `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Denylist holds the IDs (jti) of access tokens revoked before they expire,
+// and the sessions (sid) whose access tokens are all revoked.
+type Denylist interface {
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// RevokeSession revokes the session's access tokens issued until now;
+	// until is when the last of them expires.
+	RevokeSession(ctx context.Context, sid string, until time.Time) error
+	IsSessionRevoked(ctx context.Context, sid string) (bool, error)
+}
+
+// revokedTokens is consulted by JWTMiddleware; nil disables the check.
+var revokedTokens Denylist
+
+// UseDenylist makes JWTMiddleware reject tokens revoked in d.
+func UseDenylist(d Denylist) {
+	revokedTokens = d
+}
+
+// RedisDenylist keeps revoked token IDs in Redis until the token would have
+// expired anyway.
+type RedisDenylist struct {
+	client *redis.Client
+}
+
+func NewRedisDenylist(client *redis.Client) *RedisDenylist {
+	return &RedisDenylist{client: client}
+}
+
+func denylistKey(jti string) string {
+	return "jwt:revoked:" + jti
+}
+
+func sessionDenylistKey(sid string) string {
+	return "jwt:revoked-session:" + sid
+}
+
+func (d *RedisDenylist) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil // already expired
+	}
+	return d.client.Set(ctx, denylistKey(jti), 1, ttl).Err()
+}
+
+func (d *RedisDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	err := d.client.Get(ctx, denylistKey(jti)).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *RedisDenylist) RevokeSession(ctx context.Context, sid string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	return d.client.Set(ctx, sessionDenylistKey(sid), 1, ttl).Err()
+}
+
+func (d *RedisDenylist) IsSessionRevoked(ctx context.Context, sid string) (bool, error) {
+	err := d.client.Get(ctx, sessionDenylistKey(sid)).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
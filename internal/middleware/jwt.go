@@ -1,8 +1,9 @@
 package middleware
 
 import (
-	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,42 +12,19 @@ import (
 )
 
 type Claims struct {
-	UserID uint `json:"sub"`
+	UserID    uint     `json:"sub"`
+	Roles     []string `json:"roles,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"` // set on service tokens, which have no user
+	SessionID string   `json:"sid,omitempty"`       // login session of user tokens
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(secret []byte, userID uint, expHours int) (string, error) {
-	if expHours <= 0 {
-		expHours = 72
-	}
-	claims := Claims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expHours) * time.Hour)),
-		},
-	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString(secret)
-}
+// DefaultAccessTokenTTL is the lifetime of access tokens; sessions last
+// longer through refresh tokens.
+const DefaultAccessTokenTTL = 15 * time.Minute
 
-func ParseToken(secret []byte, tokenStr string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return secret, nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
-	}
-	return nil, errors.New("invalid token")
-}
-
-func JWTMiddleware(secret []byte) gin.HandlerFunc {
+// JWTMiddleware authenticates requests with a bearer access token checked by v.
+func JWTMiddleware(v *Verifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -61,13 +39,43 @@ func JWTMiddleware(secret []byte) gin.HandlerFunc {
 		}
 
 		tokenStr := parts[1]
-		claims, err := ParseToken(secret, tokenStr)
+		claims, err := v.Parse(c.Request.Context(), tokenStr)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
 
-		c.Set("user_id", claims.UserID)
+		if revokedTokens != nil && claims.ID != "" {
+			revoked, err := revokedTokens.IsRevoked(c.Request.Context(), claims.ID)
+			if err == nil && !revoked && claims.SessionID != "" {
+				revoked, err = revokedTokens.IsSessionRevoked(c.Request.Context(), claims.SessionID)
+			}
+			if err != nil {
+				// like the rate limiter, a Redis outage does not lock everyone out;
+				// access tokens are short-lived
+				log.Printf("[jwt] denylist check failed: %v", err)
+			} else if revoked {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token revoked"})
+				return
+			}
+		}
+
+		if claims.IsService() {
+			c.Set("service_client", claims.ClientID)
+			// a service calling for one of its users names them; without the
+			// header the request has no user
+			if obo := c.GetHeader(HeaderOnBehalfOf); obo != "" {
+				userID, err := strconv.ParseUint(obo, 10, 64)
+				if err != nil || userID == 0 {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid " + HeaderOnBehalfOf + " header"})
+					return
+				}
+				c.Set("user_id", uint(userID))
+			}
+		} else {
+			c.Set("user_id", claims.UserID)
+		}
+		c.Set("token_claims", claims)
 		c.Next()
 	}
 }
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles a user can hold. Every user is a customer; the others are granted
+// by an admin through auth-service.
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+	RoleService  = "service" // other services calling on their own behalf
+)
+
+// Roles lists the known roles
+var Roles = []string{RoleCustomer, RoleSupport, RoleAdmin, RoleService}
+
+// Permissions checked by RequirePermission
+const (
+	PermCatalogWrite    = "catalog:write"    // products, prices, stock and warehouses
+	PermReviewsModerate = "reviews:moderate" // approve or reject reviews
+	PermCouponsManage   = "coupons:manage"   // create and list coupons
+	PermOrdersManage    = "orders:manage"    // change the status of any order
+	PermUsersManage     = "users:manage"     // grant and revoke roles
+)
+
+// rolePermissions is what each role may do besides acting on its own data
+var rolePermissions = map[string][]string{
+	RoleCustomer: {},
+	RoleSupport:  {PermReviewsModerate, PermOrdersManage},
+	RoleAdmin:    {PermCatalogWrite, PermReviewsModerate, PermCouponsManage, PermOrdersManage, PermUsersManage},
+	RoleService:  {PermOrdersManage},
+}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasRole reports whether the token holds any of the roles. Tokens without
+// roles predate them and count as customer.
+func (c *Claims) HasRole(roles ...string) bool {
+	held := c.Roles
+	if len(held) == 0 {
+		held = []string{RoleCustomer}
+	}
+	for _, h := range held {
+		for _, r := range roles {
+			if h == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Can reports whether one of the token's roles grants perm
+func (c *Claims) Can(perm string) bool {
+	for _, role := range c.Roles {
+		for _, p := range rolePermissions[role] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClaimsFrom returns the claims JWTMiddleware stored on the request
+func ClaimsFrom(c *gin.Context) (*Claims, bool) {
+	v, ok := c.Get("token_claims")
+	if !ok {
+		return nil, false
+	}
+	claims, ok := v.(*Claims)
+	return claims, ok
+}
+
+// RequireRole lets the request through if the token holds any of the roles.
+// It must run after JWTMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return authorize(func(claims *Claims) bool { return claims.HasRole(roles...) })
+}
+
+// RequirePermission lets the request through if one of the token's roles
+// grants perm. It must run after JWTMiddleware.
+func RequirePermission(perm string) gin.HandlerFunc {
+	return authorize(func(claims *Claims) bool { return claims.Can(perm) })
+}
+
+func authorize(allowed func(*Claims) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := ClaimsFrom(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		if !allowed(claims) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderOnBehalfOf carries the ID of the user a service calls for. It is
+// only trusted on requests authenticated with a service token.
+const HeaderOnBehalfOf = "X-On-Behalf-Of"
+
+// IsService reports whether the token belongs to a service rather than a user
+func (c *Claims) IsService() bool {
+	return c.ClientID != "" && c.HasRole(RoleService)
+}
+
+// RequireService lets the request through only for service tokens, from one
+// of clients if any are given. It must run after JWTMiddleware.
+func RequireService(clients ...string) gin.HandlerFunc {
+	return authorize(func(claims *Claims) bool {
+		if !claims.IsService() {
+			return false
+		}
+		if len(clients) == 0 {
+			return true
+		}
+		for _, id := range clients {
+			if claims.ClientID == id {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RequireUser rejects requests without a user: service tokens not acting
+// for one with HeaderOnBehalfOf. It must run after JWTMiddleware.
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("user_id"); !ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "a user is required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireUserToken rejects service tokens, also when they act for a user:
+// the route is only for users calling for themselves. It must run after
+// JWTMiddleware.
+func RequireUserToken() gin.HandlerFunc {
+	return RequireUserOrService()
+}
+
+// RequireUserOrService lets through user tokens, and service tokens of one
+// of clients acting for a user with HeaderOnBehalfOf. It must run after
+// JWTMiddleware.
+func RequireUserOrService(clients ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := ClaimsFrom(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		if claims.IsService() {
+			allowed := false
+			for _, id := range clients {
+				if claims.ClientID == id {
+					allowed = true
+				}
+			}
+			if !allowed {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+				return
+			}
+			if _, ok := c.Get("user_id"); !ok {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "a user is required"})
+				return
+			}
+		}
+		c.Next()
+	}
+}
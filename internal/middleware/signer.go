@@ -0,0 +1,274 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256" // legacy shared-secret mode
+)
+
+// SigningKey is a private key identified by its kid.
+type SigningKey struct {
+	KID string
+	Alg string
+	Key crypto.Signer // *rsa.PrivateKey or ed25519.PrivateKey
+}
+
+// Signer issues access tokens. The active key signs; the others are only
+// published in the JWKS so tokens they signed still verify during rotation.
+type Signer struct {
+	Issuer   string
+	Audience string
+	active   SigningKey
+	keys     []SigningKey
+	secret   []byte // HS256 legacy mode when set
+}
+
+// NewSigner signs with the key whose kid is activeKID, or the last key by
+// kid order when activeKID is empty.
+func NewSigner(keys []SigningKey, activeKID, issuer, audience string) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	s := &Signer{Issuer: issuer, Audience: audience, keys: keys, active: keys[len(keys)-1]}
+	if activeKID != "" {
+		found := false
+		for _, k := range keys {
+			if k.KID == activeKID {
+				s.active, found = k, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("active signing key %q not found", activeKID)
+		}
+	}
+	return s, nil
+}
+
+// NewLegacySigner signs HS256 tokens with a shared secret. Any service that
+// can verify these tokens can also mint them.
+func NewLegacySigner(secret []byte, issuer, audience string) *Signer {
+	return &Signer{Issuer: issuer, Audience: audience, secret: secret}
+}
+
+// Legacy reports whether tokens are signed with the shared secret.
+func (s *Signer) Legacy() bool {
+	return s.secret != nil
+}
+
+// Issue signs an access token for the user holding roles, in the login
+// session sessionID. Each token has its own ID (jti) so it can be revoked
+// before it expires; revoking the session revokes all of them.
+func (s *Signer) Issue(userID uint, roles []string, sessionID string, ttl time.Duration) (string, *Claims, error) {
+	if ttl <= 0 {
+		ttl = DefaultAccessTokenTTL
+	}
+	claims := s.NewClaims(userID, s.Audience, ttl)
+	claims.Roles = roles
+	claims.SessionID = sessionID
+	signed, err := s.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// IssueService signs a token for the service client, accepted by the
+// services named in audiences. It holds the service role and no user.
+func (s *Signer) IssueService(clientID string, audiences []string, ttl time.Duration) (string, *Claims, error) {
+	if ttl <= 0 {
+		ttl = DefaultAccessTokenTTL
+	}
+	claims := s.NewClaims(0, "", ttl)
+	claims.Audience = audiences
+	claims.ClientID = clientID
+	claims.Roles = []string{RoleService}
+	signed, err := s.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// NewClaims returns claims for userID valid for ttl from now. Tokens for
+// other purposes than access use their own audience, so that verifiers
+// expecting access tokens reject them.
+func (s *Signer) NewClaims(userID uint, audience string, ttl time.Duration) *Claims {
+	now := time.Now()
+	return &Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    s.Issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+}
+
+// Sign signs claims with the active key.
+func (s *Signer) Sign(claims *Claims) (string, error) {
+	if s.secret != nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+	t := jwt.NewWithClaims(signingMethod(s.active.Alg), claims)
+	t.Header["kid"] = s.active.KID
+	return t.SignedString(s.active.Key)
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	if alg == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKSet is the body of /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of every signing key. It is empty in legacy mode.
+func (s *Signer) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(s.keys))}
+	for _, k := range s.keys {
+		switch pub := k.Key.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: k.KID,
+				Use: "sig",
+				Alg: AlgRS256,
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: k.KID,
+				Use: "sig",
+				Alg: AlgEdDSA,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return set
+}
+
+// publicKeys returns the verification keys by kid.
+func (s *Signer) publicKeys() map[string]crypto.PublicKey {
+	keys := make(map[string]crypto.PublicKey, len(s.keys))
+	for _, k := range s.keys {
+		keys[k.KID] = k.Key.Public()
+	}
+	return keys
+}
+
+// LoadSigningKeys reads every <kid>.pem private key (PKCS#8, or PKCS#1 for
+// RSA) in dir, sorted by kid. To rotate, add a key and make it active; drop
+// the old file once the tokens it signed have expired.
+func LoadSigningKeys(dir string) ([]SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keys := make([]SigningKey, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		keys = append(keys, key.withKID(strings.TrimSuffix(filepath.Base(p), ".pem")))
+	}
+	return keys, nil
+}
+
+// GenerateSigningKey creates a new key, for development when no key
+// directory is configured. Tokens it signs do not survive a restart.
+func GenerateSigningKey(alg string) (SigningKey, error) {
+	kid := time.Now().UTC().Format("20060102T150405")
+	if alg == AlgRS256 {
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		return SigningKey{KID: kid, Alg: AlgRS256, Key: k}, nil
+	}
+	_, k, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{KID: kid, Alg: AlgEdDSA, Key: k}, nil
+}
+
+func parsePrivateKey(data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, errors.New("no PEM block")
+	}
+
+	var key any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return SigningKey{Alg: AlgRS256, Key: k}, nil
+	case ed25519.PrivateKey:
+		return SigningKey{Alg: AlgEdDSA, Key: k}, nil
+	}
+	return SigningKey{}, fmt.Errorf("unsupported key type %T", key)
+}
+
+func (k SigningKey) withKID(kid string) SigningKey {
+	k.KID = kid
+	return k
+}
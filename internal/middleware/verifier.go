@@ -0,0 +1,252 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRefetch limits JWKS fetches triggered by unknown kids
+const minRefetch = 30 * time.Second
+
+// Verifier checks access tokens: signature against the issuer's JWKS
+// (fetched and cached), and the iss, aud and exp claims. HS256 tokens are
+// accepted only when a legacy secret is set.
+type Verifier struct {
+	Issuer   string
+	Audience string
+	Service  string        // audience of service tokens sent to this service, if accepted
+	Leeway   time.Duration // accepted clock skew, or age past expiry for tokens kept with messages
+
+	jwksURL      string
+	cacheTTL     time.Duration
+	legacySecret []byte
+	client       *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewVerifier verifies tokens against the JWKS at jwksURL, cached for cacheTTL.
+func NewVerifier(jwksURL, issuer, audience string, cacheTTL time.Duration) *Verifier {
+	if cacheTTL <= 0 {
+		cacheTTL = 10 * time.Minute
+	}
+	return &Verifier{
+		Issuer:   issuer,
+		Audience: audience,
+		jwksURL:  jwksURL,
+		cacheTTL: cacheTTL,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		keys:     map[string]crypto.PublicKey{},
+	}
+}
+
+// NewLocalVerifier verifies tokens with the signer's own keys, for the
+// issuing service itself.
+func NewLocalVerifier(s *Signer) *Verifier {
+	v := &Verifier{Issuer: s.Issuer, Audience: s.Audience, legacySecret: s.secret}
+	if !s.Legacy() {
+		v.keys = s.publicKeys()
+	}
+	return v
+}
+
+// AllowLegacyHS256 makes the verifier also accept HS256 tokens signed with secret.
+func (v *Verifier) AllowLegacyHS256(secret []byte) {
+	v.legacySecret = secret
+}
+
+// AcceptServiceTokens makes the verifier also accept service tokens issued
+// for service, the name of the service verifying them.
+func (v *Verifier) AcceptServiceTokens(service string) {
+	v.Service = service
+}
+
+// Parse verifies an access token, a user's or, if accepted, a service's
+// issued for this service, and returns its claims.
+func (v *Verifier) Parse(ctx context.Context, tokenStr string) (*Claims, error) {
+	claims, err := v.ParseFor(ctx, tokenStr, v.Audience)
+	if err == nil && claims.IsService() {
+		return nil, errors.New("service token without a service audience")
+	}
+	if v.Service == "" || !errors.Is(err, jwt.ErrTokenInvalidAudience) {
+		return claims, err
+	}
+
+	claims, err = v.ParseFor(ctx, tokenStr, v.Service)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.IsService() {
+		return nil, errors.New("not a service token")
+	}
+	return claims, nil
+}
+
+// ParseFor verifies a token issued for audience and returns its claims.
+func (v *Verifier) ParseFor(ctx context.Context, tokenStr, audience string) (*Claims, error) {
+	claims := &Claims{}
+	if err := v.ParseClaims(ctx, tokenStr, audience, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ParseClaims verifies a token issued for audience and decodes its claims
+// into claims, for tokens other than access tokens, such as the ID tokens
+// of an OpenID provider.
+func (v *Verifier) ParseClaims(ctx context.Context, tokenStr, audience string, claims jwt.Claims) error {
+	methods := []string{AlgRS256, AlgEdDSA}
+	if v.legacySecret != nil {
+		methods = append(methods, AlgHS256)
+	}
+
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			return v.legacySecret, nil
+		}
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("missing kid")
+		}
+		return v.key(ctx, kid)
+	},
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(v.Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(v.Leeway),
+	)
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return errors.New("invalid token")
+	}
+	return nil
+}
+
+// key returns the public key for kid, refreshing the JWKS when the cache is
+// stale or the kid is unknown (a key was rotated in).
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.RLock()
+	k, ok := v.keys[kid]
+	age := time.Since(v.fetchedAt)
+	v.mu.RUnlock()
+
+	if v.jwksURL == "" {
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return k, nil
+	}
+	if ok && age < v.cacheTTL {
+		return k, nil
+	}
+	if !ok && age < minRefetch {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+
+	if err := v.refresh(ctx); err != nil {
+		log.Printf("[jwt] failed to fetch JWKS: %v", err)
+		if ok {
+			return k, nil // keep using the cached key
+		}
+		return nil, err
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown kid %q", kid)
+}
+
+func (v *Verifier) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		v.markFetched()
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		v.markFetched()
+		return fmt.Errorf("jwks status %d", resp.StatusCode)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		v.markFetched()
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		k, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("[jwt] skipping JWK %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = k
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+// markFetched delays the next attempt after a failed fetch
+func (v *Verifier) markFetched() {
+	v.mu.Lock()
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+}
+
+func (j JWK) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
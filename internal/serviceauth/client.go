@@ -0,0 +1,156 @@
+// Package serviceauth gets client-credentials tokens from auth-service and
+// attaches them to calls between services.
+package serviceauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+// refreshBefore is how long before expiry a token is replaced
+const refreshBefore = 30 * time.Second
+
+// TokenSource fetches and caches a service token for one audience.
+type TokenSource struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Audience     string // the service called
+
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewTokenSource(tokenURL, clientID, clientSecret, audience string) *TokenSource {
+	return &TokenSource{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Audience:     audience,
+		client:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type tokenResp struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Token returns the cached token, fetching a new one when it is about to expire.
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expires.Add(-refreshBefore)) {
+		return s.token, nil
+	}
+
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"audience":   {s.Audience},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("service token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("service token request returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var out tokenResp
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	s.token = out.AccessToken
+	s.expires = time.Now().Add(time.Duration(out.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+// Invalidate drops the cached token, e.g. after it was rejected.
+func (s *TokenSource) Invalidate() {
+	s.mu.Lock()
+	s.token = ""
+	s.mu.Unlock()
+}
+
+// Transport sends each request with a token from Source. A 401 drops the
+// token and the request is retried once with a new one, if its body can be
+// sent again.
+type Transport struct {
+	Source *TokenSource
+	Base   http.RoundTripper // http.DefaultTransport if nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	t.Source.Invalidate()
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	resp.Body.Close()
+	return t.send(retry)
+}
+
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	token, err := t.Source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	// a RoundTripper must not change the caller's request
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
+
+// NewClient returns an HTTP client authenticating its requests with tokens
+// from src.
+func NewClient(src *TokenSource, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &Transport{Source: src},
+	}
+}
+
+// OnBehalfOf marks the request as made for the user, see
+// middleware.HeaderOnBehalfOf.
+func OnBehalfOf(req *http.Request, userID uint) {
+	req.Header.Set(middleware.HeaderOnBehalfOf, strconv.FormatUint(uint64(userID), 10))
+}
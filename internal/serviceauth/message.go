@@ -0,0 +1,54 @@
+package serviceauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/phanthehoang2503/small-project/internal/event"
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+var errNoMessageToken = errors.New("message has no service token")
+
+// MessageToken returns the token function of broker.AuthenticateWith: a
+// service token for event.AudienceEvents from token, e.g. a TokenSource's,
+// for every exchange but the logs', which only feeds logger-service.
+func MessageToken(token func(ctx context.Context) (string, error)) func(ctx context.Context, exchange string) (string, error) {
+	return func(ctx context.Context, exchange string) (string, error) {
+		if exchange == event.ExchangeLogs {
+			return "", nil
+		}
+		return token(ctx)
+	}
+}
+
+// MessageVerifier returns the check of broker.VerifyWith: the message must
+// carry a service token for event.AudienceEvents from the service that
+// publishes its routing key (see event.PublisherOf). With allowMissing,
+// messages without a token pass, for deployments where not every publisher
+// sends one yet; a token that is sent must still be valid.
+func MessageVerifier(v *middleware.Verifier, allowMissing bool) func(ctx context.Context, routingKey, authorization string) error {
+	return func(ctx context.Context, routingKey, authorization string) error {
+		token, ok := strings.CutPrefix(authorization, "Bearer ")
+		if !ok || token == "" {
+			if allowMissing {
+				return nil
+			}
+			return errNoMessageToken
+		}
+
+		claims, err := v.ParseFor(ctx, token, event.AudienceEvents)
+		if err != nil {
+			return fmt.Errorf("invalid service token: %w", err)
+		}
+		if !claims.IsService() {
+			return errors.New("not a service token")
+		}
+		if publisher := event.PublisherOf(routingKey); claims.ClientID != publisher {
+			return fmt.Errorf("%s may not publish %s", claims.ClientID, routingKey)
+		}
+		return nil
+	}
+}
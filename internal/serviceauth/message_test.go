@@ -0,0 +1,105 @@
+package serviceauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/internal/event"
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+func newTestSigner(t *testing.T) *middleware.Signer {
+	t.Helper()
+	key, err := middleware.GenerateSigningKey(middleware.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	s, err := middleware.NewSigner([]middleware.SigningKey{key}, "", "auth-service", "small-project")
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+	return s
+}
+
+func TestMessageVerifier(t *testing.T) {
+	s := newTestSigner(t)
+	other := newTestSigner(t)
+
+	service := func(s *middleware.Signer, client string, aud string, ttl time.Duration) string {
+		tok, _, err := s.IssueService(client, []string{aud}, ttl)
+		if err != nil {
+			t.Fatalf("issue service token: %v", err)
+		}
+		return "Bearer " + tok
+	}
+	user, _, err := s.Issue(7, []string{middleware.RoleCustomer}, "", time.Minute)
+	if err != nil {
+		t.Fatalf("issue user token: %v", err)
+	}
+	// expired an hour ago, like a token kept with a message that waited
+	expired, err := s.Sign(func() *middleware.Claims {
+		c := s.NewClaims(0, event.AudienceEvents, time.Minute)
+		c.ClientID = "payment-service"
+		c.Roles = []string{middleware.RoleService}
+		c.ExpiresAt.Time = time.Now().Add(-time.Hour)
+		return c
+	}())
+	if err != nil {
+		t.Fatalf("sign expired token: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		routingKey   string
+		auth         string
+		allowMissing bool
+		leeway       time.Duration
+		ok           bool
+	}{
+		{"publisher of the key", event.RoutingKeyPaymentSucceeded, service(s, "payment-service", event.AudienceEvents, time.Minute), false, 0, true},
+		{"inventory from product-service", event.RoutingKeyInventoryReserved, service(s, "product-service", event.AudienceEvents, time.Minute), false, 0, true},
+		{"another service", event.RoutingKeyPaymentSucceeded, service(s, "order-service", event.AudienceEvents, time.Minute), false, 0, false},
+		{"unknown domain", "coupon.created", service(s, "cart-service", event.AudienceEvents, time.Minute), false, 0, false},
+		{"token for an API", event.RoutingKeyPaymentSucceeded, service(s, "payment-service", "order-service", time.Minute), false, 0, false},
+		{"user token", event.RoutingKeyUserDeleted, "Bearer " + user, false, 0, false},
+		{"other issuer key", event.RoutingKeyPaymentSucceeded, service(other, "payment-service", event.AudienceEvents, time.Minute), false, 0, false},
+		{"no token", event.RoutingKeyPaymentSucceeded, "", false, 0, false},
+		{"not bearer", event.RoutingKeyPaymentSucceeded, "Basic abc", false, 0, false},
+		{"no token, allowed", event.RoutingKeyPaymentSucceeded, "", true, 0, true},
+		{"bad token, missing allowed", event.RoutingKeyPaymentSucceeded, service(s, "order-service", event.AudienceEvents, time.Minute), true, 0, false},
+		{"expired", event.RoutingKeyPaymentSucceeded, "Bearer " + expired, false, 0, false},
+		{"expired within leeway", event.RoutingKeyPaymentSucceeded, "Bearer " + expired, false, 2 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := middleware.NewLocalVerifier(s)
+			v.Leeway = tt.leeway
+			err := MessageVerifier(v, tt.allowMissing)(context.Background(), tt.routingKey, tt.auth)
+			if tt.ok && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestMessageTokenSkipsLogs(t *testing.T) {
+	calls := 0
+	token := MessageToken(func(ctx context.Context) (string, error) {
+		calls++
+		return "tok", nil
+	})
+
+	if tok, err := token(context.Background(), event.ExchangeLogs); err != nil || tok != "" {
+		t.Errorf("logs exchange: token = %q, err = %v, want none", tok, err)
+	}
+	if tok, err := token(context.Background(), event.ExchangeOrder); err != nil || tok != "tok" {
+		t.Errorf("order exchange: token = %q, err = %v, want tok", tok, err)
+	}
+	if calls != 1 {
+		t.Errorf("token fetched %d times, want 1", calls)
+	}
+}
@@ -40,6 +40,8 @@ func main() {
 	users := flag.Int("users", 5, "Number of concurrent users")
 	duration := flag.Duration("duration", 30*time.Second, "Test duration")
 	replenish := flag.Bool("replenish", false, "Replenish stock for all products to 10000")
+	adminEmail := flag.String("admin-email", "test@example.com", "Admin account (listed in ADMIN_EMAILS) used to seed products")
+	adminPassword := flag.String("admin-password", "password", "Password of the admin account")
 	flag.Parse()
 
 	cfg := Config{
@@ -51,13 +53,20 @@ func main() {
 		Duration:          *duration,
 	}
 
+	// products can only be created and updated by an admin
+	adminToken, err := authenticate(&http.Client{Timeout: 10 * time.Second}, cfg, "tester", *adminEmail, *adminPassword)
+	if err != nil {
+		fmt.Printf("Admin login failed: %v\n", err)
+		return
+	}
+
 	if *replenish {
-		replenishStock(cfg)
+		replenishStock(cfg, adminToken)
 		return
 	}
 
 	// check if products exist, if not, seed them
-	if err := checkAndSeed(cfg); err != nil {
+	if err := checkAndSeed(cfg, adminToken); err != nil {
 		fmt.Printf("Failed to seed products: %v\n", err)
 		return
 	}
@@ -307,7 +316,7 @@ func printStats(cfg Config) {
 	fmt.Println("------------------------------------------------")
 }
 
-func replenishStock(cfg Config) {
+func replenishStock(cfg Config, adminToken string) {
 	fmt.Println("Replenishing stock for all products...")
 	client := &http.Client{Timeout: 30 * time.Second}
 	products, err := getProducts(client, cfg)
@@ -321,6 +330,7 @@ func replenishStock(cfg Config) {
 		body, _ := json.Marshal(p)
 		req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/products/%d", cfg.ProductServiceURL, p.ID), bytes.NewBuffer(body))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminToken)
 		resp, err := client.Do(req)
 		if err != nil {
 			fmt.Printf("Failed to update product %d: %v\n", p.ID, err)
@@ -334,7 +344,7 @@ func replenishStock(cfg Config) {
 	fmt.Println("Stock replenishment execution complete.")
 }
 
-func checkAndSeed(cfg Config) error {
+func checkAndSeed(cfg Config, adminToken string) error {
 	client := &http.Client{Timeout: 30 * time.Second}
 	products, err := getProducts(client, cfg)
 	if err != nil {
@@ -354,7 +364,10 @@ func checkAndSeed(cfg Config) error {
 		}
 		// Fix: API expects an array of products
 		body, _ := json.Marshal([]map[string]interface{}{p})
-		resp, err := client.Post(cfg.ProductServiceURL+"/products", "application/json", bytes.NewBuffer(body))
+		req, _ := http.NewRequest("POST", cfg.ProductServiceURL+"/products", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		resp, err := client.Do(req)
 		if err != nil {
 			return fmt.Errorf("failed to create product %d: %v", i, err)
 		}
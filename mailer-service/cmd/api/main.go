@@ -17,6 +17,9 @@ func main() {
 	b := helper.ConnectRabbit()
 	defer b.Close()
 
+	// events are only accepted from the service that publishes them
+	helper.VerifyEvents(b)
+
 	// declare queue
 	queueName := "mailer_queue"
 	if err := b.DeclareQueue(queueName); err != nil {
@@ -28,6 +31,28 @@ func main() {
 		log.Fatalf("failed to bind queue payment.succeeded: %v", err)
 	}
 
+	// Bind to inventory alerts
+	if err := b.BindQueue(queueName, event.ExchangeProduct, []string{event.RoutingKeyInventoryLow, event.RoutingKeyInventoryRestocked, event.RoutingKeyInventoryBackInStock}); err != nil {
+		log.Fatalf("failed to bind queue inventory alerts: %v", err)
+	}
+
+	// Bind to wishlist price drops and abandoned cart reminders
+	if err := b.BindQueue(queueName, event.ExchangeCart, []string{event.RoutingKeyWishlistPriceDropped, event.RoutingKeyCartAbandoned}); err != nil {
+		log.Fatalf("failed to bind queue cart events: %v", err)
+	}
+
+	// Bind to account emails: verification, password reset and lockout
+	userKeys := []string{
+		event.RoutingKeyUserRegistered,
+		event.RoutingKeyUserVerificationRequested,
+		event.RoutingKeyUserPasswordResetRequested,
+		event.RoutingKeyUserLockedOut,
+		event.RoutingKeyUserEmailChangeRequested,
+	}
+	if err := b.BindQueue(queueName, event.ExchangeUser, userKeys); err != nil {
+		log.Fatalf("failed to bind queue user events: %v", err)
+	}
+
 	// Start consumer
 	c := consumer.NewMailerConsumer(b)
 
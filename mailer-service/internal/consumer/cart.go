@@ -0,0 +1,58 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/phanthehoang2503/small-project/internal/message"
+)
+
+func (c *MailerConsumer) handleWishlistPriceDropped(body []byte) error {
+	var p message.WishlistPriceDropped
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("[mailer] invalid wishlist.price_dropped payload: %v", err)
+		return nil
+	}
+
+	log.Printf("[mailer] product %d price dropped, notifying %d wishlist watcher(s)", p.ProductID, len(p.Watchers))
+
+	for _, w := range p.Watchers {
+		msg := fmt.Sprintf("%q from your wishlist %s is now cheaper.\r\n"+
+			"Was: %d\r\n"+
+			"Now: %d\r\n", p.Name, strings.Join(w.Wishlists, ", "), p.OldPrice, p.NewPrice)
+		// one email per watcher so addresses are not shared
+		if err := send([]string{w.Email}, fmt.Sprintf("Price drop: %s", p.Name), msg); err != nil {
+			log.Printf("[mailer] failed to send price-drop email to user %d: %v", w.UserID, err)
+		}
+	}
+	return nil
+}
+
+func (c *MailerConsumer) handleCartAbandoned(body []byte) error {
+	var p message.CartAbandoned
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("[mailer] invalid cart.abandoned payload: %v", err)
+		return nil
+	}
+	if p.Email == "" || len(p.Items) == 0 {
+		return nil
+	}
+
+	var lines strings.Builder
+	for _, it := range p.Items {
+		fmt.Fprintf(&lines, "- %s x%d: %d\r\n", it.Name, it.Quantity, it.Subtotal)
+	}
+	msg := fmt.Sprintf("You left some items in your cart:\r\n\r\n"+
+		"%s\r\n"+
+		"Total: %d\r\n\r\n"+
+		"To stop these reminders, turn them off in your cart settings.\r\n", lines.String(), p.Total)
+
+	if err := send([]string{p.Email}, "You left items in your cart", msg); err != nil {
+		log.Printf("[mailer] failed to send cart reminder to user %d: %v", p.UserID, err)
+		return err
+	}
+	log.Printf("[mailer] cart reminder sent to user %d", p.UserID)
+	return nil
+}
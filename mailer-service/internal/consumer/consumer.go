@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/smtp"
+	"strings"
 
 	"github.com/phanthehoang2503/small-project/internal/broker"
 	"github.com/phanthehoang2503/small-project/internal/event"
@@ -24,12 +25,46 @@ func (c *MailerConsumer) Start(queueName string) error {
 }
 
 func (c *MailerConsumer) handle(ctx context.Context, routingKey string, body []byte) error {
-	if routingKey == event.RoutingKeyPaymentSucceeded {
+	switch routingKey {
+	case event.RoutingKeyPaymentSucceeded:
 		return c.handleOrderPaid(body)
+	case event.RoutingKeyInventoryLow:
+		return c.handleInventoryLow(body)
+	case event.RoutingKeyInventoryRestocked:
+		return c.handleInventoryRestocked(body)
+	case event.RoutingKeyInventoryBackInStock:
+		return c.handleInventoryBackInStock(body)
+	case event.RoutingKeyWishlistPriceDropped:
+		return c.handleWishlistPriceDropped(body)
+	case event.RoutingKeyCartAbandoned:
+		return c.handleCartAbandoned(body)
+	case event.RoutingKeyUserRegistered, event.RoutingKeyUserVerificationRequested:
+		return c.handleUserRegistered(body)
+	case event.RoutingKeyUserPasswordResetRequested:
+		return c.handlePasswordResetRequested(body)
+	case event.RoutingKeyUserLockedOut:
+		return c.handleUserLockedOut(body)
+	case event.RoutingKeyUserEmailChangeRequested:
+		return c.handleEmailChangeRequested(body)
 	}
 	return nil
 }
 
+// MailHog is available at 'mailhog:1025' inside docker network
+const (
+	smtpAddr = "mailhog:1025"
+	mailFrom = "noreply@example.com"
+)
+
+// send delivers a plain text email
+func send(to []string, subject, body string) error {
+	msg := []byte(fmt.Sprintf("To: %s\r\n"+
+		"Subject: %s\r\n"+
+		"\r\n"+
+		"%s", strings.Join(to, ", "), subject, body))
+	return smtp.SendMail(smtpAddr, nil, mailFrom, to, msg)
+}
+
 type orderPaidPayload struct {
 	OrderUUID string `json:"order_uuid"`
 	Amount    int64  `json:"amount"`
@@ -46,17 +81,12 @@ func (c *MailerConsumer) handleOrderPaid(body []byte) error {
 	log.Printf("[mailer] sending confirmation for order %s", p.OrderUUID)
 
 	// Send email via MailHog
-	from := "noreply@example.com"
 	to := []string{"customer@example.com"} // In real app, this would come from payload or user service lookup
-	msg := []byte(fmt.Sprintf("To: customer@example.com\r\n"+
-		"Subject: Order Confirmation %s\r\n"+
-		"\r\n"+
-		"Thank you for your order!\r\n"+
+	text := fmt.Sprintf("Thank you for your order!\r\n"+
 		"Order ID: %s\r\n"+
-		"Total: %d %s\r\n", p.OrderUUID, p.OrderUUID, p.Amount, p.Currency))
+		"Total: %d %s\r\n", p.OrderUUID, p.Amount, p.Currency)
 
-	// MailHog is available at 'mailhog:1025' inside docker network
-	err := smtp.SendMail("mailhog:1025", nil, from, to, msg)
+	err := send(to, "Order Confirmation "+p.OrderUUID, text)
 	if err != nil {
 		log.Printf("[mailer] failed to send email: %v", err)
 		return err
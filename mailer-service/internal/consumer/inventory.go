@@ -0,0 +1,74 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/phanthehoang2503/small-project/internal/message"
+)
+
+// inventoryAlertTo is where low-stock alerts go
+func inventoryAlertTo() string {
+	if to := os.Getenv("LOW_STOCK_ALERT_EMAIL"); to != "" {
+		return to
+	}
+	return "inventory@example.com"
+}
+
+func (c *MailerConsumer) handleInventoryLow(body []byte) error {
+	var p message.InventoryLow
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("[mailer] invalid inventory.low payload: %v", err)
+		return nil
+	}
+
+	log.Printf("[mailer] sending low-stock alert for product %d", p.ProductID)
+
+	msg := fmt.Sprintf("Product %q (ID %d) is running low.\r\n"+
+		"Stock left: %d (threshold %d)\r\n", p.Name, p.ProductID, p.Stock, p.Threshold)
+	if err := send([]string{inventoryAlertTo()}, fmt.Sprintf("Low stock: %s", p.Name), msg); err != nil {
+		log.Printf("[mailer] failed to send low-stock alert: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (c *MailerConsumer) handleInventoryRestocked(body []byte) error {
+	var p message.InventoryRestocked
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("[mailer] invalid inventory.restocked payload: %v", err)
+		return nil
+	}
+
+	log.Printf("[mailer] sending restock alert for product %d", p.ProductID)
+
+	msg := fmt.Sprintf("Product %q (ID %d) is back above its low-stock threshold.\r\n"+
+		"Stock: %d (threshold %d)\r\n", p.Name, p.ProductID, p.Stock, p.Threshold)
+	if err := send([]string{inventoryAlertTo()}, fmt.Sprintf("Restocked: %s", p.Name), msg); err != nil {
+		log.Printf("[mailer] failed to send restock alert: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (c *MailerConsumer) handleInventoryBackInStock(body []byte) error {
+	var p message.InventoryBackInStock
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("[mailer] invalid inventory.back_in_stock payload: %v", err)
+		return nil
+	}
+
+	log.Printf("[mailer] product %d back in stock, notifying %d subscriber(s)", p.ProductID, len(p.Subscribers))
+
+	msg := fmt.Sprintf("Good news! %q is back in stock.\r\n"+
+		"Product ID: %d\r\n", p.Name, p.ProductID)
+	for _, s := range p.Subscribers {
+		// one email per subscriber so addresses are not shared
+		if err := send([]string{s.Email}, fmt.Sprintf("Back in stock: %s", p.Name), msg); err != nil {
+			log.Printf("[mailer] failed to send back-in-stock email to user %d: %v", s.UserID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/phanthehoang2503/small-project/internal/message"
+)
+
+func (c *MailerConsumer) handleUserRegistered(body []byte) error {
+	var p message.UserRegistered
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("[mailer] invalid user.registered payload: %v", err)
+		return nil
+	}
+	if p.Email == "" || p.VerificationToken == "" {
+		return nil
+	}
+
+	msg := fmt.Sprintf("Hi %s,\r\n\r\n"+
+		"Please confirm your email address by opening this link:\r\n"+
+		"%s\r\n\r\n"+
+		"The link expires at %s. If you did not sign up, ignore this email.\r\n",
+		p.Username, p.VerifyURL, p.ExpiresAt.Format("2006-01-02 15:04 MST"))
+
+	if err := send([]string{p.Email}, "Confirm your email address", msg); err != nil {
+		log.Printf("[mailer] failed to send verification email to user %d: %v", p.UserID, err)
+		return err
+	}
+	log.Printf("[mailer] verification email sent to user %d", p.UserID)
+	return nil
+}
+
+func (c *MailerConsumer) handlePasswordResetRequested(body []byte) error {
+	var p message.PasswordResetRequested
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("[mailer] invalid user.password_reset_requested payload: %v", err)
+		return nil
+	}
+	if p.Email == "" || p.ResetToken == "" {
+		return nil
+	}
+
+	msg := fmt.Sprintf("Someone asked to reset the password of your account.\r\n\r\n"+
+		"To choose a new password, open this link:\r\n"+
+		"%s\r\n\r\n"+
+		"or send this token to /auth/password/reset:\r\n"+
+		"%s\r\n\r\n"+
+		"It expires at %s. If you did not ask for this, ignore this email; your password is unchanged.\r\n",
+		p.ResetURL, p.ResetToken, p.ExpiresAt.Format("2006-01-02 15:04 MST"))
+
+	if err := send([]string{p.Email}, "Reset your password", msg); err != nil {
+		log.Printf("[mailer] failed to send password reset email to user %d: %v", p.UserID, err)
+		return err
+	}
+	log.Printf("[mailer] password reset email sent to user %d", p.UserID)
+	return nil
+}
+
+func (c *MailerConsumer) handleUserLockedOut(body []byte) error {
+	var p message.UserLockedOut
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("[mailer] invalid user.locked_out payload: %v", err)
+		return nil
+	}
+	if p.Email == "" {
+		return nil
+	}
+
+	msg := fmt.Sprintf("Your account was locked after too many failed login attempts (last from %s).\r\n\r\n"+
+		"You can log in again after %s.\r\n"+
+		"If this was not you, reset your password: doing so also unlocks the account.\r\n",
+		p.IP, p.Until.Format("2006-01-02 15:04 MST"))
+
+	if err := send([]string{p.Email}, "Your account was locked", msg); err != nil {
+		log.Printf("[mailer] failed to send lockout email to user %d: %v", p.UserID, err)
+		return err
+	}
+	log.Printf("[mailer] lockout email sent to user %d", p.UserID)
+	return nil
+}
+
+func (c *MailerConsumer) handleEmailChangeRequested(body []byte) error {
+	var p message.EmailChangeRequested
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("[mailer] invalid user.email_change_requested payload: %v", err)
+		return nil
+	}
+	if p.Email == "" || p.ConfirmToken == "" {
+		return nil
+	}
+
+	msg := fmt.Sprintf("You asked to change the email of your account from %s to this address.\r\n\r\n"+
+		"To confirm, open this link:\r\n"+
+		"%s\r\n\r\n"+
+		"It expires at %s. If you did not ask for this, ignore this email; nothing changes.\r\n",
+		p.OldEmail, p.ConfirmURL, p.ExpiresAt.Format("2006-01-02 15:04 MST"))
+
+	if err := send([]string{p.Email}, "Confirm your new email address", msg); err != nil {
+		log.Printf("[mailer] failed to send email change confirmation to user %d: %v", p.UserID, err)
+		return err
+	}
+	log.Printf("[mailer] email change confirmation sent to user %d", p.UserID)
+	return nil
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"log"
-	"os"
+	"time"
 
 	"context"
 
@@ -51,10 +51,15 @@ func main() {
 	b := helper.ConnectRabbit()
 	defer b.Close()
 
+	// events are sent with a service token of order-service and only accepted
+	// from the service that publishes them
+	helper.SignEvents(b, "order-service")
+	helper.VerifyEvents(b)
+
 	// tell logger which service this is
 	logger.SetService("order-service")
 
-	if err := db.AutoMigrate(&model.Order{}, &model.OrderItem{}); err != nil {
+	if err := db.AutoMigrate(&model.Order{}, &model.OrderItem{}, &model.OrderFulfilment{}, &model.OrderDiscount{}); err != nil {
 		log.Fatalf("Migration failed: %v", err)
 	}
 	s := repo.NewOrderRepo(db)
@@ -77,12 +82,12 @@ func main() {
 	}
 	log.Println("[order-service] payment consumer started")
 
-	// when failing
+	// stock reservation results (failure + fulfilment location)
 	stockQueue := "stock_failed_queue"
 	if err := b.DeclareQueue(stockQueue); err != nil {
 		log.Fatalf("failed to declare stock queue: %v", err)
 	}
-	if err := b.BindQueue(stockQueue, event.ExchangeOrder, []string{event.RoutingKeyInventoryReservationFailed}); err != nil {
+	if err := b.BindQueue(stockQueue, event.ExchangeOrder, []string{event.RoutingKeyInventoryReservationFailed, event.RoutingKeyInventoryReserved}); err != nil {
 		log.Fatalf("failed to bind stock queue: %v", err)
 	}
 
@@ -93,11 +98,16 @@ func main() {
 	}
 	log.Println("[order-service] stock consumer started")
 
-	jwtSecret := []byte(os.Getenv("JWT_SECRET"))
+	verifier := helper.TokenVerifier() // access tokens are checked against auth-service's JWKS
+	verifier.AcceptServiceTokens("order-service")
+	helper.UseTokenDenylist() // reject access tokens revoked by auth-service
+
+	// checkout reads and redeems the user's cart as order-service
+	cart := helper.ServiceClient("order-service", "cart-service", 10*time.Second)
 	r := gin.Default()
 	r.Use(otelgin.Middleware("order-service"))
 	r.Use(middleware.CORSMiddleware())
-	router.RegisterRoutes(r, s, b, jwtSecret)
+	router.RegisterRoutes(r, s, b, cart, verifier)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	r.Run(":8083")
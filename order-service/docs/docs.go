@@ -75,6 +75,16 @@ const docTemplate = `{
                     "Orders"
                 ],
                 "summary": "Create a new order from the current cart",
+                "parameters": [
+                    {
+                        "description": "Shipping details (shipping_address, shipping_region)",
+                        "name": "payload",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/model.Order"
+                        }
+                    }
+                ],
                 "responses": {
                     "201": {
                         "description": "Created",
@@ -100,6 +110,75 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/search": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Orders"
+                ],
+                "summary": "Search for an order by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Order"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -232,6 +311,15 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -270,6 +358,26 @@ const docTemplate = `{
         "model.Order": {
             "type": "object",
             "properties": {
+                "coupon_code": {
+                    "type": "string",
+                    "example": "SPRING10"
+                },
+                "discount": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "discounts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.OrderDiscount"
+                    }
+                },
+                "fulfilments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.OrderFulfilment"
+                    }
+                },
                 "items": {
                     "type": "array",
                     "items": {
@@ -277,40 +385,109 @@ const docTemplate = `{
                     }
                 },
                 "shipping_address": {
-                    "type": "string"
+                    "type": "string",
+                    "example": "123 Main St"
+                },
+                "shipping_region": {
+                    "description": "used to pick the nearest warehouse",
+                    "type": "string",
+                    "example": "south"
                 },
                 "status": {
-                    "type": "string"
+                    "type": "string",
+                    "example": "Pending"
+                },
+                "subtotal": {
+                    "type": "integer",
+                    "example": 50000
+                },
+                "tax": {
+                    "type": "integer",
+                    "example": 0
                 },
                 "total": {
-                    "type": "integer"
+                    "description": "subtotal - discount + tax",
+                    "type": "integer",
+                    "example": 50000
                 },
                 "user_id": {
-                    "type": "integer"
+                    "type": "integer",
+                    "example": 1
                 },
                 "uuid": {
                     "type": "string"
                 }
             }
         },
+        "model.OrderDiscount": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "example": 5000
+                },
+                "code": {
+                    "type": "string",
+                    "example": "SPRING10"
+                },
+                "order_id": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "product_id": {
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "model.OrderFulfilment": {
+            "type": "object",
+            "properties": {
+                "order_id": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "product_id": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "quantity": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "warehouse_code": {
+                    "type": "string",
+                    "example": "hcm-1"
+                },
+                "warehouse_id": {
+                    "type": "integer",
+                    "example": 2
+                }
+            }
+        },
         "model.OrderItem": {
             "type": "object",
             "properties": {
                 "order_id": {
                     "description": "foreign key",
-                    "type": "integer"
+                    "type": "integer",
+                    "example": 100
                 },
                 "price": {
-                    "type": "integer"
+                    "type": "integer",
+                    "example": 25000
                 },
                 "product_id": {
-                    "type": "integer"
+                    "type": "integer",
+                    "example": 5
                 },
                 "quantity": {
-                    "type": "integer"
+                    "type": "integer",
+                    "example": 2
                 },
                 "subtotal": {
-                    "type": "integer"
+                    "type": "integer",
+                    "example": 50000
                 }
             }
         }
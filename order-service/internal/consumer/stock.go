@@ -3,13 +3,16 @@ package consumer
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 
 	"github.com/phanthehoang2503/small-project/internal/broker"
 	"github.com/phanthehoang2503/small-project/internal/event"
 	"github.com/phanthehoang2503/small-project/internal/message"
+	"github.com/phanthehoang2503/small-project/order-service/internal/model"
 	"github.com/phanthehoang2503/small-project/order-service/internal/repo"
 	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
 )
 
 type StockConsumer struct {
@@ -33,6 +36,10 @@ func (c *StockConsumer) handle(ctx context.Context, routingKey string, body []by
 	ctx, span := tr.Start(ctx, "consumer.CompensateStockFailure")
 	defer span.End()
 
+	if routingKey == event.RoutingKeyInventoryReserved {
+		return c.handleReserved(body)
+	}
+
 	if routingKey != event.RoutingKeyInventoryReservationFailed {
 		return nil
 	}
@@ -54,3 +61,34 @@ func (c *StockConsumer) handle(ctx context.Context, routingKey string, body []by
 	log.Printf("[order-stock-consumer] order %s cancelled", payload.OrderUUID)
 	return nil
 }
+
+// handleReserved records which warehouses the order ships from.
+func (c *StockConsumer) handleReserved(body []byte) error {
+	var payload message.InventoryReserved
+	if err := json.Unmarshal(body, &payload); err != nil {
+		log.Printf("[order-stock-consumer] failed to unmarshal inventory.reserved: %v", err)
+		return nil // ack
+	}
+
+	var fulfilments []model.OrderFulfilment
+	for _, a := range payload.Allocations {
+		fulfilments = append(fulfilments, model.OrderFulfilment{
+			ProductID:     a.ProductID,
+			WarehouseID:   a.WarehouseID,
+			WarehouseCode: a.WarehouseCode,
+			Quantity:      a.Quantity,
+		})
+	}
+
+	if err := c.repo.RecordFulfilment(payload.OrderUUID, fulfilments); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("[order-stock-consumer] order not found (uuid=%s)", payload.OrderUUID)
+			return nil
+		}
+		log.Printf("[order-stock-consumer] failed to record fulfilment for order %s: %v", payload.OrderUUID, err)
+		return err
+	}
+
+	log.Printf("[order-stock-consumer] order %s fulfilled from %d location(s)", payload.OrderUUID, len(fulfilments))
+	return nil
+}
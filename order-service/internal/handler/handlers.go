@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -14,6 +17,7 @@ import (
 	"github.com/phanthehoang2503/small-project/internal/broker"
 	"github.com/phanthehoang2503/small-project/internal/logger"
 	"github.com/phanthehoang2503/small-project/internal/message"
+	"github.com/phanthehoang2503/small-project/internal/serviceauth"
 	"github.com/phanthehoang2503/small-project/internal/util"
 	"github.com/phanthehoang2503/small-project/order-service/internal/model"
 	"github.com/phanthehoang2503/small-project/order-service/internal/publisher"
@@ -28,18 +32,80 @@ type UpdateStatusReq struct {
 	Status string `json:"status" binding:"required" example:"Paid"`
 }
 
+// cartSummary is the part of cart-service's GET /cart/summary used at checkout
+type cartSummary struct {
+	Lines []struct {
+		ProductID   uint     `json:"product_id"`
+		Quantity    int      `json:"quantity"`
+		Price       int64    `json:"price"`
+		Subtotal    int64    `json:"subtotal"`
+		MaxQuantity int      `json:"max_quantity"`
+		Issues      []string `json:"issues,omitempty"` // e.g. insufficient_stock, max_per_order_exceeded
+	} `json:"lines"`
+	Subtotal    int64  `json:"subtotal"`
+	Discount    int64  `json:"discount"`
+	Tax         int64  `json:"tax"`
+	Total       int64  `json:"total"`
+	Valid       bool   `json:"valid"`
+	Coupon      string `json:"coupon"`
+	CouponError string `json:"coupon_error"`
+	Discounts   []struct {
+		Code      string `json:"code"`
+		ProductID uint   `json:"product_id"`
+		Amount    int64  `json:"amount"`
+	} `json:"discounts"`
+}
+
+// cartRequest calls cart-service on behalf of the user with the service
+// client, propagating the trace
+func cartRequest(ctx context.Context, cart *http.Client, userID uint, method, url string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	serviceauth.OnBehalfOf(req, userID)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return cart.Do(req)
+}
+
+// releaseCoupon gives back the coupon use of an order that was not placed
+func releaseCoupon(ctx context.Context, cart *http.Client, userID uint, base, orderUUID string) {
+	resp, err := cartRequest(ctx, cart, userID, http.MethodDelete, base+"/coupon/redemptions/"+orderUUID, nil)
+	if err != nil {
+		log.Printf("failed to release coupon of order %s: %v", orderUUID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		log.Printf("failed to release coupon of order %s: status %d", orderUUID, resp.StatusCode)
+	}
+}
+
 // CreateOrder godoc
 // @Summary Create a new order from the current cart
 // @Tags Orders
 // @Security BearerAuth
 // @Accept json
 // @Produce json
+// @Param payload body model.Order false "Shipping details (shipping_address, shipping_region)"
 // @Success 201 {object} model.Order
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /orders [post]
-func CreateOrder(r *repo.OrderRepo, b *broker.Broker) gin.HandlerFunc {
+func CreateOrder(r *repo.OrderRepo, b *broker.Broker, cart *http.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tr := otel.Tracer("order-service")
 		ctx, span := tr.Start(c.Request.Context(), "create_order_handler")
@@ -66,38 +132,21 @@ func CreateOrder(r *repo.OrderRepo, b *broker.Broker) gin.HandlerFunc {
 
 		// 1. Check Cart Span
 		ctxCart, spanCart := tr.Start(ctx, "check_cart")
-		cartURL := base
-		req, err := http.NewRequestWithContext(ctxCart, "GET", cartURL, nil)
-		if err != nil {
-			spanCart.RecordError(err)
-			spanCart.End()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create request"})
-			return
-		}
-		otel.GetTextMapPropagator().Inject(ctxCart, propagation.HeaderCarrier(req.Header))
-		req.Header.Set("Authorization", c.GetHeader("Authorization"))
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		resp, err := cartRequest(ctxCart, cart, userID, http.MethodGet, base+"/summary", nil)
 		if err != nil || resp.StatusCode != 200 {
 			if err != nil {
 				spanCart.RecordError(err)
 			} else {
 				spanCart.RecordError(fmt.Errorf("cart status %d", resp.StatusCode))
+				resp.Body.Close()
 			}
 			spanCart.End()
 			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to fetch cart"})
 			return
 		}
 
-		var cartItems []struct {
-			ProductID uint  `json:"product_id"`
-			Quantity  int   `json:"quantity"`
-			Price     int64 `json:"price"`
-			Subtotal  int64 `json:"subtotal"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&cartItems); err != nil {
+		var summary cartSummary
+		if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
 			spanCart.RecordError(err)
 			spanCart.End()
 			resp.Body.Close()
@@ -108,39 +157,108 @@ func CreateOrder(r *repo.OrderRepo, b *broker.Broker) gin.HandlerFunc {
 		resp.Body.Close()
 		spanCart.End()
 
-		if len(cartItems) == 0 {
+		if len(summary.Lines) == 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "cart empty"})
 			return
 		}
 
+		// the summary revalidated every line against current product data
+		if !summary.Valid {
+			if summary.CouponError != "" {
+				c.JSON(http.StatusConflict, gin.H{"error": summary.CouponError, "code": "coupon_invalid", "coupon": summary.Coupon})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "cart has items that cannot be ordered", "code": "cart_invalid", "lines": summary.Lines})
+			return
+		}
+
 		order := &model.Order{
-			UserID: userID,
-			UUID:   uuid.New().String(),
-			Status: "Pending",
+			UserID:          userID,
+			UUID:            uuid.New().String(),
+			Status:          "Pending",
+			ShippingAddress: in.ShippingAddress,
+			ShippingRegion:  in.ShippingRegion,
 		}
 
-		var total int64
-		for _, item := range cartItems {
+		// the coupon is checked again and its use recorded against the order;
+		// the redeemed summary is the one the order is built from
+		if summary.Coupon != "" {
+			ctxCoupon, spanCoupon := tr.Start(ctx, "redeem_coupon")
+			resp, err := cartRequest(ctxCoupon, cart, userID, http.MethodPost, base+"/coupon/redemptions", gin.H{"order_uuid": order.UUID})
+			if err != nil {
+				spanCoupon.RecordError(err)
+				spanCoupon.End()
+				c.JSON(http.StatusBadGateway, gin.H{"error": "failed to redeem coupon"})
+				return
+			}
+			if resp.StatusCode != http.StatusOK {
+				var out struct {
+					Error string `json:"error"`
+				}
+				_ = json.NewDecoder(resp.Body).Decode(&out)
+				resp.Body.Close()
+				spanCoupon.RecordError(fmt.Errorf("coupon redemption status %d", resp.StatusCode))
+				spanCoupon.End()
+				if resp.StatusCode == http.StatusConflict {
+					c.JSON(http.StatusConflict, gin.H{"error": out.Error, "code": "coupon_invalid", "coupon": summary.Coupon})
+					return
+				}
+				c.JSON(http.StatusBadGateway, gin.H{"error": "failed to redeem coupon"})
+				return
+			}
+			summary = cartSummary{}
+			err = json.NewDecoder(resp.Body).Decode(&summary)
+			resp.Body.Close()
+			spanCoupon.End()
+			if err != nil {
+				releaseCoupon(ctx, cart, userID, base, order.UUID)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid response from cart service"})
+				return
+			}
+			if !summary.Valid || len(summary.Lines) == 0 {
+				// the cart changed in between
+				releaseCoupon(ctx, cart, userID, base, order.UUID)
+				c.JSON(http.StatusConflict, gin.H{"error": "cart changed during checkout, please retry", "code": "cart_invalid", "lines": summary.Lines})
+				return
+			}
+			order.CouponCode = summary.Coupon
+			for _, d := range summary.Discounts {
+				order.Discounts = append(order.Discounts, model.OrderDiscount{
+					Code:      d.Code,
+					ProductID: d.ProductID,
+					Amount:    d.Amount,
+				})
+			}
+		}
+
+		for _, item := range summary.Lines {
 			if item.Quantity <= 0 {
+				if order.CouponCode != "" {
+					releaseCoupon(ctx, cart, userID, base, order.UUID)
+				}
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item quantity"})
 				return
 			}
-			oi := model.OrderItem{
+			order.Items = append(order.Items, model.OrderItem{
 				ProductID: item.ProductID,
 				Quantity:  item.Quantity,
 				Price:     item.Price,
-			}
-			oi.Subtotal = int64(oi.Quantity) * oi.Price
-			order.Items = append(order.Items, oi)
-			total += oi.Subtotal
+				Subtotal:  item.Subtotal,
+			})
 		}
-		order.Total = total
+		order.Subtotal = summary.Subtotal
+		order.Discount = summary.Discount
+		order.Tax = summary.Tax
+		order.Total = summary.Total
 
 		// 2. DB Create Span
 		_, spanDB := tr.Start(ctx, "db_create")
 		if err := r.CreateOrder(userID, order); err != nil {
 			spanDB.RecordError(err)
 			spanDB.End()
+			if order.CouponCode != "" {
+				releaseCoupon(ctx, cart, userID, base, order.UUID)
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -162,7 +280,7 @@ func CreateOrder(r *repo.OrderRepo, b *broker.Broker) gin.HandlerFunc {
 					Quantity:  i.Quantity,
 				})
 			}
-			if err := publisher.PublishOrderCreated(ctxPub, b, created.UUID, created.UUID, created.UserID, created.Total, "VND", msgItems); err != nil {
+			if err := publisher.PublishOrderCreated(ctxPub, b, created.UUID, created.UUID, created.UserID, created.Total, "VND", created.ShippingRegion, msgItems); err != nil {
 				spanPub.RecordError(err)
 				log.Printf("failed to publish order created event: %v", err)
 			}
@@ -261,6 +379,7 @@ var allowedStatuses = map[string]bool{
 // @Success 200 {object} model.Order
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /orders/{id}/status [put]
@@ -6,12 +6,19 @@ import (
 
 type Order struct {
 	gorm.Model      `swaggerignore:"true"`
-	UUID            string      `json:"uuid" gorm:"size:36;uniqueIndex"`
-	UserID          uint        `json:"user_id" gorm:"index;not null" example:"1"`
-	Total           int64       `json:"total" example:"50000"`
-	Status          string      `json:"status" example:"Pending"`
-	ShippingAddress string      `json:"shipping_address" example:"123 Main St"`
-	Items           []OrderItem `json:"items" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	UUID            string            `json:"uuid" gorm:"size:36;uniqueIndex"`
+	UserID          uint              `json:"user_id" gorm:"index;not null" example:"1"`
+	Subtotal        int64             `json:"subtotal" example:"50000"`
+	Discount        int64             `json:"discount" example:"0"`
+	CouponCode      string            `json:"coupon_code,omitempty" gorm:"size:32" example:"SPRING10"`
+	Tax             int64             `json:"tax" example:"0"`
+	Total           int64             `json:"total" example:"50000"` // subtotal - discount + tax
+	Status          string            `json:"status" example:"Pending"`
+	ShippingAddress string            `json:"shipping_address" example:"123 Main St"`
+	ShippingRegion  string            `json:"shipping_region" gorm:"size:32" example:"south"` // used to pick the nearest warehouse
+	Items           []OrderItem       `json:"items" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Fulfilments     []OrderFulfilment `json:"fulfilments" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Discounts       []OrderDiscount   `json:"discounts" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 }
 
 type OrderItem struct {
@@ -22,3 +29,23 @@ type OrderItem struct {
 	Price      int64 `json:"price" example:"25000"`
 	Subtotal   int64 `json:"subtotal" example:"50000"`
 }
+
+// OrderFulfilment is the warehouse a part of the order ships from,
+// recorded when product-service reserves the stock.
+type OrderFulfilment struct {
+	gorm.Model    `swaggerignore:"true"`
+	OrderID       uint   `json:"order_id" gorm:"index;not null" example:"100"`
+	ProductID     uint   `json:"product_id" example:"5"`
+	WarehouseID   uint   `json:"warehouse_id" example:"2"`
+	WarehouseCode string `json:"warehouse_code" example:"hcm-1"`
+	Quantity      int    `json:"quantity" example:"2"`
+}
+
+// OrderDiscount is the part of the coupon discount given on one product.
+type OrderDiscount struct {
+	gorm.Model `swaggerignore:"true"`
+	OrderID    uint   `json:"order_id" gorm:"index;not null" example:"100"`
+	Code       string `json:"code" gorm:"size:32" example:"SPRING10"`
+	ProductID  uint   `json:"product_id" example:"5"`
+	Amount     int64  `json:"amount" example:"5000"`
+}
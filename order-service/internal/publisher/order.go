@@ -9,14 +9,15 @@ import (
 	"github.com/phanthehoang2503/small-project/internal/message"
 )
 
-func PublishOrderCreated(ctx context.Context, b *broker.Broker, correlationID, orderUUID string, userID uint, total int64, currency string, items []message.OrderItem) error {
+func PublishOrderCreated(ctx context.Context, b *broker.Broker, correlationID, orderUUID string, userID uint, total int64, currency, shippingRegion string, items []message.OrderItem) error {
 	payload := message.OrderRequested{
-		CorrelationID: correlationID,
-		OrderUUID:     orderUUID,
-		UserID:        userID,
-		Total:         total,
-		Currency:      currency,
-		Items:         items,
+		CorrelationID:  correlationID,
+		OrderUUID:      orderUUID,
+		UserID:         userID,
+		Total:          total,
+		Currency:       currency,
+		ShippingRegion: shippingRegion,
+		Items:          items,
 	}
 
 	if err := b.PublishJSON(ctx, event.ExchangeOrder, event.RoutingKeyOrderCreated, payload); err != nil {
@@ -25,19 +25,23 @@ func (r *OrderRepo) CreateOrder(userId uint, order *model.Order) error {
 	order.UserID = userId
 
 	//compute server-side subtotals and total
-	var total int64
+	var subtotal int64
 	for i := range order.Items {
 		item := &order.Items[i]
 		if item.Quantity <= 0 {
 			return fmt.Errorf("invalid item quantity for product %d", item.ProductID)
 		}
 		item.Subtotal = int64(item.Quantity) * item.Price
-		total += item.Subtotal
+		subtotal += item.Subtotal
 	}
-	order.Total = total
+	if order.Discount > subtotal {
+		return errors.New("discount exceeds order subtotal")
+	}
+	order.Subtotal = subtotal
+	order.Total = subtotal - order.Discount + order.Tax
 
 	return r.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Omit("Items").Create(order).Error; err != nil {
+		if err := tx.Omit("Items", "Fulfilments", "Discounts").Create(order).Error; err != nil {
 			return err
 		}
 		for i := range order.Items {
@@ -46,6 +50,12 @@ func (r *OrderRepo) CreateOrder(userId uint, order *model.Order) error {
 				return err
 			}
 		}
+		for i := range order.Discounts {
+			order.Discounts[i].OrderID = order.ID
+			if err := tx.Create(&order.Discounts[i]).Error; err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 }
@@ -57,7 +67,7 @@ func (r *OrderRepo) ListByUser(userID uint) ([]model.Order, error) {
 	FROM "orders"
 	WHERE "orders"."user_id" = 1 AND "orders"."deleted_at" = NULL
 	*/
-	if err := r.db.Preload("Items").Where("user_id = ?", userID).Find(&order).Error; err != nil {
+	if err := r.db.Preload("Items").Preload("Fulfilments").Preload("Discounts").Where("user_id = ?", userID).Find(&order).Error; err != nil {
 		return nil, err
 	} /* get all users, and preload all non-cancelled orders
 	db.Preload("Orders", "state NOT IN (?)", "cancelled").Find(&users)
@@ -67,7 +77,7 @@ func (r *OrderRepo) ListByUser(userID uint) ([]model.Order, error) {
 
 func (r *OrderRepo) GetByID(userId, orderId uint) (*model.Order, error) {
 	var order model.Order
-	if err := r.db.Preload("Items").
+	if err := r.db.Preload("Items").Preload("Fulfilments").Preload("Discounts").
 		Where("id = ? AND user_id = ?", orderId, userId).
 		First(&order).Error; err != nil {
 		return nil, err
@@ -86,7 +96,7 @@ func (r *OrderRepo) UpdateStatus(userId, orderId uint, status string) (*model.Or
 		return nil, err
 	}
 
-	if err := r.db.Preload("Items").First(&order, orderId).Error; err != nil {
+	if err := r.db.Preload("Items").Preload("Fulfilments").Preload("Discounts").First(&order, orderId).Error; err != nil {
 		return nil, err
 	}
 	return &order, nil
@@ -139,6 +149,28 @@ func (r *OrderRepo) UpdateStatusIfNot(orderUUID, status string, forbiddenStates
 	return &ord, nil
 }
 
+// RecordFulfilment replaces the fulfilment locations of an order.
+// Returns gorm.ErrRecordNotFound if no order matches the UUID.
+func (r *OrderRepo) RecordFulfilment(orderUUID string, fulfilments []model.OrderFulfilment) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var ord model.Order
+		if err := tx.Where("uuid = ?", orderUUID).First(&ord).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("order_id = ?", ord.ID).Delete(&model.OrderFulfilment{}).Error; err != nil {
+			return err
+		}
+		for i := range fulfilments {
+			fulfilments[i].OrderID = ord.ID
+			if err := tx.Create(&fulfilments[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (r *OrderRepo) CompensateOrder(uuid string, reason string) (*model.Order, error) {
 	ord, err := r.UpdateStatusByUUID(uuid, "Cancelled")
 	if err != nil {
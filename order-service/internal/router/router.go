@@ -1,6 +1,8 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 	"github.com/phanthehoang2503/small-project/internal/broker"
 	"github.com/phanthehoang2503/small-project/internal/middleware"
@@ -9,15 +11,16 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func RegisterRoutes(r *gin.Engine, s *repo.OrderRepo, b *broker.Broker, jwtSecret []byte) {
+func RegisterRoutes(r *gin.Engine, s *repo.OrderRepo, b *broker.Broker, cart *http.Client, verifier *middleware.Verifier) {
 	r.Use(otelgin.Middleware("order-service"))
 	api := r.Group("/orders")
-	api.Use(middleware.JWTMiddleware(jwtSecret))
+	api.Use(middleware.JWTMiddleware(verifier))
 	{
-		api.POST("", handler.CreateOrder(s, b)) // List all orders for a specific user (?user_id=1)
-		api.GET("", handler.ListOrders(s))
-		api.GET("/search", handler.SearchOrders(s)) // Search order by ID (?id=1)
-		api.GET("/:id", handler.GetOrder(s))
-		api.PUT("/:id/status", handler.UpdateOrderStatus(s)) // Update order status (/orders/:id/status)
+		api.POST("", middleware.RequireUserToken(), handler.CreateOrder(s, b, cart)) // List all orders for a specific user (?user_id=1)
+		// product-service checks a reviewer's orders for them
+		api.GET("", middleware.RequireUserOrService("product-service"), handler.ListOrders(s))
+		api.GET("/search", middleware.RequireUserToken(), handler.SearchOrders(s)) // Search order by ID (?id=1)
+		api.GET("/:id", middleware.RequireUserToken(), handler.GetOrder(s))
+		api.PUT("/:id/status", middleware.RequirePermission(middleware.PermOrdersManage), handler.UpdateOrderStatus(s)) // Update order status (/orders/:id/status)
 	}
 }
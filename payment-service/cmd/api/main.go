@@ -54,6 +54,11 @@ func main() {
 	b := helper.ConnectRabbit()
 	defer b.Close()
 
+	// events are sent with a service token of payment-service and only accepted
+	// from the service that publishes them
+	helper.SignEvents(b, "payment-service")
+	helper.VerifyEvents(b)
+
 	// tell logger which service this is
 	logger.SetService("payment-service")
 
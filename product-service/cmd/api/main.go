@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"log"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -13,10 +15,12 @@ import (
 	"github.com/phanthehoang2503/small-project/internal/middleware"
 	"github.com/phanthehoang2503/small-project/internal/telemetry"
 	_ "github.com/phanthehoang2503/small-project/product-service/docs"
+	"github.com/phanthehoang2503/small-project/product-service/internal/client"
 	"github.com/phanthehoang2503/small-project/product-service/internal/consumer"
 	"github.com/phanthehoang2503/small-project/product-service/internal/model"
 	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
 	"github.com/phanthehoang2503/small-project/product-service/internal/router"
+	"github.com/phanthehoang2503/small-project/product-service/internal/scheduler"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
@@ -27,6 +31,9 @@ import (
 // @description Manage product items
 // @host localhost:8081
 // @BasePath /
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 func main() {
 	godotenv.Load()
 	// Init Tracer
@@ -43,15 +50,27 @@ func main() {
 		log.Fatal("failed to connect to database...")
 	}
 
-	if err := db.AutoMigrate(&model.Product{}); err != nil {
+	if err := db.AutoMigrate(&model.Product{}, &model.ProductPrice{}, &model.StockSubscription{}, &model.Review{},
+		&model.Warehouse{}, &model.WarehouseStock{}, &model.StockReservation{}); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	if err := repo.ClosePriceRanges(db); err != nil {
 		log.Fatalf("Migration failed: %v", err)
 	}
 	productRepo := repo.NewRepo(db)
+	if err := productRepo.EnsureDefaultWarehouse(); err != nil {
+		log.Fatalf("failed to set up default warehouse: %v", err)
+	}
 
 	// RabbitMQ + lging
 	b := helper.ConnectRabbit()
 	defer b.Close()
 
+	// events are sent with a service token of product-service and only accepted
+	// from the service that publishes them
+	helper.SignEvents(b, "product-service")
+	helper.VerifyEvents(b)
+
 	logger.SetService("product-service")
 
 	// Redis Cache
@@ -78,10 +97,22 @@ func main() {
 		log.Printf("Failed to start consumer: %v", err)
 	}
 
+	// Scheduled price activation
+	priceInterval, err := time.ParseDuration(os.Getenv("PRICE_SCHEDULER_INTERVAL"))
+	if err != nil {
+		priceInterval = time.Minute // default
+	}
+	scheduler.NewPriceScheduler(productRepo, cacheRepo, priceInterval).Start(context.Background())
+
 	r := gin.Default()
 	r.Use(otelgin.Middleware("product-service"))
 	r.Use(middleware.CORSMiddleware())
-	router.RegisterRoutes(r, productRepo, cacheRepo)
+	verifier := helper.TokenVerifier() // access tokens are checked against auth-service's JWKS
+	verifier.AcceptServiceTokens("product-service")
+	helper.UseTokenDenylist() // reject access tokens revoked by auth-service
+	// purchase checks call order-service as product-service for the reviewer
+	client.UseOrderClient(helper.ServiceClient("product-service", "order-service", 3*time.Second))
+	router.RegisterRoutes(r, productRepo, cacheRepo, verifier)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	r.Run(":8081")
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/internal/serviceauth"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// orderClient calls order-service; UseOrderClient replaces it
+var orderClient = &http.Client{Timeout: 3 * time.Second}
+
+// UseOrderClient makes order lookups go through client, e.g. one sending
+// service tokens.
+func UseOrderClient(client *http.Client) {
+	orderClient = client
+}
+
+type order struct {
+	Status string `json:"status"`
+	Items  []struct {
+		ProductID uint `json:"product_id"`
+	} `json:"items"`
+}
+
+// HasDeliveredOrder asks order-service, on behalf of the user, whether they
+// have a Delivered order containing the product.
+func HasDeliveredOrder(ctx context.Context, userID, productID uint) (bool, error) {
+	base := os.Getenv("ORDER_SERVICE_URL")
+	if base == "" {
+		return false, errors.New("order service url not configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", base, nil)
+	if err != nil {
+		return false, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	serviceauth.OnBehalfOf(req, userID)
+
+	resp, err := orderClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("order service returned status %d", resp.StatusCode)
+	}
+
+	var orders []order
+	if err := json.NewDecoder(resp.Body).Decode(&orders); err != nil {
+		return false, err
+	}
+
+	for _, o := range orders {
+		if o.Status != "Delivered" {
+			continue
+		}
+		for _, it := range o.Items {
+			if it.ProductID == productID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/phanthehoang2503/small-project/internal/broker"
 	"github.com/phanthehoang2503/small-project/internal/event"
 	"github.com/phanthehoang2503/small-project/internal/message"
+	"github.com/phanthehoang2503/small-project/product-service/internal/publisher"
 	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
@@ -54,7 +55,8 @@ func (c *OrderConsumer) handle(ctx context.Context, routingKey string, body []by
 		}
 
 		// Try to deduct
-		if err := c.repo.BatchDeductStock(stockItems); err != nil {
+		changes, allocs, err := c.repo.BatchDeductStock(payload.OrderUUID, payload.ShippingRegion, stockItems)
+		if err != nil {
 			log.Printf("[product-consumer] failed to deduct stock: %v", err)
 
 			span.RecordError(err)
@@ -77,6 +79,14 @@ func (c *OrderConsumer) handle(ctx context.Context, routingKey string, body []by
 			Total:         payload.Total,
 			Currency:      payload.Currency,
 		}
+		for _, a := range allocs {
+			successEvent.Allocations = append(successEvent.Allocations, message.StockAllocation{
+				ProductID:     a.ProductID,
+				WarehouseID:   a.WarehouseID,
+				WarehouseCode: a.WarehouseCode,
+				Quantity:      a.Quantity,
+			})
+		}
 		if err := c.b.PublishJSON(ctx, event.ExchangeOrder, event.RoutingKeyInventoryReserved, successEvent); err != nil {
 			log.Printf("[product-consumer] failed to publish inventory.reserved: %v", err)
 			// TODO: If publish fails, we should rollback stock immediately?
@@ -89,6 +99,7 @@ func (c *OrderConsumer) handle(ctx context.Context, routingKey string, body []by
 				c.cache.InvalidateProduct(ctx, item.ProductID)
 			}
 		}
+		publisher.PublishStockAlerts(ctx, c.repo, changes)
 		log.Printf("[product-consumer] stock reserved & event published for order %s", payload.OrderUUID)
 		return nil
 	}
@@ -102,20 +113,14 @@ func (c *OrderConsumer) handle(ctx context.Context, routingKey string, body []by
 		}
 		log.Printf("[product-consumer] received order.cancelled order=%s reason=%s items=%d", payload.OrderUUID, payload.Reason, len(payload.Items))
 
-		var stockItems []repo.StockItem
-		for _, item := range payload.Items {
-			stockItems = append(stockItems, repo.StockItem{
-				ProductID: item.ProductID,
-				Quantity:  item.Quantity,
-			})
-		}
-
-		// Restock
-		if err := c.repo.BatchRestock(stockItems); err != nil {
+		// Restock what was reserved for this order
+		changes, err := c.repo.BatchRestock(payload.OrderUUID)
+		if err != nil {
 			log.Printf("[product-consumer] failed to restock: %v", err)
 			span.RecordError(err)
 			return err
 		}
+		publisher.PublishStockAlerts(ctx, c.repo, changes)
 
 		// Invalidate Cache
 		for _, item := range payload.Items {
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
+)
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 500
+)
+
+// ChangesPage is one page of the product change feed. Pass next_since and
+// next_after_id back to get the following page; the feed is done when
+// items is shorter than the limit.
+type ChangesPage struct {
+	Items       []repo.ProductChange `json:"items"`
+	NextSince   time.Time            `json:"next_since"`
+	NextAfterID uint                 `json:"next_after_id" example:"42"`
+}
+
+// ListProductChanges godoc
+// @Summary List products modified since a cursor
+// @Description Change feed for services keeping a copy of the catalog (including deleted products), ordered by modification time then ID.
+// @Tags Products
+// @Produce json
+// @Param since query string false "RFC3339 timestamp, exclusive together with after_id"
+// @Param after_id query int false "Last product ID seen at `since`"
+// @Param limit query int false "Page size (max 500)" default(100)
+// @Success 200 {object} ChangesPage
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /products/changes [get]
+func ListProductChanges(r *repo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var since time.Time
+		if s := c.Query("since"); s != "" {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since, expected RFC3339"})
+				return
+			}
+			since = t
+		}
+		afterID, err := strconv.ParseUint(c.DefaultQuery("after_id", "0"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid after_id"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultChangesLimit)))
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = min(limit, maxChangesLimit)
+
+		items, err := r.ListChanged(since, uint(afterID), limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		page := ChangesPage{Items: items, NextSince: since, NextAfterID: uint(afterID)}
+		if n := len(items); n > 0 {
+			page.NextSince = items[n-1].ModifiedAt
+			page.NextAfterID = items[n-1].ID
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
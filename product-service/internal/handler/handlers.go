@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -10,7 +11,9 @@ import (
 	"github.com/phanthehoang2503/small-project/internal/logger"
 	"github.com/phanthehoang2503/small-project/internal/message"
 	"github.com/phanthehoang2503/small-project/product-service/internal/model"
+	"github.com/phanthehoang2503/small-project/product-service/internal/publisher"
 	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
+	"gorm.io/gorm"
 )
 
 // ListProducts godoc
@@ -82,9 +85,12 @@ func GetProducts(r *repo.Database, cache *repo.CacheRepository) gin.HandlerFunc
 // @Tags Products
 // @Accept json
 // @Produce json
+// @Security BearerAuth
 // @Param payload body model.Product true "Product payload"
 // @Success 201 {object} model.Product
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /products [post]
 func CreateProducts(r *repo.Database) gin.HandlerFunc {
@@ -109,10 +115,13 @@ func CreateProducts(r *repo.Database) gin.HandlerFunc {
 
 			// publish product.created for each
 			msg := message.ProductMessage{
-				ID:    newProd.ID,
-				Name:  newProd.Name,
-				Price: newProd.Price,
-				Stock: newProd.Stock,
+				ID:             newProd.ID,
+				Name:           newProd.Name,
+				Price:          newProd.Price,
+				Stock:          newProd.Stock,
+				Category:       newProd.Category,
+				MaxPerOrder:    newProd.MaxPerOrder,
+				MaxPerCustomer: newProd.MaxPerCustomer,
 			}
 			if err := broker.PublishJSON(c.Request.Context(), event.ExchangeProduct, event.RoutingKeyProductCreated, msg); err != nil {
 				logger.Error(c.Request.Context(), "failed to publish product.created: "+err.Error())
@@ -129,10 +138,13 @@ func CreateProducts(r *repo.Database) gin.HandlerFunc {
 // @Tags Products
 // @Accept json
 // @Produce json
+// @Security BearerAuth
 // @Param id path int true "Product ID"
 // @Param payload body model.Product true "Updated product data"
 // @Success 200 {object} model.Product
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /products/{id} [put]
@@ -150,8 +162,12 @@ func UpdateProducts(r *repo.Database, cache *repo.CacheRepository) gin.HandlerFu
 			return
 		}
 
-		updated, err := r.Update(id, in) //product's struct, boolean
+		updated, change, err := r.Update(id, in) //product's struct, stock change
 		if err != nil {
+			if errors.Is(err, repo.ErrDefaultStockTooLow) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}) // not found = 404
 			return
 		}
@@ -162,14 +178,18 @@ func UpdateProducts(r *repo.Database, cache *repo.CacheRepository) gin.HandlerFu
 		}
 
 		msg := message.ProductMessage{
-			ID:    updated.ID,
-			Name:  updated.Name,
-			Price: updated.Price,
-			Stock: updated.Stock,
+			ID:             updated.ID,
+			Name:           updated.Name,
+			Price:          updated.Price,
+			Stock:          updated.Stock,
+			Category:       updated.Category,
+			MaxPerOrder:    updated.MaxPerOrder,
+			MaxPerCustomer: updated.MaxPerCustomer,
 		}
 		if err := broker.PublishJSON(c.Request.Context(), event.ExchangeProduct, event.RoutingKeyProductUpdated, msg); err != nil {
 			logger.Error(c.Request.Context(), "failed to publish product.updated: "+err.Error())
 		}
+		publisher.PublishStockAlerts(c.Request.Context(), r, []repo.StockChange{change})
 
 		c.JSON(200, updated)
 	}
@@ -179,9 +199,12 @@ func UpdateProducts(r *repo.Database, cache *repo.CacheRepository) gin.HandlerFu
 // @Summary Delete a product
 // @Description Remove a product by ID
 // @Tags Products
+// @Security BearerAuth
 // @Param id path int true "Product ID"
 // @Success 204
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Router /products/{id} [delete]
 func DeleteProducts(r *repo.Database, cache *repo.CacheRepository) gin.HandlerFunc {
@@ -213,3 +236,71 @@ func DeleteProducts(r *repo.Database, cache *repo.CacheRepository) gin.HandlerFu
 		c.Status(http.StatusNoContent) // status no content = 204
 	}
 }
+
+// ListDeletedProducts godoc
+// @Summary List deleted products
+// @Description Returns soft-deleted products that can be restored
+// @Tags Products
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} model.Product
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /products/deleted [get]
+func ListDeletedProducts(r *repo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		products, err := r.ListDeleted()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, products)
+	}
+}
+
+// RestoreProducts godoc
+// @Summary Restore a deleted product
+// @Description Undo a soft delete and publish product.restored
+// @Tags Products
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Product ID"
+// @Success 200 {object} model.Product
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /products/{id}/restore [post]
+func RestoreProducts(r *repo.Database, cache *repo.CacheRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqStr := c.Param("id")
+		id, err := strconv.ParseInt(reqStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		restored, err := r.Restore(id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "deleted product not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Invalidate Cache
+		if cache != nil {
+			_ = cache.InvalidateProduct(c.Request.Context(), uint(id))
+		}
+
+		if err := publisher.PublishProductRestored(c.Request.Context(), &restored); err != nil {
+			logger.Error(c.Request.Context(), "failed to publish product.restored: "+err.Error())
+		}
+
+		c.JSON(http.StatusOK, restored)
+	}
+}
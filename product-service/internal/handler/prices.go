@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
+	"gorm.io/gorm"
+)
+
+// SchedulePriceReq is the body for scheduling a price change
+type SchedulePriceReq struct {
+	Price         int64      `json:"price" binding:"required,min=1" example:"799"`
+	EffectiveFrom time.Time  `json:"effective_from" binding:"required" example:"2026-01-01T00:00:00Z"`
+	EffectiveTo   *time.Time `json:"effective_to" example:"2026-01-08T00:00:00Z"`
+}
+
+// SchedulePrice godoc
+// @Summary Schedule a price change
+// @Description Schedule a future price for a product. With effective_to set, the previous price is restored when the window ends (e.g. a sale).
+// @Tags Prices
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Product ID"
+// @Param payload body SchedulePriceReq true "Scheduled price"
+// @Success 201 {object} model.ProductPrice
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /products/{id}/prices [post]
+func SchedulePrice(r *repo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		var in SchedulePriceReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !in.EffectiveFrom.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "effective_from must be in the future"})
+			return
+		}
+
+		scheduled, err := r.SchedulePrice(id, in.Price, in.EffectiveFrom, in.EffectiveTo)
+		if err != nil {
+			if errors.Is(err, repo.ErrInvalidPriceWindow) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, scheduled)
+	}
+}
+
+// ListPriceHistory godoc
+// @Summary Get price history of a product
+// @Description Returns past, current and scheduled prices, newest first
+// @Tags Prices
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {array} model.ProductPrice
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /products/{id}/prices [get]
+func ListPriceHistory(r *repo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		prices, err := r.PriceHistory(id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, prices)
+	}
+}
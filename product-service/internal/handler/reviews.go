@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phanthehoang2503/small-project/internal/util"
+	"github.com/phanthehoang2503/small-project/product-service/internal/client"
+	"github.com/phanthehoang2503/small-project/product-service/internal/model"
+	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
+	"gorm.io/gorm"
+)
+
+// CreateReviewReq is the body for posting a review
+type CreateReviewReq struct {
+	Rating  int    `json:"rating" binding:"required,min=1,max=5" example:"5"`
+	Comment string `json:"comment" binding:"max=2000" example:"Great phone, fast delivery"`
+}
+
+// ModerateReviewReq is the body for approving or rejecting a review
+type ModerateReviewReq struct {
+	Status string `json:"status" binding:"required,oneof=approved rejected pending" example:"approved"`
+}
+
+// ReviewPage is one page of reviews
+type ReviewPage struct {
+	Items    []model.Review `json:"items"`
+	Page     int            `json:"page" example:"1"`
+	PageSize int            `json:"page_size" example:"20"`
+	Total    int64          `json:"total" example:"42"`
+}
+
+// CreateReview godoc
+// @Summary Review a product
+// @Description Post a 1-5 rating for a product. The user must have a Delivered order containing it. Reviews start as pending until moderated.
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param payload body CreateReviewReq true "Review"
+// @Success 201 {object} model.Review
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /products/{id}/reviews [post]
+// @Security BearerAuth
+func CreateReview(r *repo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		userID, err := util.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in CreateReviewReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		delivered, err := client.HasDeliveredOrder(c.Request.Context(), userID, uint(id))
+		if err != nil {
+			log.Printf("[review-handler] failed to check orders: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to verify purchase"})
+			return
+		}
+		if !delivered {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only customers with a delivered order can review this product"})
+			return
+		}
+
+		review, err := r.CreateReview(model.Review{
+			ProductID: uint(id),
+			UserID:    userID,
+			Rating:    in.Rating,
+			Comment:   in.Comment,
+		})
+		if err != nil {
+			if errors.Is(err, repo.ErrAlreadyReviewed) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, review)
+	}
+}
+
+// ListReviews godoc
+// @Summary List reviews of a product
+// @Description Returns approved reviews, newest first
+// @Tags Reviews
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Page size (max 100)" default(20)
+// @Success 200 {object} handler.ReviewPage
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /products/{id}/reviews [get]
+func ListReviews(r *repo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+			return
+		}
+		pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page_size"})
+			return
+		}
+
+		reviews, total, err := r.ListReviews(id, page, pageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, ReviewPage{
+			Items:    reviews,
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+		})
+	}
+}
+
+// ModerateReview godoc
+// @Summary Moderate a review
+// @Description Approve or reject a review. The product's average rating and count are recomputed.
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Product ID"
+// @Param review_id path int true "Review ID"
+// @Param payload body ModerateReviewReq true "New status"
+// @Success 200 {object} model.Review
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /products/{id}/reviews/{review_id}/status [put]
+func ModerateReview(r *repo.Database, cache *repo.CacheRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+		reviewID, err := strconv.ParseUint(c.Param("review_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid review id"})
+			return
+		}
+
+		var in ModerateReviewReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		review, err := r.ModerateReview(id, uint(reviewID), in.Status)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// rating changed
+		if cache != nil {
+			_ = cache.InvalidateProduct(c.Request.Context(), uint(id))
+		}
+
+		c.JSON(http.StatusOK, review)
+	}
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phanthehoang2503/small-project/internal/logger"
+	"github.com/phanthehoang2503/small-project/product-service/internal/model"
+	"github.com/phanthehoang2503/small-project/product-service/internal/publisher"
+	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
+	"gorm.io/gorm"
+)
+
+// SetStockReq is the body for setting stock at a warehouse
+type SetStockReq struct {
+	Stock *int `json:"stock" binding:"required,min=0" example:"40"`
+}
+
+// CreateWarehouse godoc
+// @Summary Create a warehouse
+// @Description Add a stock location. Orders are fulfilled from the shipping region first, then by ascending priority.
+// @Tags Warehouses
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body model.Warehouse true "Warehouse"
+// @Success 201 {object} model.Warehouse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /warehouses [post]
+func CreateWarehouse(r *repo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in model.Warehouse
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if in.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+			return
+		}
+
+		created, err := r.CreateWarehouse(in)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, created)
+	}
+}
+
+// ListWarehouses godoc
+// @Summary List warehouses
+// @Tags Warehouses
+// @Produce json
+// @Success 200 {array} model.Warehouse
+// @Failure 500 {object} map[string]string
+// @Router /warehouses [get]
+func ListWarehouses(r *repo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		whs, err := r.ListWarehouses()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, whs)
+	}
+}
+
+// GetProductStock godoc
+// @Summary Get stock per warehouse
+// @Tags Warehouses
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {array} repo.LocationStock
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /products/{id}/stock [get]
+func GetProductStock(r *repo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		levels, err := r.ProductStock(id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, levels)
+	}
+}
+
+// SetProductStock godoc
+// @Summary Set stock at a warehouse
+// @Description Set a product's stock at one warehouse. The product's total stock is updated and product.updated is published.
+// @Tags Warehouses
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Product ID"
+// @Param warehouse_id path int true "Warehouse ID"
+// @Param payload body SetStockReq true "Stock level"
+// @Success 200 {object} model.Product
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /products/{id}/stock/{warehouse_id} [put]
+func SetProductStock(r *repo.Database, cache *repo.CacheRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+		whID, err := strconv.ParseUint(c.Param("warehouse_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid warehouse id"})
+			return
+		}
+
+		var in SetStockReq
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updated, change, err := r.SetLocationStock(id, uint(whID), *in.Stock)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "product or warehouse not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Invalidate Cache
+		if cache != nil {
+			_ = cache.InvalidateProduct(c.Request.Context(), updated.ID)
+		}
+
+		if err := publisher.PublishProductUpdated(c.Request.Context(), &updated); err != nil {
+			logger.Error(c.Request.Context(), "failed to publish product.updated: "+err.Error())
+		}
+		publisher.PublishStockAlerts(c.Request.Context(), r, []repo.StockChange{change})
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ProductPrice is one entry of a product's price history.
+// EffectiveTo is nil for an open-ended price.
+type ProductPrice struct {
+	gorm.Model    `swaggerignore:"true"`
+	ProductID     uint       `json:"product_id" gorm:"index;not null" example:"1"`
+	Price         int64      `json:"price" example:"899"`
+	EffectiveFrom time.Time  `json:"effective_from" gorm:"index;not null" example:"2026-01-01T00:00:00Z"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty" gorm:"index" example:"2026-01-08T00:00:00Z"`
+}
@@ -5,8 +5,14 @@ import (
 )
 
 type Product struct {
-	gorm.Model `swaggerignore:"true"`
-	Name       string `json:"name" example:"Smartphone"`
-	Price      int64  `json:"price" example:"999"`
-	Stock      int    `json:"stock" example:"100"`
+	gorm.Model        `swaggerignore:"true"`
+	Name              string  `json:"name" example:"Smartphone"`
+	Price             int64   `json:"price" example:"999"`
+	Category          string  `json:"category" gorm:"size:64;index" example:"phones"`
+	Stock             int     `json:"stock" example:"100"`
+	MaxPerOrder       int     `json:"max_per_order" binding:"min=0" example:"5"`     // most units in one order, 0 = no limit
+	MaxPerCustomer    int     `json:"max_per_customer" binding:"min=0" example:"10"` // most units a customer can hold in orders, 0 = no limit
+	LowStockThreshold int     `json:"low_stock_threshold" example:"5"`               // inventory.low fires when stock drops to or below this
+	RatingAverage     float64 `json:"rating_average" gorm:"default:0" example:"4.5"`
+	RatingCount       int     `json:"rating_count" gorm:"default:0" example:"12"`
 }
@@ -0,0 +1,21 @@
+package model
+
+import "gorm.io/gorm"
+
+// Review moderation statuses
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// Review is a customer's rating of a product they received.
+// Only approved reviews are listed and counted in the product rating.
+type Review struct {
+	gorm.Model `swaggerignore:"true"`
+	ProductID  uint   `json:"product_id" gorm:"uniqueIndex:idx_review_product_user;not null" example:"1"`
+	UserID     uint   `json:"user_id" gorm:"uniqueIndex:idx_review_product_user;not null" example:"1"`
+	Rating     int    `json:"rating" gorm:"not null" example:"5"`
+	Comment    string `json:"comment" example:"Great phone, fast delivery"`
+	Status     string `json:"status" gorm:"size:16;index;not null" example:"approved"`
+}
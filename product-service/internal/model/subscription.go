@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// StockSubscription is a user's request to be emailed when a product is back in stock.
+// It is removed once the notification has been sent.
+type StockSubscription struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ProductID uint      `json:"product_id" gorm:"uniqueIndex:idx_stock_subscription;not null" example:"1"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_stock_subscription;not null" example:"1"`
+	Email     string    `json:"email" gorm:"not null" example:"user@example.com"`
+	CreatedAt time.Time `json:"created_at"`
+}
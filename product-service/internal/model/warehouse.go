@@ -0,0 +1,35 @@
+package model
+
+import "gorm.io/gorm"
+
+// DefaultWarehouseCode is the location holding stock managed through the product API.
+const DefaultWarehouseCode = "default"
+
+// Warehouse is a stock location. Orders are fulfilled from warehouses in the
+// shipping region first, then by ascending Priority.
+type Warehouse struct {
+	gorm.Model `swaggerignore:"true"`
+	Code       string `json:"code" gorm:"size:32;uniqueIndex;not null" example:"hcm-1"`
+	Name       string `json:"name" example:"Ho Chi Minh City #1"`
+	Region     string `json:"region" gorm:"size:32;index" example:"south"`
+	Priority   int    `json:"priority" example:"10"`
+}
+
+// WarehouseStock is the stock of one product at one warehouse.
+// Product.Stock is the sum over all warehouses.
+type WarehouseStock struct {
+	ID          uint `json:"-" gorm:"primaryKey"`
+	ProductID   uint `json:"product_id" gorm:"uniqueIndex:idx_warehouse_stock;not null" example:"1"`
+	WarehouseID uint `json:"warehouse_id" gorm:"uniqueIndex:idx_warehouse_stock;not null" example:"1"`
+	Stock       int  `json:"stock" example:"40"`
+}
+
+// StockReservation records where an order's items were taken from,
+// so a cancellation puts them back at the same warehouse.
+type StockReservation struct {
+	ID          uint   `gorm:"primaryKey"`
+	OrderUUID   string `gorm:"size:36;index;not null"`
+	ProductID   uint   `gorm:"not null"`
+	WarehouseID uint   `gorm:"not null"`
+	Quantity    int    `gorm:"not null"`
+}
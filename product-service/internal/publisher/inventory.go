@@ -0,0 +1,78 @@
+package publisher
+
+import (
+	"context"
+	"log"
+
+	"github.com/phanthehoang2503/small-project/internal/event"
+	"github.com/phanthehoang2503/small-project/internal/message"
+	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
+)
+
+// PublishStockAlerts publishes inventory.low / inventory.restocked for every
+// change that crossed its product's low-stock threshold, and
+// inventory.back_in_stock to the subscribers of every product that was out of
+// stock and no longer is.
+func PublishStockAlerts(ctx context.Context, r *repo.Database, changes []repo.StockChange) {
+	for _, ch := range changes {
+		switch {
+		case ch.BecameLow():
+			msg := message.InventoryLow{
+				ProductID: ch.ProductID,
+				Name:      ch.Name,
+				Stock:     ch.After,
+				Threshold: ch.Threshold,
+			}
+			if err := publishJSON(ctx, event.ExchangeProduct, event.RoutingKeyInventoryLow, msg); err != nil {
+				log.Printf("[product-publisher] failed to publish inventory.low for product %d: %v", ch.ProductID, err)
+			}
+
+		case ch.Restocked():
+			msg := message.InventoryRestocked{
+				ProductID: ch.ProductID,
+				Name:      ch.Name,
+				Stock:     ch.After,
+				Threshold: ch.Threshold,
+			}
+			if err := publishJSON(ctx, event.ExchangeProduct, event.RoutingKeyInventoryRestocked, msg); err != nil {
+				log.Printf("[product-publisher] failed to publish inventory.restocked for product %d: %v", ch.ProductID, err)
+			}
+		}
+
+		if ch.BackInStock() {
+			publishBackInStock(ctx, r, ch)
+		}
+	}
+}
+
+// publishBackInStock takes the product's subscriptions and sends them in
+// inventory.back_in_stock, putting them back if it cannot be published.
+func publishBackInStock(ctx context.Context, r *repo.Database, ch repo.StockChange) {
+	subs, err := r.TakeSubscriptions(ch.ProductID)
+	if err != nil {
+		log.Printf("[product-publisher] failed to load subscriptions for product %d: %v", ch.ProductID, err)
+		return
+	}
+	if len(subs) == 0 {
+		return
+	}
+
+	msg := message.InventoryBackInStock{
+		ProductID: ch.ProductID,
+		Name:      ch.Name,
+		Stock:     ch.After,
+	}
+	for _, s := range subs {
+		msg.Subscribers = append(msg.Subscribers, message.StockSubscriber{
+			UserID: s.UserID,
+			Email:  s.Email,
+		})
+	}
+	if err := publishJSON(ctx, event.ExchangeProduct, event.RoutingKeyInventoryBackInStock, msg); err != nil {
+		log.Printf("[product-publisher] failed to publish inventory.back_in_stock for product %d: %v", ch.ProductID, err)
+		// keep the subscribers for the next restock
+		if err := r.RestoreSubscriptions(subs); err != nil {
+			log.Printf("[product-publisher] failed to keep subscriptions for product %d: %v", ch.ProductID, err)
+		}
+	}
+}
@@ -11,10 +11,13 @@ import (
 
 func PublishProductCreated(ctx context.Context, p *model.Product) error {
 	msg := message.ProductMessage{
-		ID:    p.ID,
-		Name:  p.Name,
-		Price: p.Price,
-		Stock: p.Stock,
+		ID:             p.ID,
+		Name:           p.Name,
+		Price:          p.Price,
+		Stock:          p.Stock,
+		Category:       p.Category,
+		MaxPerOrder:    p.MaxPerOrder,
+		MaxPerCustomer: p.MaxPerCustomer,
 	}
 
 	return publishJSON(ctx, event.ExchangeProduct, event.RoutingKeyProductCreated, msg)
@@ -22,10 +25,13 @@ func PublishProductCreated(ctx context.Context, p *model.Product) error {
 
 func PublishProductUpdated(ctx context.Context, p *model.Product) error {
 	msg := message.ProductMessage{
-		ID:    p.ID,
-		Name:  p.Name,
-		Price: p.Price,
-		Stock: p.Stock,
+		ID:             p.ID,
+		Name:           p.Name,
+		Price:          p.Price,
+		Stock:          p.Stock,
+		Category:       p.Category,
+		MaxPerOrder:    p.MaxPerOrder,
+		MaxPerCustomer: p.MaxPerCustomer,
 	}
 
 	return publishJSON(ctx, event.ExchangeProduct, event.RoutingKeyProductUpdated, msg)
@@ -39,6 +45,20 @@ func PublishProductDeleted(ctx context.Context, id uint) error {
 	return publishJSON(ctx, event.ExchangeProduct, event.RoutingKeyProductDeleted, msg)
 }
 
+func PublishProductRestored(ctx context.Context, p *model.Product) error {
+	msg := message.ProductMessage{
+		ID:             p.ID,
+		Name:           p.Name,
+		Price:          p.Price,
+		Stock:          p.Stock,
+		Category:       p.Category,
+		MaxPerOrder:    p.MaxPerOrder,
+		MaxPerCustomer: p.MaxPerCustomer,
+	}
+
+	return publishJSON(ctx, event.ExchangeProduct, event.RoutingKeyProductRestored, msg)
+}
+
 func publishJSON(ctx context.Context, exchange, rk string, payload any) error {
 	return broker.Global.PublishJSON(ctx, exchange, rk, payload)
 }
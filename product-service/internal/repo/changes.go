@@ -0,0 +1,37 @@
+package repo
+
+import (
+	"time"
+)
+
+// ProductChange is a product as seen by consumers keeping a copy of the
+// catalog, including soft-deleted products.
+type ProductChange struct {
+	ID             uint      `json:"id" example:"1"`
+	Name           string    `json:"name" example:"Smartphone"`
+	Price          int64     `json:"price" example:"999"`
+	Stock          int       `json:"stock" example:"100"`
+	Category       string    `json:"category" example:"phones"`
+	MaxPerOrder    int       `json:"max_per_order" example:"5"`
+	MaxPerCustomer int       `json:"max_per_customer" example:"10"`
+	Deleted        bool      `json:"deleted" example:"false"`
+	ModifiedAt     time.Time `json:"modified_at"`
+}
+
+// ListChanged returns products modified after the (since, afterID) cursor,
+// oldest first. A delete counts as a modification.
+func (d *Database) ListChanged(since time.Time, afterID uint, limit int) ([]ProductChange, error) {
+	var changes []ProductChange
+	err := d.DB.Raw(`
+		SELECT * FROM (
+			SELECT id, name, price, stock, category, max_per_order, max_per_customer,
+				deleted_at IS NOT NULL AS deleted,
+				GREATEST(updated_at, COALESCE(deleted_at, updated_at)) AS modified_at
+			FROM products
+		) p
+		WHERE (p.modified_at, p.id) > (?, ?)
+		ORDER BY p.modified_at, p.id
+		LIMIT ?`, since, afterID, limit).
+		Scan(&changes).Error
+	return changes, err
+}
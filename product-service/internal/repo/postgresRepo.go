@@ -1,10 +1,13 @@
 package repo
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/phanthehoang2503/small-project/product-service/internal/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Database struct {
@@ -16,7 +19,16 @@ func NewRepo(db *gorm.DB) *Database {
 }
 
 func (d *Database) Create(p model.Product) (model.Product, error) {
-	if err := d.DB.Create(&p).Error; err != nil {
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&p).Error; err != nil {
+			return err
+		}
+		if err := adjustDefaultStock(tx, p.ID, p.Stock); err != nil {
+			return err
+		}
+		return recordPrice(tx, p.ID, p.Price, p.CreatedAt.UTC())
+	})
+	if err != nil {
 		return model.Product{}, err
 	}
 	return p, nil
@@ -39,19 +51,45 @@ func (d *Database) Get(id int64) (model.Product, error) {
 	return p, nil
 }
 
-func (d *Database) Update(id int64, newData model.Product) (model.Product, error) {
+func (d *Database) Update(id int64, newData model.Product) (model.Product, StockChange, error) {
 	var exist model.Product
 	if err := d.DB.First(&exist, id).Error; err != nil {
-		return model.Product{}, err
+		return model.Product{}, StockChange{}, err
 	}
 
+	old := exist
+	priceChanged := exist.Price != newData.Price
 	exist.Name = newData.Name
+	exist.Category = newData.Category
+	exist.MaxPerOrder = newData.MaxPerOrder
+	exist.MaxPerCustomer = newData.MaxPerCustomer
 	exist.Price = newData.Price
 	exist.Stock = newData.Stock
-	if err := d.DB.Save(&exist).Error; err != nil {
-		return model.Product{}, err
+	exist.LowStockThreshold = newData.LowStockThreshold
+
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&exist).Error; err != nil {
+			return err
+		}
+		// manual stock changes apply to the default warehouse
+		if delta := exist.Stock - old.Stock; delta != 0 {
+			if err := adjustDefaultStock(tx, exist.ID, delta); err != nil {
+				return err
+			}
+		}
+		if !priceChanged {
+			return nil
+		}
+		// keep the old price in the history before switching
+		if err := ensureBasePrice(tx, old); err != nil {
+			return err
+		}
+		return recordPrice(tx, exist.ID, exist.Price, time.Now().UTC())
+	})
+	if err != nil {
+		return model.Product{}, StockChange{}, err
 	}
-	return exist, nil
+	return exist, newStockChange(exist, old.Stock), nil
 }
 
 func (d *Database) Delete(id int64) error {
@@ -65,13 +103,73 @@ func (d *Database) Delete(id int64) error {
 	return nil
 }
 
+// ListDeleted returns soft-deleted products, most recently deleted first.
+func (d *Database) ListDeleted() ([]model.Product, error) {
+	var products []model.Product
+	if err := d.DB.Unscoped().
+		Where("deleted_at IS NOT NULL").
+		Order("deleted_at DESC").
+		Find(&products).Error; err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// Restore undoes a soft delete. Returns gorm.ErrRecordNotFound if the
+// product does not exist or is not deleted.
+func (d *Database) Restore(id int64) (model.Product, error) {
+	res := d.DB.Unscoped().Model(&model.Product{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if res.Error != nil {
+		return model.Product{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return model.Product{}, gorm.ErrRecordNotFound
+	}
+	return d.Get(id)
+}
+
 type StockItem struct {
 	ProductID uint
 	Quantity  int
 }
 
-func (d *Database) BatchDeductStock(items []StockItem) error {
-	return d.DB.Transaction(func(tx *gorm.DB) error {
+// BatchDeductStock reserves all items for an order or none. Stock is taken
+// from warehouses chosen by allocate and recorded per order for restocking.
+func (d *Database) BatchDeductStock(orderUUID, region string, items []StockItem) ([]StockChange, []Allocation, error) {
+	items = mergeItems(items)
+
+	var changes []StockChange
+	var allocs []Allocation
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		allocs, err = allocate(tx, region, items)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range allocs {
+			res := tx.Model(&model.WarehouseStock{}).
+				Where("product_id = ? AND warehouse_id = ? AND stock >= ?", a.ProductID, a.WarehouseID, a.Quantity).
+				Update("stock", gorm.Expr("stock - ?", a.Quantity))
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return fmt.Errorf("insufficient stock for product %d", a.ProductID)
+			}
+
+			if err := tx.Create(&model.StockReservation{
+				OrderUUID:   orderUUID,
+				ProductID:   a.ProductID,
+				WarehouseID: a.WarehouseID,
+				Quantity:    a.Quantity,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
 		for _, item := range items {
 			res := tx.Model(&model.Product{}).
 				Where("id = ? AND stock >= ?", item.ProductID, item.Quantity).
@@ -83,22 +181,66 @@ func (d *Database) BatchDeductStock(items []StockItem) error {
 			if res.RowsAffected == 0 {
 				return fmt.Errorf("insufficient stock for product %d", item.ProductID)
 			}
+
+			var p model.Product
+			if err := tx.First(&p, item.ProductID).Error; err != nil {
+				return err
+			}
+			changes = append(changes, newStockChange(p, p.Stock+item.Quantity))
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, allocs, nil
 }
 
-func (d *Database) BatchRestock(items []StockItem) error {
-	return d.DB.Transaction(func(tx *gorm.DB) error {
-		for _, item := range items {
-			res := tx.Model(&model.Product{}).
+// BatchRestock puts an order's reserved stock back at the warehouses it was
+// taken from. The reservation is consumed, so a repeated cancellation is a no-op.
+func (d *Database) BatchRestock(orderUUID string) ([]StockChange, error) {
+	var changes []StockChange
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		var reserved []model.StockReservation
+		if err := tx.Clauses(clause.Returning{}).
+			Where("order_uuid = ?", orderUUID).
+			Delete(&reserved).Error; err != nil {
+			return err
+		}
+
+		var items []StockItem
+		for _, r := range reserved {
+			if err := addLocationStock(tx, r.ProductID, r.WarehouseID, r.Quantity); err != nil {
+				return err
+			}
+			items = append(items, StockItem{ProductID: r.ProductID, Quantity: r.Quantity})
+		}
+
+		for _, item := range mergeItems(items) {
+			res := tx.Unscoped().Model(&model.Product{}).
 				Where("id = ?", item.ProductID).
 				Update("stock", gorm.Expr("stock + ?", item.Quantity))
 
 			if res.Error != nil {
 				return res.Error
 			}
+			if res.RowsAffected == 0 {
+				continue
+			}
+
+			var p model.Product
+			if err := tx.First(&p, item.ProductID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue // deleted meanwhile
+				}
+				return err
+			}
+			changes = append(changes, newStockChange(p, p.Stock-item.Quantity))
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
 }
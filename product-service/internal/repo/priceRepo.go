@@ -0,0 +1,170 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/product-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidPriceWindow = errors.New("effective_to must be after effective_from")
+
+// recordPrice opens a new price of the product at `from`, see openPrice.
+func recordPrice(tx *gorm.DB, productID uint, price int64, from time.Time) error {
+	return openPrice(tx, &model.ProductPrice{
+		ProductID:     productID,
+		Price:         price,
+		EffectiveFrom: from,
+	})
+}
+
+// openPrice stores pp, a price without an end, so that the history stays a
+// sequence of ranges: the open-ended price it replaces ends at pp's start,
+// and pp ends where a later scheduled price without an end starts, if any.
+// Sales (prices with an end) are left alone; the price under them comes back
+// when they end.
+func openPrice(tx *gorm.DB, pp *model.ProductPrice) error {
+	if err := tx.Model(&model.ProductPrice{}).
+		Where("product_id = ? AND effective_to IS NULL AND effective_from <= ?", pp.ProductID, pp.EffectiveFrom).
+		Update("effective_to", pp.EffectiveFrom).Error; err != nil {
+		return err
+	}
+
+	var next model.ProductPrice
+	err := tx.Where("product_id = ? AND effective_to IS NULL AND effective_from > ?", pp.ProductID, pp.EffectiveFrom).
+		Order("effective_from").
+		First(&next).Error
+	if err == nil {
+		to := next.EffectiveFrom
+		pp.EffectiveTo = &to
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return tx.Create(pp).Error
+}
+
+// ClosePriceRanges ends open-ended prices that a later open-ended price
+// replaced, which SchedulePrice left open before it closed them.
+func ClosePriceRanges(db *gorm.DB) error {
+	return db.Exec(`
+		UPDATE product_prices p SET effective_to = (
+			SELECT min(n.effective_from) FROM product_prices n
+			WHERE n.product_id = p.product_id AND n.deleted_at IS NULL
+			  AND n.effective_to IS NULL AND n.effective_from > p.effective_from)
+		WHERE p.deleted_at IS NULL AND p.effective_to IS NULL AND EXISTS (
+			SELECT 1 FROM product_prices n
+			WHERE n.product_id = p.product_id AND n.deleted_at IS NULL
+			  AND n.effective_to IS NULL AND n.effective_from > p.effective_from)`).Error
+}
+
+// ensureBasePrice seeds the history with the product's current price for
+// products created before price history existed.
+func ensureBasePrice(tx *gorm.DB, p model.Product) error {
+	var count int64
+	if err := tx.Model(&model.ProductPrice{}).Where("product_id = ?", p.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	return tx.Create(&model.ProductPrice{
+		ProductID:     p.ID,
+		Price:         p.Price,
+		EffectiveFrom: p.CreatedAt.UTC(),
+	}).Error
+}
+
+// SchedulePrice stores a future price for a product. A nil `to` keeps the
+// price until the next change, closing the current open-ended range at
+// `from`; otherwise the previous price comes back at `to`.
+func (d *Database) SchedulePrice(productID int64, price int64, from time.Time, to *time.Time) (model.ProductPrice, error) {
+	if to != nil && !to.After(from) {
+		return model.ProductPrice{}, ErrInvalidPriceWindow
+	}
+
+	pp := model.ProductPrice{
+		ProductID:     uint(productID),
+		Price:         price,
+		EffectiveFrom: from.UTC(),
+	}
+	if to != nil {
+		utc := to.UTC()
+		pp.EffectiveTo = &utc
+	}
+
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		// one price change of the product at a time, so ranges do not overlap
+		var p model.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, productID).Error; err != nil {
+			return err
+		}
+		if err := ensureBasePrice(tx, p); err != nil {
+			return err
+		}
+		if pp.EffectiveTo == nil {
+			return openPrice(tx, &pp)
+		}
+		return tx.Create(&pp).Error
+	})
+	if err != nil {
+		return model.ProductPrice{}, err
+	}
+	return pp, nil
+}
+
+// PriceHistory returns every recorded and scheduled price of a product, newest first.
+func (d *Database) PriceHistory(productID int64) ([]model.ProductPrice, error) {
+	if _, err := d.Get(productID); err != nil {
+		return nil, err
+	}
+
+	var prices []model.ProductPrice
+	if err := d.DB.Where("product_id = ?", productID).
+		Order("effective_from DESC, id DESC").
+		Find(&prices).Error; err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// ApplyDuePrices sets each product's price to the one effective at `now`
+// and returns the products whose price changed.
+func (d *Database) ApplyDuePrices(now time.Time) ([]model.Product, error) {
+	// latest effective entry per product
+	var due []model.ProductPrice
+	if err := d.DB.Raw(`
+		SELECT DISTINCT ON (product_id) *
+		FROM product_prices
+		WHERE deleted_at IS NULL
+		  AND effective_from <= ?
+		  AND (effective_to IS NULL OR effective_to > ?)
+		ORDER BY product_id, effective_from DESC, id DESC`, now, now).
+		Scan(&due).Error; err != nil {
+		return nil, err
+	}
+
+	var changed []model.Product
+	for _, pp := range due {
+		var p model.Product
+		err := d.DB.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&model.Product{}).
+				Where("id = ? AND price <> ?", pp.ProductID, pp.Price).
+				Update("price", pp.Price)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			// the product as this update left it, for product.updated
+			return tx.First(&p, pp.ProductID).Error
+		})
+		if err != nil {
+			return changed, err
+		}
+		if p.ID != 0 {
+			changed = append(changed, p)
+		}
+	}
+	return changed, nil
+}
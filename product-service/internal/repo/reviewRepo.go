@@ -0,0 +1,94 @@
+package repo
+
+import (
+	"errors"
+
+	"github.com/phanthehoang2503/small-project/product-service/internal/model"
+	"gorm.io/gorm"
+)
+
+var ErrAlreadyReviewed = errors.New("product already reviewed by this user")
+
+// CreateReview stores a pending review. A user can review a product once.
+func (d *Database) CreateReview(r model.Review) (model.Review, error) {
+	if _, err := d.Get(int64(r.ProductID)); err != nil {
+		return model.Review{}, err
+	}
+
+	var count int64
+	if err := d.DB.Model(&model.Review{}).
+		Where("product_id = ? AND user_id = ?", r.ProductID, r.UserID).
+		Count(&count).Error; err != nil {
+		return model.Review{}, err
+	}
+	if count > 0 {
+		return model.Review{}, ErrAlreadyReviewed
+	}
+
+	r.Status = model.ReviewPending
+	if err := d.DB.Create(&r).Error; err != nil {
+		return model.Review{}, err
+	}
+	return r, nil
+}
+
+// ListReviews returns one page of approved reviews for a product, newest first, and the total count.
+func (d *Database) ListReviews(productID int64, page, pageSize int) ([]model.Review, int64, error) {
+	q := d.DB.Model(&model.Review{}).
+		Where("product_id = ? AND status = ?", productID, model.ReviewApproved)
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var reviews []model.Review
+	if err := q.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&reviews).Error; err != nil {
+		return nil, 0, err
+	}
+	return reviews, total, nil
+}
+
+// ModerateReview sets a review's status and refreshes the product's rating aggregate.
+func (d *Database) ModerateReview(productID int64, reviewID uint, status string) (model.Review, error) {
+	var r model.Review
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND product_id = ?", reviewID, productID).First(&r).Error; err != nil {
+			return err
+		}
+
+		r.Status = status
+		if err := tx.Save(&r).Error; err != nil {
+			return err
+		}
+		return refreshRating(tx, r.ProductID)
+	})
+	if err != nil {
+		return model.Review{}, err
+	}
+	return r, nil
+}
+
+// refreshRating recomputes rating_average and rating_count from approved reviews.
+func refreshRating(tx *gorm.DB, productID uint) error {
+	var agg struct {
+		Average float64
+		Count   int
+	}
+	if err := tx.Model(&model.Review{}).
+		Select("COALESCE(AVG(rating), 0) AS average, COUNT(*) AS count").
+		Where("product_id = ? AND status = ?", productID, model.ReviewApproved).
+		Scan(&agg).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&model.Product{}).
+		Where("id = ?", productID).
+		Updates(map[string]interface{}{
+			"rating_average": agg.Average,
+			"rating_count":   agg.Count,
+		}).Error
+}
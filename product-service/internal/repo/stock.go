@@ -0,0 +1,115 @@
+package repo
+
+import (
+	"errors"
+
+	"github.com/phanthehoang2503/small-project/product-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInStock is returned when subscribing to a product that can be bought
+var ErrInStock = errors.New("product is in stock")
+
+// StockChange describes a product's stock level before and after an update.
+type StockChange struct {
+	ProductID uint
+	Name      string
+	Before    int
+	After     int
+	Threshold int
+}
+
+func newStockChange(p model.Product, before int) StockChange {
+	return StockChange{
+		ProductID: p.ID,
+		Name:      p.Name,
+		Before:    before,
+		After:     p.Stock,
+		Threshold: p.LowStockThreshold,
+	}
+}
+
+// limit is the stock level at or below which a product counts as low.
+func (s StockChange) limit() int {
+	if s.Threshold < 0 {
+		return 0
+	}
+	return s.Threshold
+}
+
+// BecameLow reports whether stock dropped to or below the threshold.
+func (s StockChange) BecameLow() bool {
+	return s.Before > s.limit() && s.After <= s.limit()
+}
+
+// Restocked reports whether stock rose back above the threshold.
+func (s StockChange) Restocked() bool {
+	return s.Before <= s.limit() && s.After > s.limit()
+}
+
+// BackInStock reports whether stock rose from none to some, which is when
+// back-in-stock subscribers are notified.
+func (s StockChange) BackInStock() bool {
+	return s.Before <= 0 && s.After > 0
+}
+
+// Subscribe registers (or refreshes) a back-in-stock subscription. Only out of
+// stock products take subscriptions (ErrInStock otherwise). The product row
+// is share-locked so a concurrent restock either sees the subscription or
+// runs before the stock check.
+func (d *Database) Subscribe(productID int64, userID uint, email string) (model.StockSubscription, error) {
+	sub := model.StockSubscription{
+		ProductID: uint(productID),
+		UserID:    userID,
+		Email:     email,
+	}
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		var p model.Product
+		if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).First(&p, productID).Error; err != nil {
+			return err
+		}
+		if p.Stock > 0 {
+			return ErrInStock
+		}
+		return saveSubscription(tx, &sub)
+	})
+	if err != nil {
+		return model.StockSubscription{}, err
+	}
+	return sub, nil
+}
+
+// RestoreSubscriptions puts back subscriptions taken by TakeSubscriptions
+// whose notification could not be sent.
+func (d *Database) RestoreSubscriptions(subs []model.StockSubscription) error {
+	for i := range subs {
+		subs[i].ID = 0
+		if err := saveSubscription(d.DB, &subs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveSubscription(db *gorm.DB, sub *model.StockSubscription) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email"}),
+	}).Create(sub).Error
+}
+
+func (d *Database) Unsubscribe(productID int64, userID uint) error {
+	return d.DB.Where("product_id = ? AND user_id = ?", productID, userID).
+		Delete(&model.StockSubscription{}).Error
+}
+
+// TakeSubscriptions removes and returns every subscription of a product,
+// so each subscriber is notified only once.
+func (d *Database) TakeSubscriptions(productID uint) ([]model.StockSubscription, error) {
+	var subs []model.StockSubscription
+	err := d.DB.Clauses(clause.Returning{}).
+		Where("product_id = ?", productID).
+		Delete(&subs).Error
+	return subs, err
+}
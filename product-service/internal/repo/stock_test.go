@@ -0,0 +1,34 @@
+package repo
+
+import "testing"
+
+func TestStockChangeAlerts(t *testing.T) {
+	tests := []struct {
+		name                              string
+		before, after, threshold          int
+		becameLow, restocked, backInStock bool
+	}{
+		{"sold out", 3, 0, 5, false, false, false},
+		{"drops to threshold", 8, 5, 5, true, false, false},
+		{"back under threshold", 0, 4, 5, false, false, true},
+		{"back over threshold", 0, 20, 5, false, true, true},
+		{"over threshold from low", 2, 9, 5, false, true, false},
+		{"oversold then restocked", -2, 1, 0, false, true, true},
+		{"no threshold", 1, 0, -1, true, false, false},
+		{"stays in stock", 4, 3, 0, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := StockChange{Before: tt.before, After: tt.after, Threshold: tt.threshold}
+			if got := ch.BecameLow(); got != tt.becameLow {
+				t.Errorf("BecameLow = %v, want %v", got, tt.becameLow)
+			}
+			if got := ch.Restocked(); got != tt.restocked {
+				t.Errorf("Restocked = %v, want %v", got, tt.restocked)
+			}
+			if got := ch.BackInStock(); got != tt.backInStock {
+				t.Errorf("BackInStock = %v, want %v", got, tt.backInStock)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/phanthehoang2503/small-project/product-service/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrDefaultStockTooLow = errors.New("not enough stock at the default warehouse for this change")
+
+// Allocation is the quantity of a product taken from one warehouse for an order.
+type Allocation struct {
+	ProductID     uint
+	WarehouseID   uint
+	WarehouseCode string
+	Quantity      int
+}
+
+// LocationStock is a product's stock at one warehouse.
+type LocationStock struct {
+	WarehouseID uint   `json:"warehouse_id" example:"1"`
+	Code        string `json:"code" example:"hcm-1"`
+	Name        string `json:"name" example:"Ho Chi Minh City #1"`
+	Region      string `json:"region" example:"south"`
+	Stock       int    `json:"stock" example:"40"`
+}
+
+// EnsureDefaultWarehouse creates the default warehouse and moves the stock of
+// products that have no per-location stock yet into it.
+func (d *Database) EnsureDefaultWarehouse() error {
+	wh := model.Warehouse{
+		Code:     model.DefaultWarehouseCode,
+		Name:     "Default warehouse",
+		Priority: 1000,
+	}
+	if err := d.DB.Where("code = ?", wh.Code).FirstOrCreate(&wh).Error; err != nil {
+		return err
+	}
+
+	return d.DB.Exec(`
+		INSERT INTO warehouse_stocks (product_id, warehouse_id, stock)
+		SELECT p.id, ?, p.stock
+		FROM products p
+		WHERE NOT EXISTS (SELECT 1 FROM warehouse_stocks ws WHERE ws.product_id = p.id)`, wh.ID).Error
+}
+
+func (d *Database) CreateWarehouse(w model.Warehouse) (model.Warehouse, error) {
+	if err := d.DB.Create(&w).Error; err != nil {
+		return model.Warehouse{}, err
+	}
+	return w, nil
+}
+
+func (d *Database) ListWarehouses() ([]model.Warehouse, error) {
+	var whs []model.Warehouse
+	if err := d.DB.Order("priority ASC, id ASC").Find(&whs).Error; err != nil {
+		return nil, err
+	}
+	return whs, nil
+}
+
+// ProductStock returns a product's stock per warehouse.
+func (d *Database) ProductStock(productID int64) ([]LocationStock, error) {
+	if _, err := d.Get(productID); err != nil {
+		return nil, err
+	}
+
+	var levels []LocationStock
+	err := d.DB.Table("warehouse_stocks ws").
+		Select("w.id AS warehouse_id, w.code, w.name, w.region, ws.stock").
+		Joins("JOIN warehouses w ON w.id = ws.warehouse_id AND w.deleted_at IS NULL").
+		Where("ws.product_id = ?", productID).
+		Order("w.priority ASC, w.id ASC").
+		Scan(&levels).Error
+	return levels, err
+}
+
+// SetLocationStock sets a product's stock at one warehouse and keeps the
+// product total in sync.
+func (d *Database) SetLocationStock(productID int64, warehouseID uint, stock int) (model.Product, StockChange, error) {
+	var p model.Product
+	var before int
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, productID).Error; err != nil {
+			return err
+		}
+		var wh model.Warehouse
+		if err := tx.First(&wh, warehouseID).Error; err != nil {
+			return err
+		}
+
+		var current model.WarehouseStock
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("product_id = ? AND warehouse_id = ?", p.ID, wh.ID).
+			First(&current).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		delta := stock - current.Stock
+		if err := addLocationStock(tx, p.ID, wh.ID, delta); err != nil {
+			return err
+		}
+
+		before = p.Stock
+		p.Stock += delta
+		return tx.Model(&model.Product{}).Where("id = ?", p.ID).Update("stock", p.Stock).Error
+	})
+	if err != nil {
+		return model.Product{}, StockChange{}, err
+	}
+	return p, newStockChange(p, before), nil
+}
+
+// addLocationStock adds delta (may be negative) to a product's stock at a warehouse.
+func addLocationStock(tx *gorm.DB, productID, warehouseID uint, delta int) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product_id"}, {Name: "warehouse_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"stock": gorm.Expr("warehouse_stocks.stock + ?", delta)}),
+	}).Create(&model.WarehouseStock{
+		ProductID:   productID,
+		WarehouseID: warehouseID,
+		Stock:       delta,
+	}).Error
+}
+
+// adjustDefaultStock applies a manual stock change to the default warehouse.
+func adjustDefaultStock(tx *gorm.DB, productID uint, delta int) error {
+	var wh model.Warehouse
+	if err := tx.Where("code = ?", model.DefaultWarehouseCode).First(&wh).Error; err != nil {
+		return err
+	}
+
+	if delta < 0 {
+		res := tx.Model(&model.WarehouseStock{}).
+			Where("product_id = ? AND warehouse_id = ? AND stock >= ?", productID, wh.ID, -delta).
+			Update("stock", gorm.Expr("stock + ?", delta))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrDefaultStockTooLow
+		}
+		return nil
+	}
+	return addLocationStock(tx, productID, wh.ID, delta)
+}
+
+// allocate picks warehouses for the items: a single warehouse that can ship
+// everything if there is one, otherwise items are split across warehouses.
+// Warehouses in the shipping region come first, then by priority.
+func allocate(tx *gorm.DB, region string, items []StockItem) ([]Allocation, error) {
+	var whs []model.Warehouse
+	if err := tx.Order(clause.OrderBy{Expression: clause.Expr{SQL: "CASE WHEN region = ? THEN 0 ELSE 1 END, priority ASC, id ASC", Vars: []interface{}{region}}}).
+		Find(&whs).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.ProductID)
+	}
+	var stocks []model.WarehouseStock
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id IN ?", ids).
+		Find(&stocks).Error; err != nil {
+		return nil, err
+	}
+	available := make(map[uint]map[uint]int) // product -> warehouse -> stock
+	for _, s := range stocks {
+		if available[s.ProductID] == nil {
+			available[s.ProductID] = make(map[uint]int)
+		}
+		available[s.ProductID][s.WarehouseID] = s.Stock
+	}
+
+	// 1. single warehouse for the whole order
+	for _, wh := range whs {
+		fits := true
+		for _, it := range items {
+			if available[it.ProductID][wh.ID] < it.Quantity {
+				fits = false
+				break
+			}
+		}
+		if !fits {
+			continue
+		}
+		allocs := make([]Allocation, 0, len(items))
+		for _, it := range items {
+			allocs = append(allocs, Allocation{
+				ProductID:     it.ProductID,
+				WarehouseID:   wh.ID,
+				WarehouseCode: wh.Code,
+				Quantity:      it.Quantity,
+			})
+		}
+		return allocs, nil
+	}
+
+	// 2. split each item over warehouses in order
+	var allocs []Allocation
+	for _, it := range items {
+		remaining := it.Quantity
+		for _, wh := range whs {
+			if remaining == 0 {
+				break
+			}
+			take := min(remaining, available[it.ProductID][wh.ID])
+			if take <= 0 {
+				continue
+			}
+			allocs = append(allocs, Allocation{
+				ProductID:     it.ProductID,
+				WarehouseID:   wh.ID,
+				WarehouseCode: wh.Code,
+				Quantity:      take,
+			})
+			available[it.ProductID][wh.ID] -= take
+			remaining -= take
+		}
+		if remaining > 0 {
+			return nil, fmt.Errorf("insufficient stock for product %d", it.ProductID)
+		}
+	}
+	return allocs, nil
+}
+
+// mergeItems sums quantities of repeated products, keeping first-seen order.
+func mergeItems(items []StockItem) []StockItem {
+	idx := make(map[uint]int)
+	var merged []StockItem
+	for _, it := range items {
+		if i, ok := idx[it.ProductID]; ok {
+			merged[i].Quantity += it.Quantity
+			continue
+		}
+		idx[it.ProductID] = len(merged)
+		merged = append(merged, it)
+	}
+	return merged
+}
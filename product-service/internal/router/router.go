@@ -2,20 +2,58 @@ package router
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/phanthehoang2503/small-project/internal/middleware"
 	"github.com/phanthehoang2503/small-project/product-service/internal/handler"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 
 	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
 )
 
-func RegisterRoutes(r *gin.Engine, s *repo.Database, cache *repo.CacheRepository) {
+func RegisterRoutes(r *gin.Engine, s *repo.Database, cache *repo.CacheRepository, verifier *middleware.Verifier) {
 	r.Use(otelgin.Middleware("product-service"))
 	api := r.Group("/products")
 	{
 		api.GET("", handler.ListProducts(s))
+		api.GET("/changes", handler.ListProductChanges(s))
 		api.GET("/:id", handler.GetProducts(s, cache))
-		api.POST("", handler.CreateProducts(s))
-		api.PUT("/:id", handler.UpdateProducts(s, cache))
-		api.DELETE("/:id", handler.DeleteProducts(s, cache))
+		api.GET("/:id/prices", handler.ListPriceHistory(s))
+		api.GET("/:id/reviews", handler.ListReviews(s))
+		api.GET("/:id/stock", handler.GetProductStock(s))
+	}
+
+	// catalog management
+	catalog := r.Group("/products")
+	catalog.Use(middleware.JWTMiddleware(verifier), middleware.RequirePermission(middleware.PermCatalogWrite))
+	{
+		catalog.GET("/deleted", handler.ListDeletedProducts(s))
+		catalog.POST("", handler.CreateProducts(s))
+		catalog.PUT("/:id", handler.UpdateProducts(s, cache))
+		catalog.DELETE("/:id", handler.DeleteProducts(s, cache))
+		catalog.POST("/:id/restore", handler.RestoreProducts(s, cache))
+
+		// scheduled prices
+		catalog.POST("/:id/prices", handler.SchedulePrice(s))
+
+		// stock per warehouse
+		catalog.PUT("/:id/stock/:warehouse_id", handler.SetProductStock(s, cache))
+	}
+
+	// review moderation
+	moderation := r.Group("/products/:id/reviews")
+	moderation.Use(middleware.JWTMiddleware(verifier), middleware.RequirePermission(middleware.PermReviewsModerate))
+	{
+		moderation.PUT("/:review_id/status", handler.ModerateReview(s, cache))
+	}
+
+	r.GET("/warehouses", handler.ListWarehouses(s))
+	r.POST("/warehouses", middleware.JWTMiddleware(verifier), middleware.RequirePermission(middleware.PermCatalogWrite), handler.CreateWarehouse(s))
+
+	// logged-in users
+	user := r.Group("/products/:id")
+	user.Use(middleware.JWTMiddleware(verifier), middleware.RequireUserToken())
+	{
+		user.POST("/subscriptions", handler.SubscribeBackInStock(s))
+		user.DELETE("/subscriptions", handler.UnsubscribeBackInStock(s))
+		user.POST("/reviews", handler.CreateReview(s))
 	}
 }
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/phanthehoang2503/small-project/internal/middleware"
+)
+
+// The admin routes must be refused before reaching a handler, so the test
+// registers them without a database or cache.
+func TestAdminRoutesNeedPermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := middleware.GenerateSigningKey(middleware.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := middleware.NewSigner([]middleware.SigningKey{key}, "", "auth-service", "small-project")
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+	token := func(roles ...string) string {
+		tok, _, err := signer.Issue(7, roles, "", time.Minute)
+		if err != nil {
+			t.Fatalf("issue token: %v", err)
+		}
+		return "Bearer " + tok
+	}
+
+	r := gin.New()
+	RegisterRoutes(r, nil, nil, middleware.NewLocalVerifier(signer))
+
+	routes := []struct {
+		method, path string
+		// a role without the route's permission
+		lacking string
+	}{
+		{http.MethodGet, "/products/deleted", middleware.RoleSupport},
+		{http.MethodPost, "/products/1/restore", middleware.RoleSupport},
+		{http.MethodPost, "/products", middleware.RoleSupport},
+		{http.MethodPut, "/products/1", middleware.RoleSupport},
+		{http.MethodDelete, "/products/1", middleware.RoleSupport},
+		{http.MethodPost, "/products/1/prices", middleware.RoleSupport},
+		{http.MethodPut, "/products/1/stock/1", middleware.RoleSupport},
+		{http.MethodPost, "/warehouses", middleware.RoleSupport},
+		{http.MethodPut, "/products/1/reviews/1/status", middleware.RoleCustomer},
+	}
+	for _, rt := range routes {
+		t.Run(rt.method+" "+rt.path, func(t *testing.T) {
+			for _, c := range []struct {
+				auth string
+				want int
+			}{
+				{"", http.StatusUnauthorized},
+				{token(middleware.RoleCustomer), http.StatusForbidden},
+				{token(rt.lacking), http.StatusForbidden},
+			} {
+				req := httptest.NewRequest(rt.method, rt.path, nil)
+				if c.auth != "" {
+					req.Header.Set("Authorization", c.auth)
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != c.want {
+					t.Errorf("status = %d, want %d (with token: %t)", w.Code, c.want, c.auth != "")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/phanthehoang2503/small-project/product-service/internal/publisher"
+	"github.com/phanthehoang2503/small-project/product-service/internal/repo"
+)
+
+// PriceScheduler activates scheduled prices once they become effective.
+type PriceScheduler struct {
+	repo     *repo.Database
+	cache    *repo.CacheRepository
+	interval time.Duration
+}
+
+func NewPriceScheduler(r *repo.Database, c *repo.CacheRepository, interval time.Duration) *PriceScheduler {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &PriceScheduler{
+		repo:     r,
+		cache:    c,
+		interval: interval,
+	}
+}
+
+// Start runs the scheduler in the background until ctx is cancelled.
+func (s *PriceScheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		log.Printf("[price-scheduler] started, interval=%s", s.interval)
+		s.run(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("[price-scheduler] stopped")
+				return
+			case <-ticker.C:
+				s.run(ctx)
+			}
+		}
+	}()
+}
+
+func (s *PriceScheduler) run(ctx context.Context) {
+	changed, err := s.repo.ApplyDuePrices(time.Now().UTC())
+	if err != nil {
+		log.Printf("[price-scheduler] failed to apply prices: %v", err)
+	}
+
+	for i := range changed {
+		p := &changed[i]
+		if s.cache != nil {
+			_ = s.cache.InvalidateProduct(ctx, p.ID)
+		}
+		if err := publisher.PublishProductUpdated(ctx, p); err != nil {
+			log.Printf("[price-scheduler] failed to publish product.updated for product %d: %v", p.ID, err)
+			continue
+		}
+		log.Printf("[price-scheduler] product %d price changed to %d", p.ID, p.Price)
+	}
+}